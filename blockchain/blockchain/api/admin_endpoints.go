@@ -0,0 +1,111 @@
+package api
+
+import (
+	db_types "IS/blockchain/database_utils/types"
+	"IS/utils"
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func ListUsers(c *gin.Context) {
+	users, err := GetUsers(context.Background())
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func SetUserRole(c *gin.Context) {
+	Request := SetRoleRequest{}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+
+	applyRole(c, Request)
+}
+
+func applyRole(c *gin.Context, Request SetRoleRequest) {
+	if !db_types.IsKnownRole(Request.Role) {
+		RespondError(c, UnknownRoleError.WithDetails(map[string]interface{}{"role": Request.Role}))
+		return
+	}
+
+	if err := SetRole(context.Background(), Request.Username, Request.Role); err != nil {
+		RespondError(c, err)
+		return
+	}
+	Audit(AuditRoleChanged, GetUserFromContext(c).Username, map[string]string{
+		"username": Request.Username,
+		"role":     Request.Role,
+	})
+
+	c.JSON(http.StatusOK, "ok")
+}
+
+func FreezeAddress(c *gin.Context) {
+	processFreeze(c, true)
+}
+
+func UnfreezeAddress(c *gin.Context) {
+	processFreeze(c, false)
+}
+
+func processFreeze(c *gin.Context, freeze bool) {
+	Request := FreezeRequest{ResponseCh: make(chan error)}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+	Request.Freeze = freeze
+
+	respondFreeze(c, Request)
+}
+
+func respondFreeze(c *gin.Context, Request FreezeRequest) {
+	FreezeCh <- Request
+	if err := <-Request.ResponseCh; err != nil {
+		RespondError(c, err)
+		return
+	}
+
+	eventType := AuditAddressUnfrozen
+	if Request.Freeze {
+		eventType = AuditAddressFrozen
+	}
+	Audit(eventType, GetUserFromContext(c).Username, map[string]string{"address": utils.ToHex(Request.Address[:])})
+
+	c.JSON(http.StatusOK, "ok")
+}
+
+func GetMempool(c *gin.Context) {
+	Request := GetMempoolRequest{ResponseCh: make(chan Mempool)}
+
+	GetMempoolCh <- Request
+	c.JSON(http.StatusOK, <-Request.ResponseCh)
+}
+
+func GetAuditLog(c *gin.Context) {
+	Request := GetAuditLogRequest{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&Request); err != nil {
+			RespondError(c, InvalidBodyError)
+			return
+		}
+	}
+
+	respondAuditLog(c, Request)
+}
+
+func respondAuditLog(c *gin.Context, Request GetAuditLogRequest) {
+	events, err := GetAuditEvents(Request)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
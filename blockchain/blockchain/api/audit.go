@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	"sync"
+	"time"
+)
+
+const ( // audit event types
+	AuditLogin             = "login"
+	AuditLoginFailed       = "login_failed"
+	AuditRegistration      = "registration"
+	AuditTelegramLink      = "telegram_link"
+	AuditRoleChanged       = "role_changed"
+	AuditAddressFrozen     = "address_frozen"
+	AuditAddressUnfrozen   = "address_unfrozen"
+	AuditReadAccessGranted = "read_access_granted"
+	AuditReadAccessRevoked = "read_access_revoked"
+	AuditTwoFactorChanged  = "two_factor_changed"
+	AuditOracleValueSet    = "oracle_value_set"
+)
+
+type (
+	// AuditEvent is a record of hash chained audit log, every event commits to the previous one
+	AuditEvent struct {
+		Seq       int64             `bson:"seq" json:"seq"`
+		Timestamp int64             `bson:"timestamp" json:"timestamp"` // unix
+		Type      string            `bson:"type" json:"type"`
+		Actor     string            `bson:"actor" json:"actor"`
+		Details   map[string]string `bson:"details,omitempty" json:"details,omitempty"`
+		PrevHash  Hash              `bson:"prevHash" json:"prevHash"`
+		Hash      Hash              `bson:"hash" json:"hash"`
+	}
+	AuditEvents []*AuditEvent
+
+	// AuditAnchor is a trusted event preceding an exported part of the log, e.g. the last
+	// event of the previously verified export
+	AuditAnchor struct {
+		Seq  int64
+		Hash Hash
+	}
+
+	GetAuditLogRequest struct {
+		Type    string `json:"type,omitempty"`
+		Actor   string `json:"actor,omitempty"`
+		FromSeq *int64 `json:"fromSeq,omitempty"`
+		ToSeq   *int64 `json:"toSeq,omitempty"`
+	}
+)
+
+var (
+	auditMu        sync.Mutex
+	lastAuditEvent *AuditEvent
+)
+
+func (e *AuditEvent) ComputeHash() Hash {
+	cpy := *e
+	cpy.Hash = Hash{}
+
+	JSON, _ := json.Marshal(cpy)
+	return rlpHash(JSON)
+}
+
+// ChainAuditEvent links the event to the previous one (nil for the first event)
+func ChainAuditEvent(prev *AuditEvent, event AuditEvent) AuditEvent {
+	if prev != nil {
+		event.Seq = prev.Seq + 1
+		event.PrevHash = prev.Hash
+	} else {
+		event.Seq = 0
+		event.PrevHash = Hash{}
+	}
+	event.Hash = event.ComputeHash()
+	return event
+}
+
+// VerifyAuditChain checks the events sorted by seq and returns the first inconsistency. Without
+// anchor the events must start with seq=0, otherwise they must follow the anchor. Filtered
+// exports have gaps in seq and don't verify
+func VerifyAuditChain(events AuditEvents, anchor *AuditAnchor) error {
+	for i, event := range events {
+		if event.ComputeHash() != event.Hash {
+			return fmt.Errorf("audit event seq=%d: hash mismatch", event.Seq)
+		}
+		if i == 0 {
+			if anchor == nil {
+				if event.Seq != 0 {
+					return fmt.Errorf("audit event seq=%d: expected seq=0, the log is truncated or needs an anchor", event.Seq)
+				}
+				if event.PrevHash != (Hash{}) {
+					return fmt.Errorf("audit event seq=0: non empty parent hash")
+				}
+				continue
+			}
+			if event.Seq != anchor.Seq+1 {
+				return fmt.Errorf("audit event seq=%d: expected seq=%d after the anchor", event.Seq, anchor.Seq+1)
+			}
+			if event.PrevHash != anchor.Hash {
+				return fmt.Errorf("audit event seq=%d: parent hash doesn't match the anchor", event.Seq)
+			}
+			continue
+		}
+
+		prev := events[i-1]
+		if event.Seq != prev.Seq+1 {
+			return fmt.Errorf("audit event seq=%d: expected seq=%d, event was removed", event.Seq, prev.Seq+1)
+		}
+		if event.PrevHash != prev.Hash {
+			return fmt.Errorf("audit event seq=%d: parent hash mismatch", event.Seq)
+		}
+	}
+	return nil
+}
+
+// Audit appends event to the audit log, failures are logged only
+func Audit(eventType, actor string, details map[string]string) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if lastAuditEvent == nil {
+		last, err := GetLastAuditEvent()
+		if err == nil {
+			lastAuditEvent = last
+		}
+	}
+
+	event := ChainAuditEvent(lastAuditEvent, AuditEvent{
+		Timestamp: time.Now().Unix(),
+		Type:      eventType,
+		Actor:     actor,
+		Details:   details,
+	})
+
+	if err := WriteAuditEvent(&event); err != nil {
+		log.Error("can't write audit event", "type", eventType, "err", err)
+		// the tail may have moved, e.g. duplicate seq, it is read again by the next event
+		lastAuditEvent = nil
+		return
+	}
+	lastAuditEvent = &event
+	Events.Publish(Event{Type: EventAudit, Audit: &event})
+}
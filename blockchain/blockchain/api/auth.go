@@ -0,0 +1,84 @@
+package api
+
+import (
+	db_types "IS/blockchain/database_utils/types"
+	"context"
+	"github.com/gin-gonic/gin"
+	"sync"
+	"time"
+)
+
+const (
+	userContextKey = "user"
+
+	// basic auth checks credentials on every request, a login is audited once per interval
+	LoginAuditInterval = time.Hour
+)
+
+var (
+	loginAuditMu   sync.Mutex
+	lastLoginAudit = make(map[string]time.Time) // by username
+)
+
+// Authorize checks HTTP basic auth credentials and lets the request through
+// only if the user has one of the given roles (any role if none given).
+func Authorize(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, password, ok := c.Request.BasicAuth()
+		if !ok {
+			AbortWithError(c, UnauthorizedError)
+			return
+		}
+
+		user, err := authenticate(c.Request.Context(), username, password)
+		if err != nil {
+			AbortWithError(c, UnauthorizedError)
+			return
+		}
+
+		if len(roles) != 0 && !user.HasRole(roles...) {
+			AbortWithError(c, ForbiddenError.WithDetails(map[string]interface{}{"roles": roles}))
+			return
+		}
+
+		c.Set(userContextKey, user)
+		c.Next()
+	}
+}
+
+func GetUserFromContext(c *gin.Context) *db_types.User {
+	user, ok := c.Get(userContextKey)
+	if !ok {
+		return nil
+	}
+	return user.(*db_types.User)
+}
+
+func authenticate(ctx context.Context, username, password string) (*db_types.User, error) {
+	user, err := GetUserByUsername(ctx, username)
+	if err != nil {
+		Audit(AuditLoginFailed, username, map[string]string{"reason": "unknown user"})
+		return nil, UnauthorizedError
+	}
+
+	if ok, err := VerifyPassword(ctx, *user, password); err != nil || !ok {
+		Audit(AuditLoginFailed, username, map[string]string{"reason": "invalid password"})
+		return nil, UnauthorizedError
+	}
+
+	if shouldAuditLogin(username, time.Now()) {
+		Audit(AuditLogin, username, nil)
+	}
+	return user, nil
+}
+
+// shouldAuditLogin reports whether the successful login starts a new audit interval of the user
+func shouldAuditLogin(username string, now time.Time) bool {
+	loginAuditMu.Lock()
+	defer loginAuditMu.Unlock()
+	if last, ok := lastLoginAudit[username]; ok && now.Sub(last) < LoginAuditInterval {
+		return false
+	}
+	lastLoginAudit[username] = now
+	return true
+}
@@ -1,7 +1,10 @@
 package api
 
 import (
+	"IS/blockchain/config"
 	"IS/utils"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/log"
@@ -15,6 +18,13 @@ var (
 	SaveFutureTxCh      = make(chan SendTxBcRequest, 1)
 	GetBalanceCh        = make(chan GetBalanceRequest, 1)
 	GetTxsWithFiltersCh = make(chan GetTransactionsWithFiltersRequest, 1)
+	FreezeCh            = make(chan FreezeRequest, 1)
+	GetMempoolCh        = make(chan GetMempoolRequest, 1)
+	CancelTxCh          = make(chan CancelTxRequest, 1)
+	ReplaceTxCh         = make(chan ReplaceTxRequest, 1)
+	SimulateTxCh        = make(chan SimulateTxRequest, 1)
+	GetBlockCh          = make(chan GetBlockRequest, 1)
+	GetTxByHashCh       = make(chan GetTxByHashRequest, 1)
 )
 
 func (bc *BlockChain) GetBlockByHash(hash *Hash) *Block {
@@ -80,16 +90,44 @@ func (bc *BlockChain) processTx(req SendTxBcRequest) {
 	defer close(req.ResponseCh)
 
 	tx := req.Tx
-	if !tx.IsValid(bc) {
-		req.ResponseCh <- fmt.Errorf("invalid transaction")
+	if err := tx.Validate(bc, bc.projectedBalance); err != nil {
+		req.ResponseCh <- err
 		return
 	}
 
+	tx.ID = bc.globalTxID
+	evicted, err := bc.txQueue.Add(&tx)
+	if err != nil {
+		req.ResponseCh <- err
+		return
+	}
+	// acknowledged transaction must survive restart
+	if err = WritePendingTx(&tx); err != nil {
+		log.Error("can't write pending tx", "err", err)
+		// the evicted tx is still stored, it stays pending
+		bc.txQueue.Revert(&tx, evicted)
+		req.ResponseCh <- StorageError
+		return
+	}
+	bc.globalTxID++
+	if evicted != nil {
+		bc.dropPendingTxs(Transactions{evicted}, "evicted")
+	}
+
+	publishTxStatus(&tx, TxStatusPending, "")
+	if req.HashCh != nil {
+		req.HashCh <- tx.GetHash()
+	}
 	req.ResponseCh <- nil
-	bc.txQueue.transactions = append(bc.txQueue.transactions, &tx)
 }
 
 func (bc *BlockChain) processEpoch() {
+	if bc.pendingBlock != nil {
+		// it is written again by finalizeRetry
+		return
+	}
+	bc.dropPendingTxs(bc.txQueue.EvictExpired(time.Now()), "expired")
+
 	if bc.txQueue.IsEmpty() && len(bc.futureTransactions) == 0 {
 		log.Info("No transactions to finalize")
 		return
@@ -102,77 +140,272 @@ func (bc *BlockChain) processEpoch() {
 		ParentHash:   bc.lastFinalizedBlock.GetHash(),
 		Transactions: make(Transactions, 0, BlockTxsLimit),
 	}
-	block.GetHash()
 
-	block.Transactions = append(append(block.Transactions, bc.txQueue.GetMaxCountAndRemove()...), bc.getTransactionsToFinalize()...)
+	block.Transactions = append(block.Transactions, bc.withoutFrozen(bc.txQueue.GetMaxCountAndRemove())...)
+	block.Transactions = append(block.Transactions, bc.executeDueTxs(block.Transactions)...)
 	if len(block.Transactions) == 0 {
+		// only skipped occurrences, there is no block to wait for
+		bc.saveScheduleProgress()
 		log.Info("No transactions to finalize")
 		return
 	}
 	bc.finalizeBlock(block)
 }
 
-func (bc *BlockChain) getTransactionsToFinalize() (res Transactions) {
-	now := time.Now().Unix()
-	for i := 0; i < len(bc.futureTransactions); i++ {
-		tx := bc.futureTransactions[i]
-		if tx.Condition.SendAfterBlock != nil && *tx.Condition.SendAfterBlock != bc.lastFinalizedNumber {
+// withoutFrozen drops pending txs involving addresses frozen after the txs were accepted
+func (bc *BlockChain) withoutFrozen(txs Transactions) Transactions {
+	res := txs[:0]
+	for _, tx := range txs {
+		if bc.involvesFrozen(tx) {
+			bc.dropPendingTxs(Transactions{tx}, FrozenAddressError.Code)
 			continue
 		}
-		if tx.Condition.SendAfterTimestamp != nil && *tx.Condition.SendAfterTimestamp > now {
+		res = append(res, tx)
+	}
+	return res
+}
+
+func (bc *BlockChain) dropPendingTxs(txs Transactions, reason string) {
+	for _, tx := range txs {
+		log.Info("pending tx dropped", "id", tx.ID, "reason", reason)
+		publishTxStatus(tx, TxStatusDropped, reason)
+		if err := DeletePendingTxByID(tx.ID); err != nil {
+			log.Warn("can't delete dropped pending tx", "id", tx.ID, "err", err)
+		}
+	}
+}
+
+// executeDueTxs returns scheduled txs and occurrences of recurring txs whose condition
+// is met and which are valid after pending txs of the block, the invalid ones are dropped
+// as failed unless the schedule skips or retries them
+func (bc *BlockChain) executeDueTxs(pending Transactions) (res Transactions) {
+	state := bc.conditionState()
+	due := bc.getTransactionsToFinalize(state)
+	if len(due) == 0 {
+		return nil
+	}
+
+	balances := make(map[Address]Value_)
+	if stateInter, ok := bc.stateCache.Get(bc.lastFinalizedNumber); ok {
+		balances = utils.Copy(stateInter.(State).Balances)
+	}
+	for _, tx := range pending {
+		applyTx(balances, tx)
+	}
+	balanceOf := func(addr Address) Value_ {
+		return balances[addr]
+	}
+
+	for _, d := range due {
+		tx := d.tx
+		if err := tx.Validate(bc, balanceOf); err != nil {
+			log.Info("scheduled tx failed", "id", tx.ID, "err", err)
+			if d.recurring != nil && errors.Is(err, InsufficientFundsError) {
+				switch d.recurring.Schedule.OnInsufficientFunds {
+				case OnInsufficientFundsRetry:
+					if d.recurring.Schedule.Retries(state.Now) {
+						continue
+					}
+					// the end passed while retrying, the skip completes the schedule
+					fallthrough
+				case OnInsufficientFundsSkip, "":
+					publishTxStatus(tx, TxStatusFailed, ToAPIError(err).Code)
+					bc.advanceRecurring(d.recurring, false, state)
+					continue
+				}
+			}
+			if d.recurring != nil {
+				bc.removeFutureTx(d.recurring.ID)
+				tx = d.recurring
+			}
+			if deleteErr := DeleteTransactionByID(tx.ID); deleteErr != nil {
+				log.Warn("can't delete failed future tx", "id", tx.ID, "err", deleteErr)
+			}
+			publishTxStatus(tx, TxStatusFailed, ToAPIError(err).Code)
 			continue
 		}
-		switch tx.Condition.Type {
-		case TimeCond:
-			res = append(res, tx)
-			bc.futureTransactions = append(bc.futureTransactions[:i], bc.futureTransactions[i+1:]...)
-			i--
-		case AccountBalanceMoreThen:
-			if val, err := bc.getBalance(bc.lastFinalizedNumber, tx.Condition.CondAccount.Address); err == nil &&
-				val.GreeterThen(tx.Condition.CondValue) {
-				res = append(res, tx)
-				bc.futureTransactions = append(bc.futureTransactions[:i], bc.futureTransactions[i+1:]...)
-				i--
+		if d.recurring != nil {
+			bc.advanceRecurring(d.recurring, true, state)
+		}
+		applyTx(balances, tx)
+		res = append(res, tx)
+	}
+	return res
+}
+
+func (bc *BlockChain) conditionState() *ConditionState {
+	return &ConditionState{
+		Now:                 time.Now().Unix(),
+		LastFinalizedNumber: bc.lastFinalizedNumber,
+		LastFinalizedBlock:  bc.lastFinalizedBlock,
+		Balance: func(addr Address) (Value_, bool) {
+			val, err := bc.getBalance(bc.lastFinalizedNumber, addr)
+			if err != nil {
+				return Value_{}, false
 			}
-		case AccountBalanceLessThen:
-			if val, err := bc.getBalance(bc.lastFinalizedNumber, tx.Condition.CondAccount.Address); err == nil &&
-				val.LessThen(tx.Condition.CondValue) {
-				res = append(res, tx)
+			return *val, true
+		},
+	}
+}
+
+// getTransactionsToFinalize removes due one-off txs from the future ones, recurring txs
+// stay and their occurrences are returned
+func (bc *BlockChain) getTransactionsToFinalize(state *ConditionState) (res []dueTx) {
+	for i := 0; i < len(bc.futureTransactions); i++ {
+		tx := bc.futureTransactions[i]
+		state.Owner = txSender(tx)
+
+		if tx.Schedule == nil {
+			if tx.Condition.Met(state) {
+				res = append(res, dueTx{tx: tx})
 				bc.futureTransactions = append(bc.futureTransactions[:i], bc.futureTransactions[i+1:]...)
 				i--
 			}
-		case AccountSentTransaction:
-			for _, finTx := range bc.lastFinalizedBlock.Transactions {
-				if finTx.From.Address == tx.Condition.CondAccount.Address {
-					res = append(res, tx)
-					bc.futureTransactions = append(bc.futureTransactions[:i], bc.futureTransactions[i+1:]...)
-					i--
-				}
-			}
+			continue
+		}
+
+		if tx.Schedule.finished(state.Now) {
+			bc.completeRecurring(tx)
+			i--
+			continue
+		}
+		if tx.Schedule.due(state) && (tx.Condition == nil || tx.Condition.Met(state)) {
+			res = append(res, dueTx{tx: bc.occurrenceOf(tx), recurring: tx})
 		}
 	}
 
 	return res
 }
 
-func (bc *BlockChain) finalizeBlock(block *Block) {
-	err := WriteBlock(block)
+// occurrenceOf copies the recurring tx, the copy's schedule tells which occurrence it is
+func (bc *BlockChain) occurrenceOf(tx *Transaction) *Transaction {
+	occurrence := *tx
+	schedule := *tx.Schedule
+	schedule.Of = tx.ID
+	occurrence.Schedule = &schedule
+	occurrence.ID = bc.globalTxID
+	bc.globalTxID++
+	return &occurrence
+}
+
+// advanceRecurring replaces the recurring tx with the one planning the next occurrence,
+// the tx isn't changed in place as mempool snapshots share it. The progress is saved
+// after the block with the occurrence is written
+func (bc *BlockChain) advanceRecurring(tx *Transaction, executed bool, state *ConditionState) {
+	next := *tx
+	schedule := *tx.Schedule
+	schedule.advance(executed, state)
+	next.Schedule = &schedule
+
+	index := bc.indexOfFutureTx(tx.ID)
+	if index < 0 {
+		return
+	}
+	bc.futureTransactions[index] = &next
+	finished := schedule.finished(state.Now)
+	if finished {
+		bc.removeFutureTx(next.ID)
+	}
+	bc.scheduleProgress = append(bc.scheduleProgress, scheduleProgress{tx: &next, finished: finished})
+}
+
+// saveScheduleProgress stores schedules advanced for the written block, a block which is never
+// written leaves the stored schedules to repeat its occurrences after a restart. Progress which
+// isn't saved is caught up from the chain by restoreMempool
+func (bc *BlockChain) saveScheduleProgress() {
+	for _, progress := range bc.scheduleProgress {
+		tx := progress.tx
+		if progress.finished {
+			if err := DeleteTransactionByID(tx.ID); err != nil {
+				log.Warn("can't delete completed recurring tx", "id", tx.ID, "err", err)
+			}
+			publishTxStatus(tx, TxStatusCompleted, "")
+			continue
+		}
+		// cancelled or replaced while the block was written
+		if index := bc.indexOfFutureTx(tx.ID); index < 0 || bc.futureTransactions[index] != tx {
+			continue
+		}
+		if err := UpdateFutureTx(tx); err != nil {
+			log.Error("can't update recurring tx", "id", tx.ID, "err", err)
+		}
+	}
+	bc.scheduleProgress = nil
+}
+
+func (bc *BlockChain) completeRecurring(tx *Transaction) {
+	bc.removeFutureTx(tx.ID)
+	if err := DeleteTransactionByID(tx.ID); err != nil {
+		log.Warn("can't delete completed recurring tx", "id", tx.ID, "err", err)
+	}
+	publishTxStatus(tx, TxStatusCompleted, "")
+}
+
+func (bc *BlockChain) indexOfFutureTx(ID int64) int {
+	for i, tx := range bc.futureTransactions {
+		if tx.ID == ID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (bc *BlockChain) removeFutureTx(ID int64) {
+	if index := bc.indexOfFutureTx(ID); index >= 0 {
+		bc.futureTransactions = append(bc.futureTransactions[:index], bc.futureTransactions[index+1:]...)
+	}
+}
+
+// finalizeBlock writes the block and only then applies it to the memory state, if
+// the write fails the block is retried on the next epoch and block production is
+// halted until it is written. The chain loop never sleeps waiting for storage
+func (bc *BlockChain) finalizeBlock(block *Block) bool {
+	if err := WriteBlock(block); err != nil {
+		bc.finalizeFailures++
+		log.Error("can't finalize block", "number", block.Number, "attempt", bc.finalizeFailures, "err", err)
+		bc.pendingBlock = block
+		bc.finalizeRetry = time.After(finalizeBackoff(bc.finalizeFailures))
+		setHealth(HealthHalted, fmt.Sprintf("can't write block %d: %v", block.Number, err))
+		return false
+	}
+	bc.finalizeFailures = 0
+	bc.pendingBlock = nil
+	bc.finalizeRetry = nil
+	setHealth(HealthOK, "")
 
 	bc.lastFinalizedNumber = block.Number
 	bc.lastFinalizedBlock = block
+	bc.indexBlock(block)
 
 	if state, ok := bc.stateCache.Get(block.Number - 1); ok {
 		bc.writeStateUsingLastState(state.(State), *block)
 	}
 
-	if err != nil {
-		log.Error("can't finalize block")
-		return
+	// the block is the commit point, leftovers are dropped on the next start
+	for _, tx := range block.Transactions {
+		if err := DeleteTransactionByID(tx.ID); err != nil {
+			log.Warn("can't delete finalized future tx", "id", tx.ID, "err", err)
+		}
+		if err := DeletePendingTxByID(tx.ID); err != nil {
+			log.Warn("can't delete finalized pending tx", "id", tx.ID, "err", err)
+		}
 	}
+	bc.saveScheduleProgress()
+	// subscribers see the block after its state is queryable
+	publishBlock(block)
+	return true
+}
 
-	for _, tx := range block.Transactions {
-		_ = DeleteTransactionByID(tx.ID)
+// finalizeBackoff doubles with each failed write up to MaxFinalizeRetryBackoff
+func finalizeBackoff(failures int) time.Duration {
+	backoff := FinalizeRetryBackoff
+	for i := 1; i < failures && backoff < MaxFinalizeRetryBackoff; i++ {
+		backoff *= 2
 	}
+	if backoff > MaxFinalizeRetryBackoff {
+		return MaxFinalizeRetryBackoff
+	}
+	return backoff
 }
 
 func (bc *BlockChain) writeStateUsingLastState(lastState State, block Block) {
@@ -184,23 +417,7 @@ func (bc *BlockChain) writeStateUsingLastState(lastState State, block Block) {
 		Balances:            utils.Copy(lastState.Balances),
 	}
 	for _, tx := range block.Transactions {
-		deltas := tx.GetBalanceDelta()
-		for addr, delta := range deltas {
-			if _, ok := newState.Balances[addr]; !ok {
-				newState.Balances[addr] = delta
-				continue
-			}
-
-			value_ := newState.Balances[addr]
-			if delta.Integer < 0 {
-				delta.Integer *= -1
-				value_ = value_.Minus(&delta)
-			} else {
-				value_ = value_.Plus(&delta)
-			}
-
-			newState.Balances[addr] = value_
-		}
+		applyTx(newState.Balances, tx)
 	}
 	bc.stateCache.Add(newState.LastFinalizedNumber, newState)
 	if bc.lastFinalizedNumber < block.Number {
@@ -209,10 +426,26 @@ func (bc *BlockChain) writeStateUsingLastState(lastState State, block Block) {
 	}
 }
 
+func applyTx(balances map[Address]Value_, tx *Transaction) {
+	deltas := tx.GetBalanceDelta()
+	for addr, delta := range deltas {
+		value_ := balances[addr]
+		balances[addr] = ValueFromCents(value_.Cents() + delta.Cents())
+	}
+
+	if fee := tx.GetFee(); tx.From != nil && fee.Cents() > 0 {
+		treasury := balances[feePolicy.Treasury]
+		balances[feePolicy.Treasury] = ValueFromCents(treasury.Cents() + fee.Cents())
+	}
+}
+
 func (bc *BlockChain) processBalanceRequest(req GetBalanceRequest) {
 	defer close(req.ResponseCh)
 	respCh := req.ResponseCh
 
+	if req.BlockNumber == LatestBlock {
+		req.BlockNumber = bc.lastFinalizedNumber
+	}
 	res, err := bc.getBalance(req.BlockNumber, req.Address)
 	if err != nil {
 		respCh <- GetBalanceBCResponse{Err: err}
@@ -236,19 +469,20 @@ func (bc *BlockChain) getBalance(blockNumber utils.BlockNumber, addr Address) (*
 	}
 }
 
-func (bc *BlockChain) loadStates() {
+func (bc *BlockChain) loadStates() Blocks {
 	blocks := GetBlocks()
 	sort.Slice(blocks, func(i, j int) bool {
 		return blocks[i].Number < blocks[j].Number
 	})
 
 	if len(blocks) == 0 {
-		return
+		return blocks
 	}
 
 	bc.writeStateUsingLastState(State{Balances: make(map[Address]Value_), LastFinalizedNumber: -1}, *blocks[0])
 
 	for i, block := range blocks {
+		bc.indexBlock(block)
 		if i == 0 {
 			continue
 		}
@@ -256,6 +490,71 @@ func (bc *BlockChain) loadStates() {
 		state := stateInter.(State)
 		bc.writeStateUsingLastState(state, *block)
 	}
+	return blocks
+}
+
+// restoreMempool loads pending and future transactions saved before restart, the ones
+// that were finalized but not removed from storage are dropped. Recurring txs whose stored
+// progress is behind their last finalized occurrence are caught up, so it isn't paid twice
+func (bc *BlockChain) restoreMempool(blocks Blocks) {
+	finalized := make(map[Hash]struct{})
+	lastOccurrences := make(map[int64]*Schedule) // by recurring tx ID
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			finalized[tx.GetHash()] = struct{}{}
+			bc.bumpGlobalTxID(tx.ID)
+			if tx.Schedule != nil && tx.Schedule.Of != 0 {
+				if last, ok := lastOccurrences[tx.Schedule.Of]; !ok || last.Number() < tx.Schedule.Number() {
+					lastOccurrences[tx.Schedule.Of] = tx.Schedule
+				}
+			}
+		}
+	}
+	state := bc.conditionState()
+	futureTransactions := make(Transactions, 0, len(bc.futureTransactions))
+	for _, tx := range bc.futureTransactions {
+		bc.bumpGlobalTxID(tx.ID)
+		if _, ok := finalized[tx.GetHash()]; ok {
+			_ = DeleteTransactionByID(tx.ID)
+			continue
+		}
+		if last, ok := lastOccurrences[tx.ID]; ok && tx.Schedule != nil && tx.Schedule.CatchUp(last, state) {
+			log.Warn("recurring tx caught up with its finalized occurrence", "id", tx.ID, "occurrence", last.Number())
+			if tx.Schedule.finished(state.Now) {
+				if err := DeleteTransactionByID(tx.ID); err != nil {
+					log.Warn("can't delete completed recurring tx", "id", tx.ID, "err", err)
+				}
+				publishTxStatus(tx, TxStatusCompleted, "")
+				continue
+			}
+			if err := UpdateFutureTx(tx); err != nil {
+				log.Error("can't update recurring tx", "id", tx.ID, "err", err)
+			}
+		}
+		futureTransactions = append(futureTransactions, tx)
+	}
+	bc.futureTransactions = futureTransactions
+
+	for _, tx := range GetPendingTxs() {
+		bc.bumpGlobalTxID(tx.ID)
+		if _, ok := finalized[tx.GetHash()]; ok {
+			_ = DeletePendingTxByID(tx.ID)
+			continue
+		}
+		evicted, err := bc.txQueue.Add(tx)
+		if err != nil {
+			bc.dropPendingTxs(Transactions{tx}, err.Error())
+		} else if evicted != nil {
+			bc.dropPendingTxs(Transactions{evicted}, "evicted")
+		}
+	}
+	log.Info("mempool restored", "pending", bc.txQueue.Len())
+}
+
+func (bc *BlockChain) bumpGlobalTxID(ID int64) {
+	if ID >= bc.globalTxID {
+		bc.globalTxID = ID + 1
+	}
 }
 
 func (bc *BlockChain) getTransactionsUsingFilters(req GetTransactionsWithFiltersRequest) {
@@ -266,6 +565,9 @@ func (bc *BlockChain) getTransactionsUsingFilters(req GetTransactionsWithFilters
 
 		exit := false
 		for _, tx := range block.Transactions {
+			if req.AllowedAddresses != nil && !tx.involvesAnyOf(req.AllowedAddresses) {
+				continue
+			}
 			if req.TxTypes != nil {
 				if ok, _ := utils.Contains(Any, req.TxTypes); ok {
 					req.TxTypes = nil
@@ -307,51 +609,381 @@ func (bc *BlockChain) getTransactionsUsingFilters(req GetTransactionsWithFilters
 }
 
 func (bc *BlockChain) processFutureTxRequest(req SendTxBcRequest) {
-	if req.Tx.Condition == nil {
-		req.ResponseCh <- errors.New("missing required parameter: condition")
+	if err := bc.prepareFutureTx(&req.Tx); err != nil {
+		req.ResponseCh <- err
+		return
+	}
+
+	// the ID isn't reused even if the write fails, the tx may be stored anyway
+	req.Tx.ID = bc.globalTxID
+	bc.globalTxID++
+	// acknowledged transaction must survive restart
+	if err := WriteFutureTx(&req.Tx); err != nil {
+		log.Error("can't write future tx", "err", err)
+		req.ResponseCh <- StorageError
+		return
+	}
+	bc.futureTransactions = append(bc.futureTransactions, &req.Tx)
+
+	publishTxStatus(&req.Tx, TxStatusScheduled, "")
+	if req.HashCh != nil {
+		req.HashCh <- req.Tx.GetHash()
+	}
+	req.ResponseCh <- nil
+}
+
+func validateCondition(cond *Filter) error {
+	if cond == nil {
+		return CondMissingError
+	}
+	return cond.Validate()
+}
+
+// validateScheduled checks the condition and the schedule, recurring tx may have no condition
+func validateScheduled(tx *Transaction) error {
+	if tx.Schedule == nil {
+		return validateCondition(tx.Condition)
+	}
+	if err := tx.Schedule.Validate(); err != nil {
+		return err
+	}
+	if tx.Condition != nil {
+		return tx.Condition.Validate()
+	}
+	return nil
+}
+
+// prepareFutureTx validates tx and plans the first occurrence of its schedule
+func (bc *BlockChain) prepareFutureTx(tx *Transaction) error {
+	if err := validateScheduled(tx); err != nil {
+		return err
+	}
+	if tx.Schedule != nil && !tx.Schedule.start(time.Now(), bc.lastFinalizedNumber) {
+		return InvalidScheduleError.WithDetails(map[string]interface{}{"reason": "schedule has no occurrences"})
+	}
+	return nil
+}
+
+func (bc *BlockChain) processCancelTxRequest(req CancelTxRequest) {
+	defer close(req.ResponseCh)
+
+	if index := indexOfOwnTx(bc.txQueue.transactions, req.ID, req.Owner); index >= 0 {
+		tx, _ := bc.txQueue.Remove(req.ID)
+		if err := DeletePendingTxByID(tx.ID); err != nil {
+			log.Warn("can't delete cancelled pending tx", "id", tx.ID, "err", err)
+		}
+		publishTxStatus(tx, TxStatusCancelled, "")
+		req.ResponseCh <- nil
 		return
 	}
 
-	switch req.Tx.Condition.Type {
-	case AccountBalanceMoreThen, AccountBalanceLessThen:
-		if req.Tx.Condition.CondAccount == nil {
-			req.ResponseCh <- errors.New("missing required parameter: CondAccount")
+	if index := indexOfOwnTx(bc.futureTransactions, req.ID, req.Owner); index >= 0 {
+		publishTxStatus(bc.futureTransactions[index], TxStatusCancelled, "")
+		bc.futureTransactions = append(bc.futureTransactions[:index], bc.futureTransactions[index+1:]...)
+		if err := DeleteTransactionByID(req.ID); err != nil {
+			log.Warn("can't delete cancelled future tx", "id", req.ID, "err", err)
+		}
+		req.ResponseCh <- nil
+		return
+	}
+
+	req.ResponseCh <- UnknownPendingTxError
+}
+
+func (bc *BlockChain) processReplaceTxRequest(req ReplaceTxRequest) {
+	defer close(req.ResponseCh)
+
+	newTx := req.Tx
+	newTx.ID = req.ID
+
+	if index := indexOfOwnTx(bc.txQueue.transactions, req.ID, req.Owner); index >= 0 {
+		if newTx.IsScheduled() {
+			req.ResponseCh <- InvalidReplaceError.WithDetails(map[string]interface{}{"reason": "pending transaction can't become conditional"})
+			return
+		}
+		oldTx, _ := bc.txQueue.Remove(req.ID)
+		if err := newTx.Validate(bc, bc.projectedBalance); err != nil {
+			_, _ = bc.txQueue.Add(oldTx)
+			req.ResponseCh <- err
 			return
-		} else if req.Tx.Condition.CondValue == nil {
-			req.ResponseCh <- errors.New("missing required parameter: CondValue")
+		}
+		evicted, err := bc.txQueue.Add(&newTx)
+		if err != nil {
+			_, _ = bc.txQueue.Add(oldTx)
+			req.ResponseCh <- err
 			return
 		}
-	case AccountSentTransaction:
-		if req.Tx.Condition.CondAccount == nil {
-			req.ResponseCh <- errors.New("missing required parameter: CondAccount")
+		// the record is replaced in place, the old tx is never lost
+		if err = ReplacePendingTx(&newTx); err != nil {
+			log.Error("can't replace pending tx", "id", req.ID, "err", err)
+			bc.txQueue.Revert(&newTx, evicted)
+			_, _ = bc.txQueue.Add(oldTx)
+			req.ResponseCh <- StorageError
 			return
 		}
-	case TimeCond:
-		//nothing
-	default:
-		req.ResponseCh <- errors.New("unknown cond type")
+		publishTxStatus(oldTx, TxStatusReplaced, "")
+		publishTxStatus(&newTx, TxStatusPending, "")
+		if req.HashCh != nil {
+			req.HashCh <- newTx.GetHash()
+		}
+		req.ResponseCh <- nil
+		return
 	}
 
-	req.Tx.ID = bc.globalTxID
-	bc.globalTxID++
-	bc.futureTransactions = append(bc.futureTransactions, &req.Tx)
-	err := WriteFutureTx(&req.Tx)
-	if err != nil {
-		log.Error("can't write future tx")
+	if index := indexOfOwnTx(bc.futureTransactions, req.ID, req.Owner); index >= 0 {
+		if err := bc.prepareFutureTx(&newTx); err != nil {
+			req.ResponseCh <- err
+			return
+		}
+		if err := UpdateFutureTx(&newTx); err != nil {
+			log.Error("can't replace future tx", "id", req.ID, "err", err)
+			req.ResponseCh <- StorageError
+			return
+		}
+		publishTxStatus(bc.futureTransactions[index], TxStatusReplaced, "")
+		publishTxStatus(&newTx, TxStatusScheduled, "")
+		bc.futureTransactions[index] = &newTx
+		if req.HashCh != nil {
+			req.HashCh <- newTx.GetHash()
+		}
+		req.ResponseCh <- nil
+		return
+	}
+
+	req.ResponseCh <- UnknownPendingTxError
+}
+
+// processSimulateTxRequest validates tx against the same balances processTx does
+func (bc *BlockChain) processSimulateTxRequest(req SimulateTxRequest) {
+	defer close(req.ResponseCh)
+
+	tx := req.Tx
+	if tx.IsScheduled() {
+		if err := validateScheduled(&tx); err != nil {
+			req.ResponseCh <- SimulateTxResponse{Error: ToAPIError(err)}
+			return
+		}
+	}
+	req.ResponseCh <- tx.Simulate(bc, bc.projectedBalance)
+}
+
+// Simulate validates tx against balanceOf and reports the balances it changes
+func (tx *Transaction) Simulate(bc *BlockChain, balanceOf func(Address) Value_) SimulateTxResponse {
+	if err := tx.Validate(bc, balanceOf); err != nil {
+		return SimulateTxResponse{Error: ToAPIError(err)}
+	}
+
+	before := make(map[Address]Value_)
+	for addr := range tx.GetBalanceDelta() {
+		before[addr] = balanceOf(addr)
+	}
+	if fee := tx.GetFee(); tx.From != nil && fee.Cents() > 0 {
+		before[feePolicy.Treasury] = balanceOf(feePolicy.Treasury)
+	}
+	after := utils.Copy(before)
+	applyTx(after, tx)
+
+	balances := make([]ProjectedBalance, 0, len(after))
+	for addr, value := range after {
+		balances = append(balances, ProjectedBalance{Address: addr, Before: before[addr], After: value})
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return bytes.Compare(balances[i].Address[:], balances[j].Address[:]) < 0
+	})
+	return SimulateTxResponse{Valid: true, Balances: balances}
+}
+
+// projectedBalance is the basis new txs are accepted against: the head state with the
+// unwritten block and the mempool applied, so pending txs can't spend the same funds
+func (bc *BlockChain) projectedBalance(addr Address) Value_ {
+	var unwritten Transactions
+	if bc.pendingBlock != nil {
+		unwritten = bc.pendingBlock.Transactions
+	}
+	return PendingBalance(bc.headStateBalance, unwritten, bc.txQueue.transactions)(addr)
+}
+
+// PendingBalance returns balances of head with the pending txs applied
+func PendingBalance(head func(Address) Value_, pending ...Transactions) func(Address) Value_ {
+	return func(addr Address) Value_ {
+		balances := map[Address]Value_{addr: head(addr)}
+		for _, txs := range pending {
+			for _, tx := range txs {
+				applyTx(balances, tx)
+			}
+		}
+		return balances[addr]
+	}
+}
+
+func indexOfOwnTx(txs Transactions, ID int64, owner Address) int {
+	for i, tx := range txs {
+		if tx.ID == ID && tx.From != nil && tx.From.Address == owner {
+			return i
+		}
+	}
+	return -1
+}
+
+func (bc *BlockChain) isFrozen(acc *Account) bool {
+	if acc == nil {
+		return false
+	}
+	_, frozen := bc.frozen[acc.Address]
+	return frozen
+}
+
+func (bc *BlockChain) involvesFrozen(tx *Transaction) bool {
+	if bc.isFrozen(tx.From) || bc.isFrozen(tx.To) {
+		return true
+	}
+	for _, leg := range tx.Legs {
+		if leg != nil && (bc.isFrozen(leg.From) || bc.isFrozen(leg.To)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bc *BlockChain) processFreezeRequest(req FreezeRequest) {
+	defer close(req.ResponseCh)
+
+	if req.Freeze {
+		if err := WriteFrozenAddress(req.Address); err != nil {
+			req.ResponseCh <- err
+			return
+		}
+		bc.frozen[req.Address] = struct{}{}
+	} else {
+		if err := DeleteFrozenAddress(req.Address); err != nil {
+			req.ResponseCh <- err
+			return
+		}
+		delete(bc.frozen, req.Address)
 	}
 
 	req.ResponseCh <- nil
 }
 
-func Start() {
+func (bc *BlockChain) processMempoolRequest(req GetMempoolRequest) {
+	defer close(req.ResponseCh)
+
+	if req.Owner != nil {
+		req.ResponseCh <- Mempool{
+			Pending:   ownTxs(bc.txQueue.transactions, *req.Owner),
+			Scheduled: ownTxs(bc.futureTransactions, *req.Owner),
+		}
+		return
+	}
+
+	pending := make(Transactions, 0, len(bc.txQueue.transactions))
+	if bc.pendingBlock != nil {
+		pending = append(pending, bc.pendingBlock.Transactions...)
+	}
+	pending = append(pending, bc.txQueue.transactions...)
+	scheduled := make(Transactions, len(bc.futureTransactions))
+	copy(scheduled, bc.futureTransactions)
+
+	req.ResponseCh <- Mempool{Pending: pending, Scheduled: scheduled, Metrics: bc.txQueue.Metrics()}
+}
+
+func ownTxs(txs Transactions, owner Address) Transactions {
+	res := make(Transactions, 0)
+	for _, tx := range txs {
+		if tx.From != nil && tx.From.Address == owner {
+			res = append(res, tx)
+		}
+	}
+	return res
+}
+
+func (bc *BlockChain) indexBlock(block *Block) {
+	bc.blockNumbers[block.GetHash()] = block.Number
+	for i, tx := range block.Transactions {
+		bc.txLocations[tx.GetHash()] = TxLocation{BlockNumber: block.Number, Index: i}
+	}
+}
+
+func (bc *BlockChain) processBlockRequest(req GetBlockRequest) {
+	defer close(req.ResponseCh)
+
+	number := req.Number
+	if req.Hash != nil {
+		var ok bool
+		if number, ok = bc.blockNumbers[*req.Hash]; !ok {
+			req.ResponseCh <- nil
+			return
+		}
+	}
+	if number == LatestBlock {
+		number = bc.lastFinalizedNumber
+	}
+	if number < 0 || number > bc.lastFinalizedNumber {
+		req.ResponseCh <- nil
+		return
+	}
+
+	req.ResponseCh <- bc.GetBlockByNumber(number)
+}
+
+// processTxByHashRequest looks for tx in finalized blocks, then in mempool and scheduled txs
+func (bc *BlockChain) processTxByHashRequest(req GetTxByHashRequest) {
+	defer close(req.ResponseCh)
+
+	if location, ok := bc.txLocations[req.Hash]; ok {
+		block := bc.GetBlockByNumber(location.BlockNumber)
+		if block != nil && location.Index < len(block.Transactions) {
+			req.ResponseCh <- TxLookup{Tx: block.Transactions[location.Index], Block: block, Index: location.Index}
+			return
+		}
+	}
+	for _, txs := range []Transactions{bc.txQueue.transactions, bc.futureTransactions} {
+		for _, tx := range txs {
+			if tx.GetHash() == req.Hash {
+				txCopy := *tx
+				req.ResponseCh <- TxLookup{Tx: &txCopy}
+				return
+			}
+		}
+	}
+
+	req.ResponseCh <- TxLookup{}
+}
+
+// Start runs the chain loop until ctx is done, the returned channel is closed after
+// the pending transactions were finalized
+func Start(ctx context.Context, cfg *config.Config) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
+
 		stateCache, _ := lru.New(512)
+		policy, err := FeePolicyFromConfig(cfg)
+		if err != nil {
+			log.Error("invalid fee policy, fees are disabled", "err", err)
+		} else {
+			feePolicy = policy
+		}
+		confirmationTTL = cfg.ConfirmationTTL
+		loadOracles(cfg)
+
 		bc := &BlockChain{
 			stateCache:            stateCache,
+			txQueue:               NewTransactionQueue(cfg.MempoolSize, cfg.MempoolAccountLimit, cfg.MempoolTxTTL),
 			sendTxCh:              SendTxCh,
 			saveFutureTransaction: SaveFutureTxCh,
 			getBalanceCh:          GetBalanceCh,
 			getTxsWithFiltersCh:   GetTxsWithFiltersCh,
+			freezeCh:              FreezeCh,
+			getMempoolCh:          GetMempoolCh,
+			cancelTxCh:            CancelTxCh,
+			replaceTxCh:           ReplaceTxCh,
+			simulateTxCh:          SimulateTxCh,
+			getBlockCh:            GetBlockCh,
+			getTxByHashCh:         GetTxByHashCh,
+			frozen:                make(map[Address]struct{}),
+			blockNumbers:          make(map[Hash]utils.BlockNumber),
+			txLocations:           make(map[Hash]TxLocation),
 		}
 
 		if !BlocksExist() {
@@ -363,12 +995,20 @@ func Start() {
 			bc.lastFinalizedBlock = LFB
 			bc.lastFinalizedNumber = LFB.Number
 		}
-		bc.loadStates()
+		blocks := bc.loadStates()
 		bc.futureTransactions = GetFutureTxs()
+		bc.restoreMempool(blocks)
+		for _, addr := range GetFrozenAddresses() {
+			bc.frozen[addr] = struct{}{}
+		}
 
 		epochTicker := time.NewTicker(EpochDuration)
+		defer epochTicker.Stop()
 		for {
 			select {
+			case <-ctx.Done():
+				bc.shutdown()
+				return
 			case req := <-bc.sendTxCh:
 				bc.processTx(req)
 			case req := <-bc.getBalanceCh:
@@ -377,9 +1017,46 @@ func Start() {
 				bc.getTransactionsUsingFilters(req)
 			case req := <-bc.saveFutureTransaction:
 				bc.processFutureTxRequest(req)
+			case req := <-bc.freezeCh:
+				bc.processFreezeRequest(req)
+			case req := <-bc.getMempoolCh:
+				bc.processMempoolRequest(req)
+			case req := <-bc.cancelTxCh:
+				bc.processCancelTxRequest(req)
+			case req := <-bc.replaceTxCh:
+				bc.processReplaceTxRequest(req)
+			case req := <-bc.simulateTxCh:
+				bc.processSimulateTxRequest(req)
+			case req := <-bc.getBlockCh:
+				bc.processBlockRequest(req)
+			case req := <-bc.getTxByHashCh:
+				bc.processTxByHashRequest(req)
 			case <-epochTicker.C:
 				bc.processEpoch()
+			case <-bc.finalizeRetry:
+				bc.finalizeBlock(bc.pendingBlock)
 			}
 		}
 	}()
+	return done
+}
+
+func (bc *BlockChain) shutdown() {
+	log.Info("stopping chain", "pending", len(bc.txQueue.transactions))
+	for bc.pendingBlock != nil || !bc.txQueue.IsEmpty() {
+		if bc.pendingBlock != nil {
+			bc.finalizeBlock(bc.pendingBlock)
+		} else {
+			bc.processEpoch()
+		}
+		if bc.pendingBlock == nil {
+			continue
+		}
+		// requests aren't served any more, waiting for storage blocks nobody
+		if bc.finalizeFailures >= FinalizeRetries {
+			log.Error("storage is unavailable, pending transactions are kept in mempool")
+			return
+		}
+		time.Sleep(finalizeBackoff(bc.finalizeFailures))
+	}
 }
@@ -12,6 +12,7 @@ var hasherPool = sync.Pool{
 	New: func() interface{} { return sha3.NewLegacyKeccak256() },
 }
 
+// GetHash caches the hash, it must not be called before the block is assembled
 func (b *Block) GetHash() (h Hash) {
 	if b == nil {
 		return
@@ -19,13 +20,17 @@ func (b *Block) GetHash() (h Hash) {
 	if hash := b.Hash_.Load(); hash != nil {
 		return hash.(Hash)
 	}
+	b.Hash_.Store(b.computeHash())
+	return b.Hash_.Load().(Hash)
+}
+
+// computeHash ignores the cached hash
+func (b *Block) computeHash() Hash {
 	blockCopy := b.Copy()
 	blockCopy.TimeStamp = nil
 
 	JSON, _ := json.Marshal(blockCopy)
-
-	b.Hash_.Store(rlpHash(JSON))
-	return b.Hash_.Load().(Hash)
+	return rlpHash(JSON)
 }
 
 func (b *Block) Copy() *Block {
@@ -43,6 +48,11 @@ func (b *Block) Copy() *Block {
 	return cpy
 }
 
+func (tx *Transaction) GetHash() Hash {
+	JSON, _ := json.Marshal(tx)
+	return rlpHash(JSON)
+}
+
 func rlpHash(x interface{}) (h Hash) {
 	sha := hasherPool.Get().(crypto.KeccakState)
 	defer hasherPool.Put(sha)
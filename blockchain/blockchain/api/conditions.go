@@ -0,0 +1,311 @@
+package api
+
+import (
+	"IS/utils"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ( // parameters kept in Filter fields, others are keys of Filter.Params
+	ParamAccount = "cond_account"
+	ParamValue   = "cond_value"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+type (
+	// ConditionState is the chain state conditions of future txs are evaluated against
+	ConditionState struct {
+		Now                 int64 // unix
+		LastFinalizedNumber utils.BlockNumber
+		LastFinalizedBlock  *Block
+		Balance             func(addr Address) (Value_, bool) // false for unknown address
+		Owner               Address                           // sender of the evaluated tx
+	}
+
+	// ConditionEvaluator implements a condition type, evaluators are registered by type ID
+	ConditionEvaluator interface {
+		// Params are required, they are checked before the tx is accepted
+		Params() []string
+		Met(f *Filter, state *ConditionState) bool
+	}
+
+	// ConditionValidator is implemented by evaluators checking parameter values
+	ConditionValidator interface {
+		Validate(f *Filter) error
+	}
+
+	timeCondition    struct{}
+	balanceCondition struct {
+		more bool
+	}
+	sentTxCondition       struct{}
+	receivedFromCondition struct{}
+	blockRangeCondition   struct{}
+	weekdayCondition      struct{}
+	oracleCondition       struct{}
+)
+
+var conditionEvaluators = make(map[int]ConditionEvaluator)
+
+func init() {
+	RegisterCondition(TimeCond, timeCondition{})
+	RegisterCondition(AccountBalanceMoreThen, balanceCondition{more: true})
+	RegisterCondition(AccountBalanceLessThen, balanceCondition{more: false})
+	RegisterCondition(AccountSentTransaction, sentTxCondition{})
+	RegisterCondition(ReceivedFrom, receivedFromCondition{})
+	RegisterCondition(BlockRange, blockRangeCondition{})
+	RegisterCondition(Weekday, weekdayCondition{})
+	RegisterCondition(OracleValue, oracleCondition{})
+}
+
+// RegisterCondition makes the condition type available, it panics if the type is taken.
+// It must be called before the chain starts
+func RegisterCondition(typeID int, evaluator ConditionEvaluator) {
+	if _, ok := conditionEvaluators[typeID]; ok {
+		panic(fmt.Sprintf("condition type %d is registered twice", typeID))
+	}
+	conditionEvaluators[typeID] = evaluator
+}
+
+func (f *Filter) hasParam(name string) bool {
+	switch name {
+	case ParamAccount:
+		return f.CondAccount != nil
+	case ParamValue:
+		return f.CondValue != nil
+	}
+	_, ok := f.Params[name]
+	return ok
+}
+
+// Validate checks every filter of the tree and the tree size
+func (f *Filter) Validate() error {
+	nodes := 0
+	return f.validate(1, &nodes)
+}
+
+func (f *Filter) validate(depth int, nodes *int) error {
+	*nodes++
+	if depth > ConditionDepthLimit || *nodes > ConditionNodesLimit {
+		return CondTooComplexError.WithDetails(map[string]interface{}{
+			"depthLimit": ConditionDepthLimit, "nodesLimit": ConditionNodesLimit})
+	}
+
+	evaluator, ok := conditionEvaluators[f.Type]
+	if !ok {
+		return UnknownCondTypeError.WithDetails(map[string]interface{}{"type": f.Type})
+	}
+	for _, param := range evaluator.Params() {
+		if !f.hasParam(param) {
+			return CondParamError.WithDetails(map[string]interface{}{"parameter": param})
+		}
+	}
+	if validator, ok := evaluator.(ConditionValidator); ok {
+		if err := validator.Validate(f); err != nil {
+			return err
+		}
+	}
+
+	parts := append(append([]*Filter{}, f.All...), f.Any...)
+	if f.Not != nil {
+		parts = append(parts, f.Not)
+	}
+	for _, part := range parts {
+		if part == nil {
+			return CondMissingError
+		}
+		if err := part.validate(depth+1, nodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Met evaluates the tree, the filter must be valid
+func (f *Filter) Met(state *ConditionState) bool {
+	if !f.metSelf(state) {
+		return false
+	}
+	for _, part := range f.All {
+		if !part.Met(state) {
+			return false
+		}
+	}
+	if len(f.Any) != 0 {
+		met := false
+		for _, part := range f.Any {
+			if part.Met(state) {
+				met = true
+				break
+			}
+		}
+		if !met {
+			return false
+		}
+	}
+	return f.Not == nil || !f.Not.Met(state)
+}
+
+// metSelf evaluates the filter ignoring its compound parts
+func (f *Filter) metSelf(state *ConditionState) bool {
+	if f.SendAfterBlock != nil && *f.SendAfterBlock != state.LastFinalizedNumber {
+		return false
+	}
+	if f.SendAfterTimestamp != nil && *f.SendAfterTimestamp > state.Now {
+		return false
+	}
+	evaluator, ok := conditionEvaluators[f.Type]
+	return ok && evaluator.Met(f, state)
+}
+
+func invalidCondParam(param string) error {
+	return InvalidCondParamError.WithDetails(map[string]interface{}{"parameter": param})
+}
+
+func (timeCondition) Params() []string {
+	return nil
+}
+
+func (timeCondition) Met(*Filter, *ConditionState) bool {
+	return true
+}
+
+func (balanceCondition) Params() []string {
+	return []string{ParamAccount, ParamValue}
+}
+
+func (c balanceCondition) Met(f *Filter, state *ConditionState) bool {
+	val, ok := state.Balance(f.CondAccount.Address)
+	if c.more {
+		return ok && val.GreeterThen(f.CondValue)
+	}
+	return ok && val.LessThen(f.CondValue)
+}
+
+func (sentTxCondition) Params() []string {
+	return []string{ParamAccount}
+}
+
+func (sentTxCondition) Met(f *Filter, state *ConditionState) bool {
+	if state.LastFinalizedBlock == nil {
+		return false
+	}
+	for _, finTx := range state.LastFinalizedBlock.Transactions {
+		if finTx.From != nil && finTx.From.Address == f.CondAccount.Address {
+			return true
+		}
+	}
+	return false
+}
+
+// receivedFromCondition is met when the last block credits the tx sender from the
+// account, at least cond_value if it is set
+func (receivedFromCondition) Params() []string {
+	return []string{ParamAccount}
+}
+
+func (receivedFromCondition) Met(f *Filter, state *ConditionState) bool {
+	if state.LastFinalizedBlock == nil {
+		return false
+	}
+	for _, finTx := range state.LastFinalizedBlock.Transactions {
+		delta := finTx.GetBalanceDelta()
+		received, paid := delta[state.Owner], delta[f.CondAccount.Address]
+		if paid.Cents() < 0 && received.Cents() > 0 && (f.CondValue == nil || received.GreeterEqualThen(f.CondValue)) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockRangeCondition is met while the last finalized block is within "from" and "to" inclusive
+func (blockRangeCondition) Params() []string {
+	return []string{"from", "to"}
+}
+
+func (blockRangeCondition) bounds(f *Filter) (from, to utils.BlockNumber, err error) {
+	fromInt, errFrom := strconv.ParseInt(f.Params["from"], 10, 64)
+	toInt, errTo := strconv.ParseInt(f.Params["to"], 10, 64)
+	if errFrom != nil || fromInt < 0 {
+		return 0, 0, invalidCondParam("from")
+	}
+	if errTo != nil || toInt < fromInt {
+		return 0, 0, invalidCondParam("to")
+	}
+	return utils.BlockNumber(fromInt), utils.BlockNumber(toInt), nil
+}
+
+func (c blockRangeCondition) Validate(f *Filter) error {
+	_, _, err := c.bounds(f)
+	return err
+}
+
+func (c blockRangeCondition) Met(f *Filter, state *ConditionState) bool {
+	from, to, err := c.bounds(f)
+	return err == nil && state.LastFinalizedNumber >= from && state.LastFinalizedNumber <= to
+}
+
+// weekdayCondition is met on "days" like "mon,fri" in "time_zone", UTC if it is empty
+func (weekdayCondition) Params() []string {
+	return []string{"days"}
+}
+
+func (weekdayCondition) days(f *Filter) (map[time.Weekday]bool, *time.Location, error) {
+	res := make(map[time.Weekday]bool)
+	for _, name := range strings.Split(f.Params["days"], ",") {
+		day, ok := weekdays[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, nil, invalidCondParam("days")
+		}
+		res[day] = true
+	}
+	location, err := time.LoadLocation(f.Params["time_zone"])
+	if err != nil {
+		return nil, nil, invalidCondParam("time_zone")
+	}
+	return res, location, nil
+}
+
+func (c weekdayCondition) Validate(f *Filter) error {
+	_, _, err := c.days(f)
+	return err
+}
+
+func (c weekdayCondition) Met(f *Filter, state *ConditionState) bool {
+	days, location, err := c.days(f)
+	return err == nil && days[time.Unix(state.Now, 0).In(location).Weekday()]
+}
+
+// oracleCondition compares the value of oracle "name" with cond_value, "op" is gt or lt.
+// The name must be reported already, a stale value meets neither op
+func (oracleCondition) Params() []string {
+	return []string{"name", "op", ParamValue}
+}
+
+func (oracleCondition) Validate(f *Filter) error {
+	if op := f.Params["op"]; op != "gt" && op != "lt" {
+		return invalidCondParam("op")
+	}
+	// a name nobody reports would never meet the condition
+	if _, ok := Oracles.Get(f.Params["name"]); !ok {
+		return invalidCondParam("name")
+	}
+	return nil
+}
+
+func (oracleCondition) Met(f *Filter, state *ConditionState) bool {
+	value, ok := Oracles.Fresh(f.Params["name"], state.Now)
+	if !ok {
+		return false
+	}
+	if f.Params["op"] == "gt" {
+		return value.Value.GreeterThen(f.CondValue)
+	}
+	return value.Value.LessThen(f.CondValue)
+}
@@ -13,15 +13,24 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
+	"strconv"
 	"time"
 )
 
 var (
-	usersCollection        *mongo.Collection
-	stateCollection        *mongo.Collection
-	transactionsCollection *mongo.Collection
-	ctx                    = context.Background()
-	databaseInited         = false
+	usersCollection         *mongo.Collection
+	stateCollection         *mongo.Collection
+	transactionsCollection  *mongo.Collection
+	frozenCollection        *mongo.Collection
+	mempoolCollection       *mongo.Collection
+	auditCollection         *mongo.Collection
+	webhooksCollection      *mongo.Collection
+	deliveriesCollection    *mongo.Collection
+	notificationsCollection *mongo.Collection
+	oraclesCollection       *mongo.Collection
+	client                  *mongo.Client
+	ctx                     = context.Background()
+	databaseInited          = false
 )
 
 const idAndNicknameToSaltFormat = "%s+%s" //id.InsertedID.(primitive.ObjectID).String(), user.Username
@@ -39,7 +48,7 @@ func GetBlocks() Blocks {
 	if !databaseInited {
 		panic("use database_utils.InitDB()")
 	}
-	opts := options.Find().SetSort(bson.D{{"number", 1}})
+	opts := options.Find().SetSort(bson.D{{Key: "number", Value: 1}})
 	blocks := make(Blocks, 0)
 	cursor, err := stateCollection.Find(ctx, bson.D{}, opts)
 	if err != nil {
@@ -76,11 +85,16 @@ func CreateUser(ctx context.Context, user dbtypes.User, clearPassword string) er
 		panic("use database_utils.InitDB()")
 	}
 	if UserExists(ctx, user.Username) {
-		return errors.New(fmt.Sprintf("user with nickname=\"%s\" exists", user.Username))
+		return UserExistsError.WithDetails(map[string]interface{}{"username": user.Username})
 	}
 
+	return createUser(ctx, user, clearPassword, dbtypes.RoleUser)
+}
+
+func createUser(ctx context.Context, user dbtypes.User, clearPassword, role string) error {
 	userData := bson.M{
 		"nickname":   user.Username,
+		"role":       role,
 		"created_at": time.Now(),
 	}
 
@@ -89,13 +103,13 @@ func CreateUser(ctx context.Context, user dbtypes.User, clearPassword string) er
 		return err
 	}
 
-	filter := bson.D{{"nickname", user.Username}}
+	filter := bson.D{{Key: "nickname", Value: user.Username}}
 	user.ID = id.InsertedID.(primitive.ObjectID)
 	salt := infoToSalt(user)
 
 	userData["hashed_password"] = hash.CreateSaltPasswordHash(salt, clearPassword)
 	update := bson.D{
-		{"$set", userData},
+		{Key: "$set", Value: userData},
 	}
 
 	_, err = usersCollection.UpdateOne(ctx, filter, update)
@@ -109,7 +123,7 @@ func VerifyPassword(ctx context.Context, user dbtypes.User, clearPassword string
 	if !databaseInited {
 		panic("use database_utils.InitDB()")
 	}
-	filter := bson.D{{"nickname", user.Username}}
+	filter := bson.D{{Key: "nickname", Value: user.Username}}
 
 	if len(user.HashedPassword) == 0 {
 		err := usersCollection.FindOne(ctx, filter).Decode(&user)
@@ -133,7 +147,7 @@ func GetIdByUsername(username string) (primitive.ObjectID, error) {
 		panic("use database_utils.InitDB()")
 	}
 	user := dbtypes.User{}
-	filter := bson.D{{"nickname", username}}
+	filter := bson.D{{Key: "nickname", Value: username}}
 
 	if err := usersCollection.FindOne(ctx, filter).Decode(&user); err != nil {
 		return [12]byte{}, err
@@ -142,28 +156,333 @@ func GetIdByUsername(username string) (primitive.ObjectID, error) {
 	return user.ID, nil
 }
 
+func GetUserByUsername(ctx context.Context, username string) (*dbtypes.User, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	filter := bson.M{"nickname": username}
+
+	user := &dbtypes.User{}
+	if err := usersCollection.FindOne(ctx, filter).Decode(user); err != nil {
+		return nil, UnknownUserError.WithDetails(map[string]interface{}{"username": username})
+	}
+	return user, nil
+}
+
+func GetUsers(ctx context.Context) ([]dbtypes.User, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cursor, err := usersCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]dbtypes.User, 0)
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func SetRole(ctx context.Context, username, role string) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	if !dbtypes.IsKnownRole(role) {
+		return UnknownRoleError.WithDetails(map[string]interface{}{"role": role})
+	}
+	filter := bson.M{"nickname": username}
+	update := bson.M{"$set": bson.M{"role": role}}
+
+	res, err := usersCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return UnknownUserError.WithDetails(map[string]interface{}{"username": username})
+	}
+	return nil
+}
+
+func GrantReadAccess(ctx context.Context, owner, grantee string) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	if !UserExists(ctx, grantee) {
+		return UnknownUserError.WithDetails(map[string]interface{}{"username": grantee})
+	}
+	filter := bson.M{"nickname": owner}
+	update := bson.M{"$addToSet": bson.M{"read_grants": grantee}}
+	_, err := usersCollection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func RevokeReadAccess(ctx context.Context, owner, grantee string) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	filter := bson.M{"nickname": owner}
+	update := bson.M{"$pull": bson.M{"read_grants": grantee}}
+	_, err := usersCollection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// GetGrantors returns users who granted read access to the grantee
+func GetGrantors(ctx context.Context, grantee string) ([]dbtypes.User, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	cursor, err := usersCollection.Find(ctx, bson.M{"read_grants": grantee})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]dbtypes.User, 0)
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 func SetTelegramID(username string, ID int) error {
 	if !databaseInited {
 		panic("use database_utils.InitDB()")
 	}
-	filter := bson.D{{"nickname", username}}
+	filter := bson.D{{Key: "nickname", Value: username}}
 
 	user := dbtypes.User{}
 	err := usersCollection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
-		return errors.New(fmt.Sprintf("user with nickname=\"%s\" doesn't exists", username))
+		return UnknownUserError.WithDetails(map[string]interface{}{"username": username})
+	}
+
+	// a chat is linked to one account only
+	unlink := bson.M{"$unset": bson.M{"telegram_id": ""}}
+	if _, err = usersCollection.UpdateMany(ctx, bson.M{"telegram_id": ID}, unlink); err != nil {
+		return err
 	}
 
 	opts := options.Update().SetUpsert(true)
-	update := bson.D{{"$set", bson.D{{"telegram_id", ID}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "telegram_id", Value: ID}}}}
 	_, err = usersCollection.UpdateOne(ctx, filter, update, opts)
 	if err != nil {
 		return err
 	}
 
+	Audit(AuditTelegramLink, username, map[string]string{"telegram_id": strconv.Itoa(ID)})
+	if err = EnsureNotificationSettings(username); err != nil {
+		log.Println("can't store notification settings of", username, err)
+	}
+
 	return nil
 }
 
+func GetUserByTelegramID(ID int) (*dbtypes.User, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	user := &dbtypes.User{}
+	if err := usersCollection.FindOne(ctx, bson.M{"telegram_id": ID}).Decode(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetNotifiableUsers returns users with linked telegram chat or notification email
+// GetNotificationRecipients returns users of the addresses with their settings, the
+// settings are looked up by address and every user with a channel has them
+func GetNotificationRecipients(addrs []Address) ([]NotificationRecipient, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	hexAddrs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		hexAddrs = append(hexAddrs, utils.ToHex(addr[:]))
+	}
+	cursor, err := notificationsCollection.Find(ctx, bson.M{"address": bson.M{"$in": hexAddrs}})
+	if err != nil {
+		return nil, err
+	}
+	settings := make([]*NotificationSettings, 0)
+	if err = cursor.All(ctx, &settings); err != nil {
+		return nil, err
+	}
+	if len(settings) == 0 {
+		return nil, nil
+	}
+
+	byUsername := make(map[string]*NotificationSettings, len(settings))
+	usernames := make([]string, 0, len(settings))
+	for _, s := range settings {
+		byUsername[s.Username] = s
+		usernames = append(usernames, s.Username)
+	}
+	cursor, err = usersCollection.Find(ctx, bson.M{"nickname": bson.M{"$in": usernames}})
+	if err != nil {
+		return nil, err
+	}
+	users := make([]dbtypes.User, 0, len(usernames))
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	res := make([]NotificationRecipient, 0, len(users))
+	for _, user := range users {
+		res = append(res, NotificationRecipient{User: user, Settings: byUsername[user.Username]})
+	}
+	return res, nil
+}
+
+// GetNotificationSettingsByEmailToken returns nil if no email awaits confirmation with the token
+func GetNotificationSettingsByEmailToken(token string) (*NotificationSettings, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	settings := &NotificationSettings{}
+	err := notificationsCollection.FindOne(ctx, bson.M{"emailToken": token}).Decode(settings)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// EnsureNotificationSettings stores default settings of the user unless they exist
+func EnsureNotificationSettings(username string) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	defaults := bson.M{}
+	raw, err := bson.Marshal(DefaultNotificationSettings(username))
+	if err != nil {
+		return err
+	}
+	if err = bson.Unmarshal(raw, &defaults); err != nil {
+		return err
+	}
+	delete(defaults, "username")
+	delete(defaults, "address")
+
+	update := bson.M{
+		"$setOnInsert": defaults,
+		"$set":         bson.M{"address": notificationAddress(username)},
+	}
+	_, err = notificationsCollection.UpdateOne(ctx, bson.M{"username": username}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// BackfillNotificationAddresses gives settings to users linked to telegram before
+// settings were looked up by address, and addresses to the stored settings
+func BackfillNotificationAddresses() error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	opts := options.Find().SetProjection(bson.M{"nickname": 1})
+	cursor, err := usersCollection.Find(ctx, bson.M{"telegram_id": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return err
+	}
+	linked := make([]dbtypes.User, 0)
+	if err = cursor.All(ctx, &linked); err != nil {
+		return err
+	}
+
+	opts = options.Find().SetProjection(bson.M{"username": 1})
+	cursor, err = notificationsCollection.Find(ctx, bson.M{"address": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return err
+	}
+	unaddressed := make([]*NotificationSettings, 0)
+	if err = cursor.All(ctx, &unaddressed); err != nil {
+		return err
+	}
+
+	usernames := make([]string, 0, len(linked)+len(unaddressed))
+	for _, user := range linked {
+		usernames = append(usernames, user.Username)
+	}
+	for _, settings := range unaddressed {
+		usernames = append(usernames, settings.Username)
+	}
+	for _, username := range usernames {
+		if err = EnsureNotificationSettings(username); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNotificationSettings returns default settings if the user didn't change them
+func GetNotificationSettings(username string) (*NotificationSettings, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	settings := DefaultNotificationSettings(username)
+	err := notificationsCollection.FindOne(ctx, bson.M{"username": username}).Decode(settings)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func WriteNotificationSettings(settings *NotificationSettings) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	settings.Address = notificationAddress(settings.Username)
+	filter := bson.M{"username": settings.Username}
+	_, err := notificationsCollection.ReplaceOne(ctx, filter, settings, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetTwoFactorSettings returns settings kept in the user document, empty if they weren't set
+func GetTwoFactorSettings(username string) (*TwoFactorSettings, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	doc := struct {
+		TwoFactor *TwoFactorSettings `bson:"two_factor"`
+	}{}
+	opts := options.FindOne().SetProjection(bson.M{"two_factor": 1})
+	if err := usersCollection.FindOne(ctx, bson.M{"nickname": username}, opts).Decode(&doc); err != nil {
+		return nil, UnknownUserError.WithDetails(map[string]interface{}{"username": username})
+	}
+	if doc.TwoFactor == nil {
+		return &TwoFactorSettings{}, nil
+	}
+	return doc.TwoFactor, nil
+}
+
+func WriteTwoFactorSettings(username string, settings *TwoFactorSettings) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	update := bson.M{"$set": bson.M{"two_factor": settings}}
+	_, err := usersCollection.UpdateOne(ctx, bson.M{"nickname": username}, update)
+	return err
+}
+
+// UseTOTPStep records the time step of an accepted TOTP code of the user,
+// false if the step or a later one is recorded already
+func UseTOTPStep(username string, step int64) (bool, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	filter := bson.M{"nickname": username, "$or": bson.A{
+		bson.M{"totp_last_step": bson.M{"$lt": step}},
+		bson.M{"totp_last_step": bson.M{"$exists": false}},
+	}}
+	res, err := usersCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp_last_step": step}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
 func BlocksExist() bool {
 	if !databaseInited {
 		panic("use database_utils.InitDB()")
@@ -177,7 +496,7 @@ func GetLastBlock() (*Block, error) {
 	if !databaseInited {
 		panic("use database_utils.InitDB()")
 	}
-	opts := options.FindOne().SetSort(bson.D{{"number", 1}})
+	opts := options.FindOne().SetSort(bson.D{{Key: "number", Value: 1}})
 	LFB := stateCollection.FindOne(ctx, bson.D{}, opts)
 	block := &Block{}
 	err := LFB.Decode(block)
@@ -191,8 +510,8 @@ func GetBlockByHash(hash Hash) (*Block, error) {
 	if !databaseInited {
 		panic("use database_utils.InitDB()")
 	}
-	opts := options.FindOne().SetSort(bson.D{{"number", 1}})
-	filter := bson.D{{"hash", hash}}
+	opts := options.FindOne().SetSort(bson.D{{Key: "number", Value: 1}})
+	filter := bson.D{{Key: "hash", Value: hash}}
 	block := &Block{}
 	err := stateCollection.FindOne(ctx, filter, opts).Decode(block)
 	if err != nil {
@@ -205,8 +524,8 @@ func GetBlockByNumber(number utils.BlockNumber) (*Block, error) {
 	if !databaseInited {
 		panic("use database_utils.InitDB()")
 	}
-	opts := options.FindOne().SetSort(bson.D{{"number", 1}})
-	filter := bson.D{{"number", number}}
+	opts := options.FindOne().SetSort(bson.D{{Key: "number", Value: 1}})
+	filter := bson.D{{Key: "number", Value: number}}
 	block := &Block{}
 	err := stateCollection.FindOne(ctx, filter, opts).Decode(block)
 	if err != nil {
@@ -224,6 +543,14 @@ func WriteBlock(block *Block) error {
 		return err
 	}
 	_, err = stateCollection.InsertOne(ctx, blockBSON)
+	if mongo.IsDuplicateKeyError(err) {
+		// retry after an unacknowledged successful write
+		stored, getErr := GetBlockByNumber(block.Number)
+		if getErr == nil && stored.Number == block.Number && stored.computeHash() == block.computeHash() {
+			return nil
+		}
+		return fmt.Errorf("another block with number=%v exists", block.Number)
+	}
 	if err != nil {
 		return err
 	}
@@ -247,6 +574,16 @@ func WriteFutureTx(tx *Transaction) error {
 	return nil
 }
 
+// UpdateFutureTx replaces the future tx with the same ID, it keeps the schedule progress
+// of a recurring tx and stores replacements
+func UpdateFutureTx(tx *Transaction) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	_, err := transactionsCollection.ReplaceOne(ctx, bson.M{"ID": tx.ID}, tx, options.Replace().SetUpsert(true))
+	return err
+}
+
 func DeleteTransactionByID(ID int64) error {
 	filter := bson.M{"ID": ID}
 	_, err := transactionsCollection.DeleteOne(context.TODO(), filter)
@@ -256,6 +593,275 @@ func DeleteTransactionByID(ID int64) error {
 	return nil
 }
 
+func WriteOracleValue(name string, entry OracleEntry) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	update := bson.M{"$set": bson.M{"value": entry.Value, "updatedAt": entry.UpdatedAt}}
+	_, err := oraclesCollection.UpdateOne(ctx, bson.M{"name": name}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func GetOracleEntries() (map[string]OracleEntry, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	cursor, err := oraclesCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []struct {
+		Name        string `bson:"name"`
+		OracleEntry `bson:",inline"`
+	}
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]OracleEntry, len(docs))
+	for _, doc := range docs {
+		res[doc.Name] = doc.OracleEntry
+	}
+	return res, nil
+}
+
+func GetFrozenAddresses() []Address {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	cursor, err := frozenCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil
+	}
+
+	var docs []struct {
+		Address Address `bson:"address"`
+	}
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil
+	}
+
+	res := make([]Address, 0, len(docs))
+	for _, doc := range docs {
+		res = append(res, doc.Address)
+	}
+	return res
+}
+
+func WriteFrozenAddress(addr Address) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	filter := bson.M{"address": addr}
+	update := bson.M{"$set": bson.M{"address": addr, "frozen_at": time.Now()}}
+	_, err := frozenCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func DeleteFrozenAddress(addr Address) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	_, err := frozenCollection.DeleteOne(ctx, bson.M{"address": addr})
+	return err
+}
+
+func WriteAuditEvent(event *AuditEvent) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	_, err := auditCollection.InsertOne(ctx, event)
+	return err
+}
+
+func GetLastAuditEvent() (*AuditEvent, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	opts := options.FindOne().SetSort(bson.M{"seq": -1})
+	event := &AuditEvent{}
+	if err := auditCollection.FindOne(ctx, bson.M{}, opts).Decode(event); err != nil {
+		return nil, fmt.Errorf("no audit events")
+	}
+	return event, nil
+}
+
+func GetAuditEvents(req GetAuditLogRequest) (AuditEvents, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	filter := bson.M{}
+	if req.Type != "" {
+		filter["type"] = req.Type
+	}
+	if req.Actor != "" {
+		filter["actor"] = req.Actor
+	}
+	seqFilter := bson.M{}
+	if req.FromSeq != nil {
+		seqFilter["$gte"] = *req.FromSeq
+	}
+	if req.ToSeq != nil {
+		seqFilter["$lte"] = *req.ToSeq
+	}
+	if len(seqFilter) != 0 {
+		filter["seq"] = seqFilter
+	}
+
+	opts := options.Find().SetSort(bson.M{"seq": 1})
+	cursor, err := auditCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(AuditEvents, 0)
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func WritePendingTx(tx *Transaction) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+
+	txBSON, err := bson.Marshal(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = mempoolCollection.InsertOne(ctx, txBSON)
+	return err
+}
+
+// ReplacePendingTx replaces the pending tx with the same ID in one write
+func ReplacePendingTx(tx *Transaction) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	_, err := mempoolCollection.ReplaceOne(ctx, bson.M{"ID": tx.ID}, tx, options.Replace().SetUpsert(true))
+	return err
+}
+
+func GetPendingTxs() Transactions {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	opts := options.Find().SetSort(bson.M{"ID": 1})
+	txs := make(Transactions, 0)
+	cursor, err := mempoolCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil
+	}
+	if err = cursor.All(ctx, &txs); err != nil {
+		return nil
+	}
+
+	return txs
+}
+
+func DeletePendingTxByID(ID int64) error {
+	_, err := mempoolCollection.DeleteOne(ctx, bson.M{"ID": ID})
+	return err
+}
+
+func WriteWebhook(hook *Webhook) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	_, err := webhooksCollection.InsertOne(ctx, hook)
+	return err
+}
+
+// GetWebhooks returns webhooks of the owner, empty owner matches any
+func GetWebhooks(owner string) []*Webhook {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	filter := bson.M{}
+	if owner != "" {
+		filter["owner"] = owner
+	}
+	cursor, err := webhooksCollection.Find(ctx, filter)
+	if err != nil {
+		return nil
+	}
+	hooks := make([]*Webhook, 0)
+	if err = cursor.All(ctx, &hooks); err != nil {
+		return nil
+	}
+	return hooks
+}
+
+// DeleteWebhook returns false if owner has no webhook with the ID
+func DeleteWebhook(owner, ID string) (bool, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	res, err := webhooksCollection.DeleteOne(ctx, bson.M{"id": ID, "owner": owner})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount != 0, nil
+}
+
+func WriteWebhookDelivery(delivery *WebhookDelivery) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	_, err := deliveriesCollection.InsertOne(ctx, delivery)
+	return err
+}
+
+func UpdateWebhookDelivery(delivery *WebhookDelivery) error {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	_, err := deliveriesCollection.ReplaceOne(ctx, bson.M{"id": delivery.ID}, delivery)
+	return err
+}
+
+func GetWebhookDelivery(owner, ID string) (*WebhookDelivery, error) {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	delivery := &WebhookDelivery{}
+	if err := deliveriesCollection.FindOne(ctx, bson.M{"id": ID, "owner": owner}).Decode(delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// GetWebhookDeliveries returns deliveries oldest first, empty arguments match any
+func GetWebhookDeliveries(owner, webhookID, status string) []*WebhookDelivery {
+	if !databaseInited {
+		panic("use database_utils.InitDB()")
+	}
+	filter := bson.M{}
+	if owner != "" {
+		filter["owner"] = owner
+	}
+	if webhookID != "" {
+		filter["webhookId"] = webhookID
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.M{"createdAt": 1})
+	cursor, err := deliveriesCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil
+	}
+	deliveries := make([]*WebhookDelivery, 0)
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil
+	}
+	return deliveries
+}
+
 func infoToSalt(usr dbtypes.User) string {
 	return fmt.Sprintf(idAndNicknameToSaltFormat, usr.ID.String(), usr.Username)
 }
@@ -265,7 +871,8 @@ func InitDB(cfg *config.Config) {
 	dataBasePort := cfg.DataBasePort
 
 	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%s/", dataBaseAddress, dataBasePort))
-	client, err := mongo.Connect(ctx, clientOptions)
+	var err error
+	client, err = mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -278,8 +885,68 @@ func InitDB(cfg *config.Config) {
 	usersCollection = client.Database(cfg.DataBaseName).Collection(cfg.UsersCollectionName)
 	stateCollection = client.Database(cfg.DataBaseName).Collection(cfg.StateCollectionName)
 	transactionsCollection = client.Database(cfg.DataBaseName).Collection(cfg.TransactionsCollectionName)
+	frozenCollection = client.Database(cfg.DataBaseName).Collection(cfg.FrozenCollectionName)
+	mempoolCollection = client.Database(cfg.DataBaseName).Collection(cfg.MempoolCollectionName)
+	auditCollection = client.Database(cfg.DataBaseName).Collection(cfg.AuditCollectionName)
+	webhooksCollection = client.Database(cfg.DataBaseName).Collection(cfg.WebhooksCollectionName)
+	deliveriesCollection = client.Database(cfg.DataBaseName).Collection(cfg.DeliveriesCollectionName)
+	notificationsCollection = client.Database(cfg.DataBaseName).Collection(cfg.NotificationsCollectionName)
+	oraclesCollection = client.Database(cfg.DataBaseName).Collection(cfg.OraclesCollectionName)
+
+	_, err = stateCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"number": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// unique seq doesn't let two events share the same place in the chain
+	_, err = auditCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"seq": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = notificationsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"address": 1},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	databaseInited = true
+
+	if cfg.AdminUsername != "" {
+		seedAdmin(ctx, cfg.AdminUsername, cfg.AdminPassword)
+	}
+}
+
+// seedAdmin creates the configured admin, an existing user becomes admin only if it has
+// the configured password, so the name can't be claimed by registering it first
+func seedAdmin(ctx context.Context, username, password string) {
+	if !UserExists(ctx, username) {
+		if err := createUser(ctx, dbtypes.User{Username: username}, password, dbtypes.RoleAdmin); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if ok, _ := VerifyPassword(ctx, dbtypes.User{Username: username}, password); !ok {
+		log.Fatalf("user %v exists and --admin-password isn't its password", username)
+	}
+	if err := SetRole(ctx, username, dbtypes.RoleAdmin); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func CloseDB(ctx context.Context) error {
+	if !databaseInited {
+		return nil
+	}
+	databaseInited = false
+	return client.Disconnect(ctx)
 }
 
 func StateBlock() Block {
@@ -2,8 +2,8 @@ package api
 
 import (
 	db_types "IS/blockchain/database_utils/types"
+	"IS/utils"
 	"context"
-	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
@@ -15,86 +15,222 @@ func CreateUserReq(c *gin.Context) {
 	usr := &db_types.LoginData{}
 	err := c.ShouldBindJSON(usr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
-	}
-
-	if usr.Username == "" || utf8.RuneCountInString(usr.Username) < 4 {
-		c.JSON(http.StatusBadRequest, "nickname is so short")
+		RespondError(c, InvalidBodyError)
 		return
 	}
 
-	if usr.Password == "" {
-		c.JSON(http.StatusBadRequest, "")
-		return
-	} else if utf8.RuneCountInString(usr.Password) < 8 {
-		c.JSON(http.StatusBadRequest, "password is too short")
+	if err = registerUser(*usr); err != nil {
+		RespondError(c, err)
 		return
 	}
 
-	if err = CreateUser(context.Background(), db_types.User{Username: usr.Username}, usr.Password); err != nil {
-		c.JSON(http.StatusConflict, err.Error())
-		return
+	c.JSON(http.StatusOK, fmt.Sprintf("%s, your account was created\n", usr.Username))
+}
+
+func registerUser(usr db_types.LoginData) error {
+	if usr.Username == "" || utf8.RuneCountInString(usr.Username) < 4 {
+		return ShortUsernameError.WithDetails(map[string]interface{}{"minLength": 4})
+	}
+	if utf8.RuneCountInString(usr.Password) < 8 {
+		return ShortPasswordError.WithDetails(map[string]interface{}{"minLength": 8})
 	}
 
-	c.JSON(http.StatusOK, fmt.Sprintf("%s, your account was created\n", usr.Username))
+	if err := CreateUser(context.Background(), db_types.User{Username: usr.Username}, usr.Password); err != nil {
+		return err
+	}
+	Audit(AuditRegistration, usr.Username, nil)
+	return nil
 }
 
 func GetPublicKeyByUsername(c *gin.Context) {
 	RequestData := GetBPKByUsernameReq{}
 	err := c.ShouldBindJSON(&RequestData)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, "invalid body")
+		RespondError(c, InvalidBodyError)
+		return
 	}
 
-	if !UserExists(context.Background(), RequestData.Username) {
-		c.JSON(http.StatusNoContent, "unknown username")
+	respondAddress(c, RequestData.Username)
+}
+
+func respondAddress(c *gin.Context, username string) {
+	if !UserExists(context.Background(), username) {
+		RespondError(c, UnknownUserError.WithDetails(map[string]interface{}{"username": username}))
 		return
 	}
 
 	response := GetBPKByUsernameResp{}
-	response.Address = CalculatePublicKeyByUsername(RequestData.Username)
+	response.Address = CalculatePublicKeyByUsername(username)
 
 	c.JSON(http.StatusOK, response)
 }
 
 func SendTx(c *gin.Context) {
-	timestamp := time.Now().Unix()
-
 	Request := SendTxRequest{}
 	err := c.ShouldBindJSON(&Request)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+		RespondError(c, InvalidBodyError)
 		return
 	}
 
-	if ok, err := VerifyPassword(context.TODO(), db_types.User{Username: Request.Username}, Request.Password); err != nil || !ok {
-		c.JSON(http.StatusUnauthorized, "")
+	user, signers, err := authenticateSigners(Request.LoginData, Request.CoSigners)
+	if err != nil {
+		RespondError(c, err)
 		return
 	}
 
-	if Request.Tx.TxType == Transfer || Request.Tx.TxType == Spending {
-		Request.Tx.From = &Account{Address: CalculatePublicKeyByUsername(Request.Username)}
+	if _, err = submitTx(user, signers, Request.Tx); err != nil {
+		if apiErr := ToAPIError(err); apiErr.Code == ConfirmationRequiredError.Code {
+			c.JSON(http.StatusAccepted, gin.H{"result": "awaiting_confirmation", "confirmation": apiErr.Details})
+			return
+		}
+		RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "ok")
+}
+
+func SendTxBatch(c *gin.Context) {
+	Request := SendTxBatchRequest{}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+	if len(Request.Txs) == 0 || len(Request.Txs) > BatchTxsLimit {
+		RespondError(c, InvalidBatchError.WithDetails(map[string]interface{}{"maxTxs": BatchTxsLimit}))
+		return
+	}
+
+	user, signers, err := authenticateSigners(Request.LoginData, Request.CoSigners)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+
+	results := make([]BatchTxResult, 0, len(Request.Txs))
+	for i, tx := range Request.Txs {
+		result := BatchTxResult{Index: i, Result: "ok"}
+		if _, err := submitTx(user, signers, tx); err != nil {
+			result.Result = "error"
+			result.Error = ToAPIError(err)
+		}
+		results = append(results, result)
 	}
-	Request.Tx.Timestamp = &timestamp
 
-	errChan := make(chan error)
-	if Request.Tx.Condition != nil {
-		SaveFutureTxCh <- SendTxBcRequest{Tx: Request.Tx, ResponseCh: errChan}
+	c.JSON(http.StatusOK, results)
+}
+
+// authenticateSigners returns the user and addresses allowed to send funds in the request
+func authenticateSigners(login db_types.LoginData, coSigners []db_types.LoginData) (*db_types.User, []Address, error) {
+	user, err := authenticate(context.TODO(), login.Username, login.Password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signers := []Address{CalculatePublicKeyByUsername(user.Username)}
+	for _, coSigner := range coSigners {
+		if _, err := authenticate(context.TODO(), coSigner.Username, coSigner.Password); err != nil {
+			return nil, nil, UnauthorizedError.WithDetails(map[string]interface{}{"cosigner": coSigner.Username})
+		}
+		signers = append(signers, CalculatePublicKeyByUsername(coSigner.Username))
+	}
+	return user, signers, nil
+}
+
+func SimulateTx(c *gin.Context) {
+	Request := SendTxRequest{}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+
+	user, signers, err := authenticateSigners(Request.LoginData, Request.CoSigners)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+
+	tx := Request.Tx
+	if err = prepareTx(user, signers, &tx); err != nil {
+		apiErr := ToAPIError(err)
+		c.JSON(apiErr.Status, SimulateTxResponse{Error: apiErr})
+		return
+	}
+
+	simulation := SimulateTxRequest{Tx: tx, ResponseCh: make(chan SimulateTxResponse)}
+	SimulateTxCh <- simulation
+	c.JSON(http.StatusOK, <-simulation.ResponseCh)
+}
+
+// submitTx passes tx to the chain and waits for the result, hash of the accepted tx is returned.
+// Large transfers are parked until the second factor confirms them
+func submitTx(user *db_types.User, signers []Address, tx Transaction) (Hash, error) {
+	if err := prepareTx(user, signers, &tx); err != nil {
+		return Hash{}, err
+	}
+	if err := parkIfLarge(user, tx, nil); err != nil {
+		return Hash{}, err
+	}
+	return sendToChain(tx)
+}
+
+func sendToChain(tx Transaction) (Hash, error) {
+	Request := SendTxBcRequest{Tx: tx, ResponseCh: make(chan error, 1), HashCh: make(chan Hash, 1)}
+	if tx.IsScheduled() {
+		SaveFutureTxCh <- Request
 	} else {
-		SendTxCh <- SendTxBcRequest{Tx: Request.Tx, ResponseCh: errChan}
+		SendTxCh <- Request
 	}
 
 	select {
-	case err := <-errChan:
+	case err := <-Request.ResponseCh:
 		if err != nil {
-			c.JSON(http.StatusNotAcceptable, err)
-			return
+			return Hash{}, err
 		}
-		c.JSON(http.StatusOK, "ok")
+		return <-Request.HashCh, nil
 	case <-time.After(10 * time.Second):
-		c.JSON(http.StatusProcessing, "")
-		return
+		return Hash{}, TxProcessingError
+	}
+}
+
+// replaceInChain replaces pending or scheduled tx of the owner, hash of the new tx is returned
+func replaceInChain(owner Address, ID int64, tx Transaction) (Hash, error) {
+	Request := ReplaceTxRequest{ID: ID, Tx: tx, Owner: owner, ResponseCh: make(chan error, 1), HashCh: make(chan Hash, 1)}
+	ReplaceTxCh <- Request
+	if err := <-Request.ResponseCh; err != nil {
+		return Hash{}, err
+	}
+	return <-Request.HashCh, nil
+}
+
+// prepareTx checks permissions and fills fields which are set by the node
+func prepareTx(user *db_types.User, signers []Address, tx *Transaction) error {
+	timestamp := time.Now().Unix()
+
+	if tx.TxType == Obtaining && !user.HasRole(db_types.RoleAdmin) {
+		return ForbiddenError.WithDetails(map[string]interface{}{"reason": "only admin can mint"})
+	}
+
+	sender := &Account{Address: CalculatePublicKeyByUsername(user.Username)}
+	switch tx.TxType {
+	case Transfer, Spending:
+		tx.From = sender
+	case MultiTransfer:
+		tx.From = sender
+		for i, leg := range tx.Legs {
+			if leg == nil {
+				return InvalidLegError.WithDetails(map[string]interface{}{"leg": i, "reason": "empty leg"})
+			}
+			if leg.From == nil {
+				leg.From = sender
+			}
+			if ok, _ := utils.Contains(leg.From.Address, signers); !ok {
+				return UnsignedLegError.WithDetails(map[string]interface{}{"leg": i, "address": utils.ToHex(leg.From.Address[:])})
+			}
+		}
 	}
+	tx.Timestamp = &timestamp
+	return nil
 }
 
 func GetBalanceByBlockNumber(c *gin.Context) {
@@ -104,14 +240,18 @@ func GetBalanceByBlockNumber(c *gin.Context) {
 	err := c.ShouldBindJSON(&Request)
 
 	if err != nil {
-		c.JSON(http.StatusBadRequest, "bad request")
+		RespondError(c, InvalidBodyError)
 		return
 	}
 
+	respondBalance(c, Request)
+}
+
+func respondBalance(c *gin.Context, Request GetBalanceRequest) {
 	GetBalanceCh <- Request
 	Response := <-Request.ResponseCh
-	if errors.Is(Response.Err, FutureBlockError) {
-		c.JSON(http.StatusBadRequest, "")
+	if Response.Err != nil {
+		RespondError(c, Response.Err)
 		return
 	}
 
@@ -122,14 +262,143 @@ func GetTransactionsWithFilters(c *gin.Context) {
 	Request := GetTransactionsWithFiltersRequest{ResponseCh: make(chan Transactions)}
 	err := c.ShouldBindJSON(&Request)
 	if err != nil {
-		fmt.Println(err)
-		c.JSON(http.StatusBadRequest, "")
+		RespondError(c, InvalidBodyError)
+		return
+	}
+
+	respondTxsWithFilters(c, Request)
+}
+
+func respondTxsWithFilters(c *gin.Context, Request GetTransactionsWithFiltersRequest) {
+	txs, err := filterTxs(GetUserFromContext(c), Request)
+	if err != nil {
+		RespondError(c, err)
 		return
 	}
 
+	c.JSON(http.StatusOK, txs)
+}
+
+// filterTxs returns txs readable by user
+func filterTxs(user *db_types.User, Request GetTransactionsWithFiltersRequest) (Transactions, error) {
+	var err error
+	if !user.HasRole(db_types.RoleAuditor, db_types.RoleAdmin) {
+		Request.AllowedAddresses, err = readableAddresses(context.Background(), user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	GetTxsWithFiltersCh <- Request
+	return <-Request.ResponseCh, nil
+}
 
-	resp := <-Request.ResponseCh
+func GrantReadAccessReq(c *gin.Context) {
+	processReadAccess(c, GrantReadAccess, AuditReadAccessGranted)
+}
+
+func RevokeReadAccessReq(c *gin.Context) {
+	processReadAccess(c, RevokeReadAccess, AuditReadAccessRevoked)
+}
 
-	c.JSON(http.StatusOK, resp)
+func processReadAccess(c *gin.Context, apply func(ctx context.Context, owner, grantee string) error, eventType string) {
+	Request := ReadAccessRequest{}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+
+	applyReadAccess(c, Request.Username, apply, eventType)
+}
+
+func applyReadAccess(c *gin.Context, grantee string, apply func(ctx context.Context, owner, grantee string) error, eventType string) {
+	user := GetUserFromContext(c)
+	if err := apply(context.Background(), user.Username, grantee); err != nil {
+		RespondError(c, err)
+		return
+	}
+	Audit(eventType, user.Username, map[string]string{"grantee": grantee})
+
+	c.JSON(http.StatusOK, "ok")
+}
+
+// readableAddresses returns own address and addresses of users who granted read access
+func readableAddresses(ctx context.Context, user *db_types.User) ([]Address, error) {
+	grantors, err := GetGrantors(ctx, user.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Address, 0, len(grantors)+1)
+	res = append(res, CalculatePublicKeyByUsername(user.Username))
+	for _, grantor := range grantors {
+		res = append(res, CalculatePublicKeyByUsername(grantor.Username))
+	}
+	return res, nil
+}
+
+func GetOwnPendingTxs(c *gin.Context) {
+	owner := CalculatePublicKeyByUsername(GetUserFromContext(c).Username)
+	Request := GetMempoolRequest{Owner: &owner, ResponseCh: make(chan Mempool)}
+
+	GetMempoolCh <- Request
+	c.JSON(http.StatusOK, <-Request.ResponseCh)
+}
+
+func CancelTx(c *gin.Context) {
+	Request := CancelTxRequest{ResponseCh: make(chan error)}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+
+	respondCancelTx(c, Request)
+}
+
+func respondCancelTx(c *gin.Context, Request CancelTxRequest) {
+	Request.Owner = CalculatePublicKeyByUsername(GetUserFromContext(c).Username)
+
+	CancelTxCh <- Request
+	if err := <-Request.ResponseCh; err != nil {
+		RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "ok")
+}
+
+func ReplaceTx(c *gin.Context) {
+	Request := ReplaceTxRequest{ResponseCh: make(chan error)}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+
+	respondReplaceTx(c, Request)
+}
+
+func respondReplaceTx(c *gin.Context, Request ReplaceTxRequest) {
+	timestamp := time.Now().Unix()
+
+	user := GetUserFromContext(c)
+	Request.Owner = CalculatePublicKeyByUsername(user.Username)
+
+	if Request.Tx.TxType != Transfer && Request.Tx.TxType != Spending {
+		RespondError(c, InvalidReplaceError.WithDetails(map[string]interface{}{"reason": "only transfer and spending can be replaced"}))
+		return
+	}
+	Request.Tx.From = &Account{Address: Request.Owner}
+	Request.Tx.Timestamp = &timestamp
+
+	// a small tx mustn't become a large one without the second factor
+	if err := parkIfLarge(user, Request.Tx, &Request.ID); err != nil {
+		RespondError(c, err)
+		return
+	}
+
+	ReplaceTxCh <- Request
+	if err := <-Request.ResponseCh; err != nil {
+		RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "ok")
 }
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"IS/utils"
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"strings"
+)
+
+// handlers of /v1 routes, they take parameters from path and query and share
+// the processing with the deprecated routes
+
+func GetAddressV1(c *gin.Context) {
+	respondAddress(c, c.Param("username"))
+}
+
+func GetBalanceV1(c *gin.Context) {
+	addr, err := ParseAddress(c.Param("address"))
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+
+	Request := GetBalanceRequest{
+		Address:     addr,
+		BlockNumber: LatestBlock,
+		ResponseCh:  make(chan GetBalanceBCResponse),
+	}
+	if block := c.Query("block"); block != "" {
+		number, err := strconv.ParseInt(block, 10, 64)
+		if err != nil || number < 0 {
+			RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": "block"}))
+			return
+		}
+		Request.BlockNumber = utils.BlockNumber(number)
+	}
+
+	respondBalance(c, Request)
+}
+
+func GetTransactionsV1(c *gin.Context) {
+	Request := GetTransactionsWithFiltersRequest{ResponseCh: make(chan Transactions)}
+
+	if types := c.Query("types"); types != "" {
+		for _, txType := range strings.Split(types, ",") {
+			value, err := strconv.ParseUint(txType, 10, 32)
+			if err != nil {
+				RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": "types"}))
+				return
+			}
+			Request.TxTypes = append(Request.TxTypes, uint32(value))
+		}
+	}
+	for param, account := range map[string]**Account{"from": &Request.From, "to": &Request.To} {
+		if value := c.Query(param); value != "" {
+			addr, err := ParseAddress(value)
+			if err != nil {
+				RespondError(c, err)
+				return
+			}
+			*account = &Account{Address: addr}
+		}
+	}
+	for param, timestamp := range map[string]**int64{"since": &Request.TimeStampFrom, "until": &Request.TimeStampTo} {
+		if value := c.Query(param); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": param}))
+				return
+			}
+			*timestamp = &parsed
+		}
+	}
+
+	respondTxsWithFilters(c, Request)
+}
+
+func CancelTxV1(c *gin.Context) {
+	ID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": "id"}))
+		return
+	}
+
+	respondCancelTx(c, CancelTxRequest{ID: ID, ResponseCh: make(chan error)})
+}
+
+func ReplaceTxV1(c *gin.Context) {
+	ID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": "id"}))
+		return
+	}
+
+	Request := ReplaceTxRequest{ID: ID, ResponseCh: make(chan error)}
+	if err = c.ShouldBindJSON(&Request.Tx); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+
+	respondReplaceTx(c, Request)
+}
+
+func RevokeReadAccessV1(c *gin.Context) {
+	applyReadAccess(c, c.Param("username"), RevokeReadAccess, AuditReadAccessRevoked)
+}
+
+func GetAuditLogV1(c *gin.Context) {
+	Request := GetAuditLogRequest{
+		Type:  c.Query("type"),
+		Actor: c.Query("actor"),
+	}
+	for param, seq := range map[string]**int64{"fromSeq": &Request.FromSeq, "toSeq": &Request.ToSeq} {
+		if value := c.Query(param); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": param}))
+				return
+			}
+			*seq = &parsed
+		}
+	}
+
+	respondAuditLog(c, Request)
+}
+
+func SetUserRoleV1(c *gin.Context) {
+	Request := SetRoleRequest{}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+	Request.Username = c.Param("username")
+
+	applyRole(c, Request)
+}
+
+func FreezeAddressV1(c *gin.Context) {
+	freezeV1(c, true)
+}
+
+func UnfreezeAddressV1(c *gin.Context) {
+	freezeV1(c, false)
+}
+
+func freezeV1(c *gin.Context, freeze bool) {
+	addr, err := ParseAddress(c.Param("address"))
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+
+	respondFreeze(c, FreezeRequest{Address: addr, Freeze: freeze, ResponseCh: make(chan error)})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// APIError is an error with machine-readable code returned to clients
+type APIError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Status  int                    `json:"-"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func newAPIError(status int, code, message string) *APIError {
+	return &APIError{Code: code, Message: message, Status: status}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Is matches errors by code, so errors with details match the catalogue values
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Code == e.Code
+}
+
+func (e *APIError) WithDetails(details map[string]interface{}) *APIError {
+	cpy := *e
+	cpy.Details = details
+	return &cpy
+}
+
+var ( // errors catalogue
+	InternalError         = newAPIError(http.StatusInternalServerError, "internal", "internal error")
+	StorageError          = newAPIError(http.StatusServiceUnavailable, "storage_unavailable", "storage is unavailable")
+	InvalidBodyError      = newAPIError(http.StatusBadRequest, "invalid_body", "invalid request body")
+	UnauthorizedError     = newAPIError(http.StatusUnauthorized, "unauthorized", "missing or invalid credentials")
+	ForbiddenError        = newAPIError(http.StatusForbidden, "forbidden", "permission denied")
+	ShortUsernameError    = newAPIError(http.StatusBadRequest, "username_too_short", "nickname is so short")
+	ShortPasswordError    = newAPIError(http.StatusBadRequest, "password_too_short", "password is too short")
+	UserExistsError       = newAPIError(http.StatusConflict, "user_exists", "user already exists")
+	UnknownUserError      = newAPIError(http.StatusNotFound, "unknown_user", "unknown username")
+	UnknownRoleError      = newAPIError(http.StatusBadRequest, "unknown_role", "unknown role")
+	FutureBlockError      = newAPIError(http.StatusBadRequest, "unknown_block", "unknown block")
+	UnknownAddressError   = newAPIError(http.StatusNotFound, "unknown_address", "unknown address")
+	InvalidAddressError   = newAPIError(http.StatusBadRequest, "invalid_address", "invalid address")
+	InvalidParamError     = newAPIError(http.StatusBadRequest, "invalid_parameter", "invalid parameter")
+	InvalidBatchError     = newAPIError(http.StatusBadRequest, "invalid_batch", "invalid batch size")
+	TxProcessingError     = newAPIError(http.StatusGatewayTimeout, "tx_processing", "transaction is processing")
+	InvalidReplaceError   = newAPIError(http.StatusNotAcceptable, "invalid_replacement", "transaction can't be replaced")
+	UnsignedLegError      = newAPIError(http.StatusForbidden, "unsigned_leg", "leg sender didn't sign")
+	CondMissingError      = newAPIError(http.StatusNotAcceptable, "condition_missing", "missing required parameter: condition")
+	CondParamError        = newAPIError(http.StatusNotAcceptable, "condition_parameter_missing", "missing required condition parameter")
+	UnknownCondTypeError  = newAPIError(http.StatusNotAcceptable, "unknown_condition_type", "unknown cond type")
+	InvalidCondParamError = newAPIError(http.StatusNotAcceptable, "invalid_condition_parameter", "invalid condition parameter")
+	InvalidScheduleError  = newAPIError(http.StatusNotAcceptable, "invalid_schedule", "invalid schedule")
+	NotRecurringError     = newAPIError(http.StatusBadRequest, "not_recurring", "transaction isn't recurring")
+	CondTooComplexError   = newAPIError(http.StatusNotAcceptable, "condition_too_complex", "condition is nested too deep or too large")
+
+	// transaction validation
+	InvalidValueError      = newAPIError(http.StatusNotAcceptable, "invalid_value", "invalid value")
+	InvalidFeeError        = newAPIError(http.StatusNotAcceptable, "invalid_fee", "invalid fee")
+	InsufficientFeeError   = newAPIError(http.StatusNotAcceptable, "insufficient_fee", "insufficient fee")
+	InsufficientFundsError = newAPIError(http.StatusNotAcceptable, "insufficient_funds", "insufficient funds")
+	FrozenAddressError     = newAPIError(http.StatusNotAcceptable, "address_frozen", "address is frozen")
+	MissingSenderError     = newAPIError(http.StatusNotAcceptable, "missing_sender", "missing sender")
+	MissingRecipientError  = newAPIError(http.StatusNotAcceptable, "missing_recipient", "missing recipient")
+	UnknownTxTypeError     = newAPIError(http.StatusNotAcceptable, "unknown_tx_type", "unknown tx type")
+	InvalidLegError        = newAPIError(http.StatusNotAcceptable, "invalid_leg", "invalid leg")
+
+	// mempool
+	MempoolFullError      = newAPIError(http.StatusServiceUnavailable, "mempool_full", "mempool is full")
+	AccountLimitError     = newAPIError(http.StatusTooManyRequests, "account_limit", "too many pending transactions for account")
+	UnknownPendingTxError = newAPIError(http.StatusNotFound, "unknown_pending_tx", "unknown pending transaction")
+
+	// two-factor confirmation
+	ConfirmationRequiredError = newAPIError(http.StatusAccepted, "confirmation_required", "transaction awaits confirmation with the second factor")
+	UnknownConfirmationError  = newAPIError(http.StatusNotFound, "unknown_confirmation", "unknown or expired confirmation")
+	InvalidOTPError           = newAPIError(http.StatusForbidden, "invalid_otp", "invalid one-time code")
+	NoSecondFactorError       = newAPIError(http.StatusServiceUnavailable, "second_factor_unavailable", "no second factor is available")
+	SecondFactorRequiredError = newAPIError(http.StatusForbidden, "second_factor_required", "the change needs a code of the current second factor")
+	TooManyOTPAttemptsError   = newAPIError(http.StatusTooManyRequests, "too_many_otp_attempts", "too many invalid one-time codes, try later")
+
+	// notifications
+	EmailUnavailableError    = newAPIError(http.StatusServiceUnavailable, "email_unavailable", "email can't be sent")
+	UnknownEmailConfirmError = newAPIError(http.StatusNotFound, "unknown_email_confirmation", "unknown or expired email confirmation")
+
+	// webhooks
+	InvalidWebhookError  = newAPIError(http.StatusBadRequest, "invalid_webhook", "invalid webhook")
+	UnknownWebhookError  = newAPIError(http.StatusNotFound, "unknown_webhook", "unknown webhook")
+	UnknownDeliveryError = newAPIError(http.StatusNotFound, "unknown_delivery", "unknown webhook delivery")
+	RedeliveryError      = newAPIError(http.StatusConflict, "delivery_not_dead", "only dead deliveries can be redelivered")
+)
+
+// ToAPIError returns err from the catalogue or wraps unknown error as internal
+func ToAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return InternalError.WithDetails(map[string]interface{}{"error": err.Error()})
+}
+
+func RespondError(c *gin.Context, err error) {
+	apiErr := ToAPIError(err)
+	c.JSON(apiErr.Status, gin.H{
+		"result":   "error",
+		"err_code": apiErr.Code,
+		"message":  apiErr.Message,
+		"details":  apiErr.Details,
+	})
+}
+
+func AbortWithError(c *gin.Context, err error) {
+	RespondError(c, err)
+	c.Abort()
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"IS/utils"
+	"sync"
+)
+
+const (
+	EventBlock    = "block"
+	EventTx       = "tx"        // finalized tx
+	EventTxStatus = "tx_status" // status change of pending or scheduled tx
+	EventAudit    = "audit"     // written audit event, for notifications only
+
+	TxStatusPending   = "pending"
+	TxStatusScheduled = "scheduled"
+	TxStatusFinalized = "finalized"
+	TxStatusDropped   = "dropped"
+	TxStatusCancelled = "cancelled"
+	TxStatusReplaced  = "replaced"
+	TxStatusFailed    = "failed"    // scheduled tx was invalid when its condition was met
+	TxStatusCompleted = "completed" // recurring tx has no occurrences left
+
+	EventBufferSize = 256
+)
+
+type (
+	Event struct {
+		Type        string             `json:"type"`
+		Block       *BlockSummary      `json:"block,omitempty"`
+		Tx          *Transaction       `json:"tx,omitempty"`
+		BlockNumber *utils.BlockNumber `json:"blockNumber,omitempty"` // block of finalized tx
+		Status      *TxStatus          `json:"status,omitempty"`      // Tx is set too
+		Audit       *AuditEvent        `json:"-"`
+	}
+
+	BlockSummary struct {
+		Number     utils.BlockNumber `json:"number"`
+		Hash       string            `json:"hash"`
+		ParentHash string            `json:"parentHash"`
+		Timestamp  int64             `json:"timestamp"`
+		TxCount    int               `json:"txCount"`
+	}
+
+	TxStatus struct {
+		ID          int64              `json:"id"`
+		Hash        string             `json:"hash"`
+		Owner       string             `json:"owner"`
+		Status      string             `json:"status"`
+		Reason      string             `json:"reason,omitempty"`
+		BlockNumber *utils.BlockNumber `json:"blockNumber,omitempty"`
+
+		owner Address
+	}
+
+	// EventBus fans chain events out to subscribers, publishing never blocks the chain loop
+	EventBus struct {
+		mu          sync.Mutex
+		subscribers map[*Subscription]struct{}
+	}
+
+	// Subscription channel is closed on Unsubscribe or when the subscriber falls behind
+	Subscription struct {
+		C   chan Event
+		bus *EventBus
+	}
+)
+
+var Events = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[*Subscription]struct{})}
+}
+
+func (b *EventBus) Subscribe(buffer int) *Subscription {
+	sub := &Subscription{C: make(chan Event, buffer), bus: b}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *EventBus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		select {
+		case sub.C <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.C)
+		}
+	}
+}
+
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, ok := s.bus.subscribers[s]; ok {
+		delete(s.bus.subscribers, s)
+		close(s.C)
+	}
+}
+
+// OwnedBy reports whether status belongs to tx sent from addr
+func (s *TxStatus) OwnedBy(addr Address) bool {
+	return s.owner == addr
+}
+
+func NewBlockSummary(block *Block) *BlockSummary {
+	hash := block.GetHash()
+	summary := &BlockSummary{
+		Number:     block.Number,
+		Hash:       utils.ToHex(hash[:]),
+		ParentHash: utils.ToHex(block.ParentHash[:]),
+		TxCount:    len(block.Transactions),
+	}
+	if block.TimeStamp != nil {
+		summary.Timestamp = *block.TimeStamp
+	}
+	return summary
+}
+
+func NewTxStatus(tx *Transaction, status, reason string) *TxStatus {
+	hash, owner := tx.GetHash(), txSender(tx)
+	return &TxStatus{
+		ID:     tx.ID,
+		Hash:   utils.ToHex(hash[:]),
+		Owner:  utils.ToHex(owner[:]),
+		Status: status,
+		Reason: reason,
+		owner:  owner,
+	}
+}
+
+func publishTxStatus(tx *Transaction, status, reason string) {
+	Events.Publish(Event{Type: EventTxStatus, Tx: tx, Status: NewTxStatus(tx, status, reason)})
+}
+
+// publishBlock sends tx events before the block event, so the block event marks
+// that everything of the block was delivered
+func publishBlock(block *Block) {
+	number := block.Number
+	for _, tx := range block.Transactions {
+		Events.Publish(Event{Type: EventTx, Tx: tx, BlockNumber: &number})
+
+		status := NewTxStatus(tx, TxStatusFinalized, "")
+		status.BlockNumber = &number
+		Events.Publish(Event{Type: EventTxStatus, Tx: tx, BlockNumber: &number, Status: status})
+	}
+	Events.Publish(Event{Type: EventBlock, Block: NewBlockSummary(block)})
+}
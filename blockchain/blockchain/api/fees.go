@@ -0,0 +1,104 @@
+package api
+
+import (
+	"IS/blockchain/config"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const ( // fee policies
+	NoFee      = "none"
+	FlatFee    = "flat"
+	PerByteFee = "per-byte"
+)
+
+type FeePolicy struct {
+	Kind     string
+	Amount   Value_
+	Treasury Address
+}
+
+var feePolicy = FeePolicy{Kind: NoFee}
+
+func FeePolicyFromConfig(cfg *config.Config) (FeePolicy, error) {
+	policy := FeePolicy{Kind: cfg.FeePolicy}
+	if policy.Kind == "" || policy.Kind == NoFee {
+		policy.Kind = NoFee
+		return policy, nil
+	}
+
+	amount, err := ParseValue(cfg.FeeAmount)
+	if err != nil {
+		return policy, err
+	}
+	policy.Amount = amount
+
+	treasury, err := hex.DecodeString(cfg.TreasuryAddress)
+	if err != nil || len(treasury) != AddressLen {
+		return policy, fmt.Errorf("invalid treasury address: \"%v\"", cfg.TreasuryAddress)
+	}
+	copy(policy.Treasury[:], treasury)
+	if policy.Treasury == (Address{}) {
+		return policy, fmt.Errorf("treasury address is required by fee policy \"%v\"", policy.Kind)
+	}
+
+	return policy, nil
+}
+
+// RequiredFee returns the minimal fee accepted for tx
+func (fp *FeePolicy) RequiredFee(tx *Transaction) Value_ {
+	if tx.From == nil {
+		return Value_{}
+	}
+	switch fp.Kind {
+	case FlatFee:
+		return fp.Amount
+	case PerByteFee:
+		return ValueFromCents(fp.Amount.Cents() * int64(txSize(tx)))
+	default:
+		return Value_{}
+	}
+}
+
+// txSize is a size of tx without fields set after fee calculation
+func txSize(tx *Transaction) int {
+	cpy := *tx
+	cpy.ID = 0
+	cpy.Fee = nil
+
+	JSON, _ := json.Marshal(cpy)
+	return len(JSON)
+}
+
+func (tx *Transaction) GetFee() Value_ {
+	if tx.Fee == nil {
+		return Value_{}
+	}
+	return *tx.Fee
+}
+
+// ParseValue parses decimal string with up to 2 fractional digits, e.g. "12.5"
+func ParseValue(s string) (Value_, error) {
+	integerPart, fractionalPart, _ := strings.Cut(s, ".")
+	if len(fractionalPart) > 2 {
+		return Value_{}, fmt.Errorf("invalid value: \"%v\"", s)
+	}
+
+	integer, err := strconv.ParseInt(integerPart, 10, 64)
+	if err != nil || integer < 0 {
+		return Value_{}, fmt.Errorf("invalid value: \"%v\"", s)
+	}
+
+	var fractional int64
+	if fractionalPart != "" {
+		fractionalPart += strings.Repeat("0", 2-len(fractionalPart))
+		if fractional, err = strconv.ParseInt(fractionalPart, 10, 32); err != nil || fractional < 0 {
+			return Value_{}, fmt.Errorf("invalid value: \"%v\"", s)
+		}
+	}
+
+	return Value_{Integer: integer, Fractional: int32(fractional)}, nil
+}
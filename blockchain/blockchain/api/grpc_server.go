@@ -0,0 +1,451 @@
+package api
+
+import (
+	"IS/blockchain/blockchain/api/pb"
+	db_types "IS/blockchain/database_utils/types"
+	"IS/utils"
+	"context"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"time"
+)
+
+// gRPC services delegate to the same chain channels as gin handlers
+
+// GRPCStopGrace is how long unary calls may finish on shutdown before streams are cut
+const GRPCStopGrace = 2 * time.Second
+
+type (
+	accountServer struct {
+		pb.UnimplementedAccountServiceServer
+	}
+	balanceServer struct {
+		pb.UnimplementedBalanceServiceServer
+	}
+	transactionServer struct {
+		pb.UnimplementedTransactionServiceServer
+	}
+	blockServer struct {
+		pb.UnimplementedBlockServiceServer
+	}
+)
+
+func NewGRPCServer() *grpc.Server {
+	server := grpc.NewServer()
+	pb.RegisterAccountServiceServer(server, &accountServer{})
+	pb.RegisterBalanceServiceServer(server, &balanceServer{})
+	pb.RegisterTransactionServiceServer(server, &transactionServer{})
+	pb.RegisterBlockServiceServer(server, &blockServer{})
+	return server
+}
+
+// GRPCError converts error to status, APIError code is passed as ErrorInfo reason
+func GRPCError(err error) error {
+	apiErr := ToAPIError(err)
+
+	code := codes.Internal
+	switch apiErr.Status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotAcceptable, http.StatusAccepted: // rejected by chain rules or awaits confirmation
+		code = codes.FailedPrecondition
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout: // the chain didn't answer in time, tx may still be accepted
+		code = codes.DeadlineExceeded
+	}
+
+	info := &errdetails.ErrorInfo{Reason: apiErr.Code, Domain: "is.node"}
+	if apiErr.Details != nil {
+		info.Metadata = make(map[string]string, len(apiErr.Details))
+		for k, v := range apiErr.Details {
+			info.Metadata[k] = fmt.Sprint(v)
+		}
+	}
+	st, detailsErr := status.New(code, apiErr.Message).WithDetails(info)
+	if detailsErr != nil {
+		return status.Error(code, apiErr.Message)
+	}
+	return st.Err()
+}
+
+// grpcAuthenticate checks basic auth passed in metadata
+func grpcAuthenticate(ctx context.Context) (*db_types.User, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	request := http.Request{Header: http.Header{"Authorization": md.Get("authorization")}}
+	username, password, ok := request.BasicAuth()
+	if !ok {
+		return nil, UnauthorizedError
+	}
+	return authenticate(ctx, username, password)
+}
+
+// grpcReadable authenticates the caller and returns addresses whose txs it can read, nil - any
+func grpcReadable(ctx context.Context) ([]Address, error) {
+	user, err := grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return subscriberReadable(user)
+}
+
+func addressFromPB(b []byte) (Address, error) {
+	addr := Address{}
+	if len(b) != AddressLen {
+		return addr, InvalidAddressError.WithDetails(map[string]interface{}{"address": utils.ToHex(b)})
+	}
+	copy(addr[:], b)
+	return addr, nil
+}
+
+func accountFromPB(acc *pb.Account) (*Account, error) {
+	if acc == nil {
+		return nil, nil
+	}
+	addr, err := addressFromPB(acc.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{Address: addr}, nil
+}
+
+func accountToPB(acc *Account) *pb.Account {
+	if acc == nil {
+		return nil
+	}
+	return &pb.Account{Address: acc.Address[:]}
+}
+
+func txFromPB(msg *pb.Transaction) (Transaction, error) {
+	var err error
+	tx := Transaction{
+		Value:       ValueFromCents(msg.GetValue()),
+		Description: msg.GetDescription(),
+		TxType:      msg.GetType(),
+	}
+	if tx.To, err = accountFromPB(msg.GetTo()); err != nil {
+		return tx, err
+	}
+	if msg.Fee != nil {
+		fee := ValueFromCents(msg.GetFee())
+		tx.Fee = &fee
+	}
+	for _, leg := range msg.GetLegs() {
+		res := &Leg{Value: ValueFromCents(leg.GetValue())}
+		if res.From, err = accountFromPB(leg.GetFrom()); err != nil {
+			return tx, err
+		}
+		if res.To, err = accountFromPB(leg.GetTo()); err != nil {
+			return tx, err
+		}
+		tx.Legs = append(tx.Legs, res)
+	}
+	if cond := msg.GetCondition(); cond != nil {
+		tx.Condition = &Filter{Type: int(cond.GetType()), SendAfterTimestamp: cond.SendAfterTimestamp}
+		if cond.SendAfterBlock != nil {
+			number := utils.BlockNumber(cond.GetSendAfterBlock())
+			tx.Condition.SendAfterBlock = &number
+		}
+		if cond.Value != nil {
+			value := ValueFromCents(cond.GetValue())
+			tx.Condition.CondValue = &value
+		}
+		if tx.Condition.CondAccount, err = accountFromPB(cond.GetAccount()); err != nil {
+			return tx, err
+		}
+	}
+	return tx, nil
+}
+
+func txToPB(tx *Transaction) *pb.Transaction {
+	hash := tx.GetHash()
+	res := &pb.Transaction{
+		Id:          tx.ID,
+		From:        accountToPB(tx.From),
+		To:          accountToPB(tx.To),
+		Value:       tx.Value.Cents(),
+		Description: tx.Description,
+		Type:        tx.TxType,
+		Hash:        hash[:],
+	}
+	if tx.Timestamp != nil {
+		res.Timestamp = *tx.Timestamp
+	}
+	if tx.Fee != nil {
+		fee := tx.Fee.Cents()
+		res.Fee = &fee
+	}
+	for _, leg := range tx.Legs {
+		res.Legs = append(res.Legs, &pb.Leg{From: accountToPB(leg.From), To: accountToPB(leg.To), Value: leg.Value.Cents()})
+	}
+	// compound parts and params of the condition and schedules aren't exposed over gRPC
+	if cond := tx.Condition; cond != nil {
+		res.Condition = &pb.Condition{
+			SendAfterTimestamp: cond.SendAfterTimestamp,
+			Type:               int32(cond.Type),
+			Account:            accountToPB(cond.CondAccount),
+		}
+		if cond.SendAfterBlock != nil {
+			number := int64(*cond.SendAfterBlock)
+			res.Condition.SendAfterBlock = &number
+		}
+		if cond.CondValue != nil {
+			value := cond.CondValue.Cents()
+			res.Condition.Value = &value
+		}
+	}
+	return res
+}
+
+// blockToPB converts block with txs readable by the caller only
+func blockToPB(block *Block, readable []Address) *pb.Block {
+	hash := block.GetHash()
+	res := &pb.Block{
+		Number:       int64(block.Number),
+		Hash:         hash[:],
+		ParentHash:   block.ParentHash[:],
+		Transactions: make([]*pb.Transaction, 0, len(block.Transactions)),
+	}
+	if block.TimeStamp != nil {
+		res.Timestamp = *block.TimeStamp
+	}
+	for _, tx := range block.Transactions {
+		if txReadable(tx, readable) {
+			res.Transactions = append(res.Transactions, txToPB(tx))
+		}
+	}
+	return res
+}
+
+func (s *accountServer) CreateAccount(_ context.Context, req *pb.Login) (*pb.Empty, error) {
+	if err := registerUser(db_types.LoginData{Username: req.GetUsername(), Password: req.GetPassword()}); err != nil {
+		return nil, GRPCError(err)
+	}
+	return &pb.Empty{}, nil
+}
+
+func (s *accountServer) GetAddress(ctx context.Context, req *pb.GetAddressRequest) (*pb.Account, error) {
+	if !UserExists(ctx, req.GetUsername()) {
+		return nil, GRPCError(UnknownUserError.WithDetails(map[string]interface{}{"username": req.GetUsername()}))
+	}
+	addr := CalculatePublicKeyByUsername(req.GetUsername())
+	return &pb.Account{Address: addr[:]}, nil
+}
+
+func (s *balanceServer) GetBalance(_ context.Context, req *pb.GetBalanceRequest) (*pb.Balance, error) {
+	addr, err := addressFromPB(req.GetAddress())
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+
+	Request := GetBalanceRequest{Address: addr, BlockNumber: LatestBlock, ResponseCh: make(chan GetBalanceBCResponse)}
+	if req.Block != nil {
+		Request.BlockNumber = utils.BlockNumber(req.GetBlock())
+	}
+	GetBalanceCh <- Request
+	Response := <-Request.ResponseCh
+	if Response.Err != nil {
+		return nil, GRPCError(Response.Err)
+	}
+	return &pb.Balance{Value: Response.Balance.Cents()}, nil
+}
+
+func (s *transactionServer) prepare(ctx context.Context, req *pb.SendTransactionRequest) (*db_types.User, []Address, Transaction, error) {
+	tx, err := txFromPB(req.GetTx())
+	if err != nil {
+		return nil, nil, tx, err
+	}
+	user, err := grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, nil, tx, err
+	}
+
+	signers := []Address{CalculatePublicKeyByUsername(user.Username)}
+	for _, coSigner := range req.GetCosigners() {
+		if _, err = authenticate(ctx, coSigner.GetUsername(), coSigner.GetPassword()); err != nil {
+			return nil, nil, tx, UnauthorizedError.WithDetails(map[string]interface{}{"cosigner": coSigner.GetUsername()})
+		}
+		signers = append(signers, CalculatePublicKeyByUsername(coSigner.GetUsername()))
+	}
+	return user, signers, tx, nil
+}
+
+func (s *transactionServer) SendTransaction(ctx context.Context, req *pb.SendTransactionRequest) (*pb.SendTransactionResponse, error) {
+	user, signers, tx, err := s.prepare(ctx, req)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+
+	hash, err := submitTx(user, signers, tx)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+	return &pb.SendTransactionResponse{Hash: hash[:]}, nil
+}
+
+func (s *transactionServer) SimulateTransaction(ctx context.Context, req *pb.SendTransactionRequest) (*pb.SimulateTransactionResponse, error) {
+	user, signers, tx, err := s.prepare(ctx, req)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+
+	Response := SimulateTxResponse{}
+	if err = prepareTx(user, signers, &tx); err != nil {
+		Response.Error = ToAPIError(err)
+	} else {
+		simulation := SimulateTxRequest{Tx: tx, ResponseCh: make(chan SimulateTxResponse)}
+		SimulateTxCh <- simulation
+		Response = <-simulation.ResponseCh
+	}
+
+	res := &pb.SimulateTransactionResponse{Valid: Response.Valid}
+	if Response.Error != nil {
+		res.ErrCode, res.Message = Response.Error.Code, Response.Error.Message
+	}
+	for _, balance := range Response.Balances {
+		address := balance.Address
+		res.Balances = append(res.Balances, &pb.ProjectedBalance{
+			Address: address[:],
+			Before:  balance.Before.Cents(),
+			After:   balance.After.Cents(),
+		})
+	}
+	return res, nil
+}
+
+func (s *transactionServer) GetTransaction(ctx context.Context, req *pb.GetTransactionRequest) (*pb.TransactionInfo, error) {
+	hash := Hash{}
+	if len(req.GetHash()) != len(hash) {
+		return nil, GRPCError(InvalidParamError.WithDetails(map[string]interface{}{"parameter": "hash"}))
+	}
+	copy(hash[:], req.GetHash())
+	user, err := grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+
+	Request := GetTxByHashRequest{Hash: hash, ResponseCh: make(chan TxLookup)}
+	GetTxByHashCh <- Request
+	lookup := <-Request.ResponseCh
+	ok, err := lookupReadable(user, lookup)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+	// txs of others are not found, so their existence is not disclosed
+	if !ok {
+		return nil, status.Error(codes.NotFound, "unknown transaction")
+	}
+
+	res := &pb.TransactionInfo{Tx: txToPB(lookup.Tx), Index: int32(lookup.Index)}
+	if lookup.Block != nil {
+		number, blockHash := int64(lookup.Block.Number), lookup.Block.GetHash()
+		res.BlockNumber, res.BlockHash = &number, blockHash[:]
+	}
+	return res, nil
+}
+
+func (s *transactionServer) ListTransactions(req *pb.ListTransactionsRequest, stream pb.TransactionService_ListTransactionsServer) error {
+	user, err := grpcAuthenticate(stream.Context())
+	if err != nil {
+		return GRPCError(err)
+	}
+
+	Request := GetTransactionsWithFiltersRequest{
+		TxTypes:       req.GetTypes(),
+		TimeStampFrom: req.Since,
+		TimeStampTo:   req.Until,
+		ResponseCh:    make(chan Transactions),
+	}
+	for _, filter := range []struct {
+		address []byte
+		account **Account
+	}{{req.GetFrom(), &Request.From}, {req.GetTo(), &Request.To}} {
+		if len(filter.address) == 0 {
+			continue
+		}
+		if *filter.account, err = accountFromPB(&pb.Account{Address: filter.address}); err != nil {
+			return GRPCError(err)
+		}
+	}
+
+	txs, err := filterTxs(user, Request)
+	if err != nil {
+		return GRPCError(err)
+	}
+	for _, tx := range txs {
+		if err = stream.Send(txToPB(tx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *blockServer) GetBlock(ctx context.Context, req *pb.GetBlockRequest) (*pb.Block, error) {
+	Request := GetBlockRequest{Number: LatestBlock}
+	switch selector := req.GetSelector().(type) {
+	case *pb.GetBlockRequest_Number:
+		Request.Number = utils.BlockNumber(selector.Number)
+	case *pb.GetBlockRequest_Hash:
+		hash := Hash{}
+		if len(selector.Hash) != len(hash) {
+			return nil, GRPCError(InvalidParamError.WithDetails(map[string]interface{}{"parameter": "hash"}))
+		}
+		copy(hash[:], selector.Hash)
+		Request.Hash = &hash
+	}
+	readable, err := grpcReadable(ctx)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+
+	block := getBlock(Request)
+	if block == nil {
+		return nil, status.Error(codes.NotFound, "unknown block")
+	}
+	return blockToPB(block, readable), nil
+}
+
+func (s *blockServer) StreamBlocks(req *pb.StreamBlocksRequest, stream pb.BlockService_StreamBlocksServer) error {
+	readable, err := grpcReadable(stream.Context())
+	if err != nil {
+		return GRPCError(err)
+	}
+
+	ticker := time.NewTicker(EpochDuration)
+	defer ticker.Stop()
+
+	number := utils.BlockNumber(req.GetFromNumber())
+	if number < 0 {
+		number = 0
+	}
+	for {
+		if block := getBlock(GetBlockRequest{Number: number}); block != nil {
+			if err := stream.Send(blockToPB(block, readable)); err != nil {
+				return err
+			}
+			number++
+			continue
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
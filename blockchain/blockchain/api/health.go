@@ -0,0 +1,45 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HealthOK     = "ok"
+	HealthHalted = "halted"
+)
+
+type Health struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	Since  int64  `json:"since"` // unix
+}
+
+var health atomic.Value
+
+func init() {
+	health.Store(Health{Status: HealthOK, Since: time.Now().Unix()})
+}
+
+func setHealth(status, reason string) {
+	if current := GetHealth(); current.Status == status && current.Reason == reason {
+		return
+	}
+	health.Store(Health{Status: status, Reason: reason, Since: time.Now().Unix()})
+}
+
+func GetHealth() Health {
+	return health.Load().(Health)
+}
+
+func HealthCheck(c *gin.Context) {
+	h := GetHealth()
+	if h.Status != HealthOK {
+		c.JSON(http.StatusServiceUnavailable, h)
+		return
+	}
+	c.JSON(http.StatusOK, h)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"sort"
+	"time"
+)
+
+func NewTransactionQueue(size, accountLimit int, ttl time.Duration) TransactionQueue {
+	return TransactionQueue{
+		transactions: make(Transactions, 0, 1),
+		size:         size,
+		accountLimit: accountLimit,
+		ttl:          ttl,
+	}
+}
+
+// Add puts tx to the queue, if the queue is full the tx with the lowest priority is evicted
+func (tq *TransactionQueue) Add(tx *Transaction) (evicted *Transaction, err error) {
+	if tq.accountLimit > 0 && tq.countBySender(txSender(tx)) >= tq.accountLimit {
+		tq.metrics.DroppedAccountLimit++
+		return nil, AccountLimitError
+	}
+
+	if tq.size > 0 && len(tq.transactions) >= tq.size {
+		worst := tq.lowestPriorityIndex()
+		if !txLess(tx, tq.transactions[worst]) {
+			tq.metrics.DroppedFull++
+			return nil, MempoolFullError
+		}
+		evicted = tq.transactions[worst]
+		tq.transactions = append(tq.transactions[:worst], tq.transactions[worst+1:]...)
+		tq.metrics.Evicted++
+	}
+
+	tq.transactions = append(tq.transactions, tx)
+	tq.metrics.Accepted++
+	return evicted, nil
+}
+
+// Revert undoes Add of tx which evicted the given tx, evicted may be nil
+func (tq *TransactionQueue) Revert(tx, evicted *Transaction) {
+	if _, err := tq.Remove(tx.ID); err != nil {
+		return
+	}
+	tq.metrics.Accepted--
+	if evicted != nil {
+		tq.transactions = append(tq.transactions, evicted)
+		tq.metrics.Evicted--
+	}
+}
+
+func (tq *TransactionQueue) Remove(ID int64) (*Transaction, error) {
+	for i, tx := range tq.transactions {
+		if tx.ID == ID {
+			tq.transactions = append(tq.transactions[:i], tq.transactions[i+1:]...)
+			return tx, nil
+		}
+	}
+	return nil, UnknownPendingTxError
+}
+
+// EvictExpired removes transactions which are waiting longer than ttl
+func (tq *TransactionQueue) EvictExpired(now time.Time) Transactions {
+	if tq.ttl <= 0 {
+		return nil
+	}
+	deadline := now.Add(-tq.ttl).Unix()
+
+	expired := make(Transactions, 0)
+	kept := tq.transactions[:0]
+	for _, tx := range tq.transactions {
+		if tx.Timestamp != nil && *tx.Timestamp < deadline {
+			expired = append(expired, tx)
+			continue
+		}
+		kept = append(kept, tx)
+	}
+	tq.transactions = kept
+	tq.metrics.Expired += uint64(len(expired))
+	return expired
+}
+
+func (tq *TransactionQueue) Len() int {
+	return len(tq.transactions)
+}
+
+func (tq *TransactionQueue) Metrics() MempoolMetrics {
+	return tq.metrics
+}
+
+func (tq *TransactionQueue) sortByPriority() {
+	sort.SliceStable(tq.transactions, func(i, j int) bool {
+		return txLess(tq.transactions[i], tq.transactions[j])
+	})
+}
+
+func (tq *TransactionQueue) lowestPriorityIndex() int {
+	index := 0
+	for i, tx := range tq.transactions {
+		if txLess(tq.transactions[index], tx) {
+			index = i
+		}
+	}
+	return index
+}
+
+func (tq *TransactionQueue) countBySender(sender Address) (count int) {
+	for _, tx := range tq.transactions {
+		if txSender(tx) == sender {
+			count++
+		}
+	}
+	return count
+}
+
+// txLess reports whether a must be included in a block before b, higher fee first, then older
+func txLess(a, b *Transaction) bool {
+	if fa, fb := a.GetFee(), b.GetFee(); !fa.Equal(&fb) {
+		return fa.GreeterThen(&fb)
+	}
+	if ta, tb := txTimestamp(a), txTimestamp(b); ta != tb {
+		return ta < tb
+	}
+	return a.ID < b.ID
+}
+
+func txTimestamp(tx *Transaction) int64 {
+	if tx.Timestamp == nil {
+		return 0
+	}
+	return *tx.Timestamp
+}
+
+func txSender(tx *Transaction) Address {
+	if tx.From != nil {
+		return tx.From.Address
+	}
+	if tx.To != nil {
+		return tx.To.Address
+	}
+	return Address{}
+}
@@ -0,0 +1,604 @@
+package api
+
+import (
+	"IS/blockchain/config"
+	dbtypes "IS/blockchain/database_utils/types"
+	"IS/utils"
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/mail"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	NotifyReceived          = "received"
+	NotifyScheduledExecuted = "scheduled_executed"
+	NotifyScheduledFailed   = "scheduled_failed"
+	NotifyThreshold         = "threshold"
+	NotifySecurity          = "security"
+
+	notificationsTick = time.Minute // quiet hours are checked once a minute
+	quietHoursLayout  = "15:04"
+
+	notificationsQueueSize = 256 // rendered messages waiting for channels
+	emailConfirmationTTL   = 24 * time.Hour
+)
+
+// notificationTemplates has "<kind>.subject" and "<kind>.text" for every kind
+var notificationTemplates = template.Must(template.New("notifications").Funcs(template.FuncMap{"value": formatValue}).Parse(`
+{{define "received.subject"}}Received {{value .Value}}{{end}}
+{{define "received.text"}}Received {{value .Value}} from {{.Counterparty}}{{end}}
+{{define "scheduled_executed.subject"}}Scheduled transaction #{{.TxID}} executed{{end}}
+{{define "scheduled_executed.text"}}Scheduled transaction #{{.TxID}} executed in block {{.BlockNumber}}{{end}}
+{{define "scheduled_failed.subject"}}Scheduled transaction #{{.TxID}} failed{{end}}
+{{define "scheduled_failed.text"}}Scheduled transaction #{{.TxID}} failed: {{.Reason}}{{end}}
+{{define "threshold.subject"}}Balance is {{if .Above}}above{{else}}below{{end}} {{value .Threshold}}{{end}}
+{{define "threshold.text"}}Balance is {{if .Above}}above{{else}}below{{end}} {{value .Threshold}}: {{value .Value}}{{end}}
+{{define "security.subject"}}Security alert{{end}}
+{{define "security.text"}}
+{{- if eq .Event "login_failed"}}Failed login attempt: {{index .Details "reason"}}
+{{- else if eq .Event "two_factor_changed"}}Two-factor settings were changed
+{{- else if eq .Event "telegram_link"}}Telegram account was linked
+{{- else if eq .Event "role_changed"}}Your role was changed to {{index .Details "role"}}
+{{- else}}Security event: {{.Event}}{{end}}
+{{- end}}
+`))
+
+type (
+	NotificationSettings struct {
+		Username    string      `bson:"username" json:"-"`
+		Address     string      `bson:"address,omitempty" json:"-"`     // hex, settings are looked up by it
+		Received    bool        `bson:"received" json:"received"`       // incoming funds
+		Conditional bool        `bson:"conditional" json:"conditional"` // scheduled tx executed or failed
+		Security    bool        `bson:"security" json:"security"`       // failed logins, 2FA, role and telegram changes
+		Thresholds  []Value_    `bson:"thresholds" json:"thresholds"`   // balance crossing any of them
+		QuietHours  *QuietHours `bson:"quietHours,omitempty" json:"quietHours,omitempty"`
+		Telegram    bool        `bson:"telegram" json:"telegram"`               // to the linked chat
+		Email       string      `bson:"email,omitempty" json:"email,omitempty"` // no emails if empty
+
+		// a new email is used after the link sent to it is opened
+		PendingEmail        string `bson:"pendingEmail,omitempty" json:"pendingEmail,omitempty"`
+		EmailToken          string `bson:"emailToken,omitempty" json:"-"`
+		EmailTokenExpiresAt int64  `bson:"emailTokenExpiresAt,omitempty" json:"-"` // unix
+	}
+
+	// QuietHours hold notifications until the period ends, To before From spans midnight
+	QuietHours struct {
+		From     string `bson:"from" json:"from"` // HH:MM
+		To       string `bson:"to" json:"to"`
+		TimeZone string `bson:"timeZone,omitempty" json:"timeZone,omitempty"` // IANA name, UTC if empty
+	}
+
+	// Notification is rendered by notificationTemplates, fields unused by its kind are empty
+	Notification struct {
+		Kind         string
+		Address      Address // account the notification is about
+		Value        Value_  // received amount or balance after threshold crossing
+		Counterparty string
+		TxID         int64
+		BlockNumber  utils.BlockNumber
+		Reason       string
+		Threshold    Value_
+		Above        bool
+		Event        string // audit event type
+		Details      map[string]string
+	}
+
+	NotificationRecipient struct {
+		User     dbtypes.User
+		Settings *NotificationSettings
+	}
+
+	// RecipientLookup returns users of the addresses who may be notified
+	RecipientLookup func(addrs []Address) ([]NotificationRecipient, error)
+
+	// NotificationChannel delivers rendered notifications to a user
+	NotificationChannel interface {
+		Name() string
+		// Send returns false if the user can't be reached over the channel
+		Send(user *dbtypes.User, settings *NotificationSettings, subject, text string) (bool, error)
+	}
+
+	outgoingNotification struct {
+		user          dbtypes.User
+		settings      *NotificationSettings
+		subject, text string
+	}
+
+	heldNotifications struct {
+		user     dbtypes.User
+		settings *NotificationSettings
+		notes    []Notification
+	}
+
+	// Notifier turns chain and security events into notifications over its channels,
+	// events are handled in one goroutine so its state needs no locks, messages are
+	// sent by another one so slow channels don't hold events
+	Notifier struct {
+		channels   []NotificationChannel
+		recipients RecipientLookup
+		outbox     chan outgoingNotification
+
+		pending []Notification                // of the block being finalized
+		touched map[Address]struct{}          // accounts changed by the block
+		held    map[string]*heldNotifications // by username
+	}
+
+	telegramChannel struct {
+		bot *TelegramBot
+	}
+
+	emailChannel struct {
+		mailer *SMTPMailer
+	}
+)
+
+var (
+	notificationMailer *SMTPMailer // nil if SMTP isn't configured
+	publicURL          string
+)
+
+func notificationAddress(username string) string {
+	addr := CalculatePublicKeyByUsername(username)
+	return utils.ToHex(addr[:])
+}
+
+func DefaultNotificationSettings(username string) *NotificationSettings {
+	return &NotificationSettings{Username: username, Received: true, Conditional: true, Security: true,
+		Thresholds: make([]Value_, 0), Telegram: true}
+}
+
+// wants reports whether the user enabled notifications of the kind
+func (s *NotificationSettings) wants(note Notification) bool {
+	switch note.Kind {
+	case NotifyReceived:
+		return s.Received
+	case NotifyScheduledExecuted, NotifyScheduledFailed:
+		return s.Conditional
+	case NotifySecurity:
+		return s.Security
+	}
+	return true // thresholds are requested explicitly
+}
+
+func (q *QuietHours) Validate() error {
+	from, errFrom := time.Parse(quietHoursLayout, q.From)
+	to, errTo := time.Parse(quietHoursLayout, q.To)
+	if errFrom != nil || errTo != nil || from.Equal(to) {
+		return InvalidParamError.WithDetails(map[string]interface{}{"parameter": "quietHours"})
+	}
+	if _, err := time.LoadLocation(q.TimeZone); err != nil {
+		return InvalidParamError.WithDetails(map[string]interface{}{"parameter": "timeZone"})
+	}
+	return nil
+}
+
+// Contains reports whether t is within quiet hours, the hours must be valid
+func (q *QuietHours) Contains(t time.Time) bool {
+	location, _ := time.LoadLocation(q.TimeZone)
+	from, _ := time.Parse(quietHoursLayout, q.From)
+	to, _ := time.Parse(quietHoursLayout, q.To)
+
+	local := t.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	start, end := from.Hour()*60+from.Minute(), to.Hour()*60+to.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// CrossedThresholds returns thresholds between the balances, a balance reaching
+// a threshold crosses it
+func CrossedThresholds(before, after Value_, thresholds []Value_) (res []Value_) {
+	b, a := before.Cents(), after.Cents()
+	for _, threshold := range thresholds {
+		t := threshold.Cents()
+		if b < t && a >= t || b >= t && a < t {
+			res = append(res, threshold)
+		}
+	}
+	return res
+}
+
+func RenderNotification(note Notification) (subject, text string, err error) {
+	var buf strings.Builder
+	if err = notificationTemplates.ExecuteTemplate(&buf, note.Kind+".subject", note); err != nil {
+		return "", "", err
+	}
+	subject = buf.String()
+	buf.Reset()
+	if err = notificationTemplates.ExecuteTemplate(&buf, note.Kind+".text", note); err != nil {
+		return "", "", err
+	}
+	return subject, buf.String(), nil
+}
+
+// renderDigest joins notifications into one message, the subject of a single one is kept
+func renderDigest(notes []Notification, held bool) (subject, text string) {
+	texts := make([]string, 0, len(notes)+1)
+	if held {
+		texts = append(texts, "While quiet hours were on:")
+	}
+	for _, note := range notes {
+		noteSubject, noteText, err := RenderNotification(note)
+		if err != nil {
+			log.Error("can't render notification", "kind", note.Kind, "err", err)
+			continue
+		}
+		subject, texts = noteSubject, append(texts, noteText)
+	}
+	if len(notes) > 1 {
+		subject = fmt.Sprintf("%d notifications", len(notes))
+	}
+	return subject, strings.Join(texts, "\n")
+}
+
+// StartNotifier runs notifications over the telegram bot and SMTP until ctx is done,
+// nil if neither is configured. The bot must be started first
+func StartNotifier(ctx context.Context, cfg *config.Config) <-chan struct{} {
+	channels := make([]NotificationChannel, 0, 2)
+	if telegramBot != nil {
+		channels = append(channels, &telegramChannel{telegramBot})
+	}
+	if cfg.SMTPHost != "" {
+		notificationMailer = NewSMTPMailer(cfg)
+		channels = append(channels, &emailChannel{notificationMailer})
+	}
+	publicURL = cfg.PublicURL
+	if publicURL == "" {
+		publicURL = "http://" + cfg.HttpAddress + ":" + cfg.HttpPort
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+	if err := BackfillNotificationAddresses(); err != nil {
+		log.Error("can't backfill notification addresses", "err", err)
+	}
+
+	notifier := NewNotifier(GetNotificationRecipients, channels...)
+	sub := Events.Subscribe(EventBufferSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tick := time.NewTicker(notificationsTick)
+		defer tick.Stop()
+		notifier.Run(ctx, sub, tick.C)
+	}()
+	return done
+}
+
+func NewNotifier(recipients RecipientLookup, channels ...NotificationChannel) *Notifier {
+	return &Notifier{
+		channels:   channels,
+		recipients: recipients,
+		outbox:     make(chan outgoingNotification, notificationsQueueSize),
+		touched:    make(map[Address]struct{}),
+		held:       make(map[string]*heldNotifications),
+	}
+}
+
+// Run notifies about events of sub until ctx is done and queued messages are sent,
+// held notifications are released on ticks and lost on stop. It must be called once
+func (n *Notifier) Run(ctx context.Context, sub *Subscription, ticks <-chan time.Time) {
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		n.deliver()
+	}()
+	defer func() {
+		close(n.outbox)
+		<-delivered
+	}()
+
+	defer func() {
+		sub.Unsubscribe()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticks:
+			n.releaseHeld(now)
+		case event, ok := <-sub.C:
+			if !ok {
+				log.Error("notifier lost events, resubscribing")
+				sub = sub.bus.Subscribe(EventBufferSize)
+				continue
+			}
+			n.handle(event)
+		}
+	}
+}
+
+func (n *Notifier) handle(event Event) {
+	switch event.Type {
+	case EventTx:
+		tx := event.Tx
+		for addr, delta := range tx.GetBalanceDelta() {
+			n.touched[addr] = struct{}{}
+			if delta.Cents() > 0 && tx.TxType != Obtaining && addr != txSender(tx) {
+				n.pending = append(n.pending, Notification{Kind: NotifyReceived, Address: addr, Value: delta,
+					Counterparty: utils.ToHex(tx.From.Address[:])})
+			}
+		}
+		if tx.IsScheduled() {
+			n.pending = append(n.pending, Notification{Kind: NotifyScheduledExecuted, Address: txSender(tx),
+				TxID: tx.ID, BlockNumber: *event.BlockNumber})
+		}
+	case EventTxStatus:
+		if event.Status.Status != TxStatusFailed {
+			return
+		}
+		// due txs may all fail, then there is no block to wait for
+		n.notify([]Notification{{Kind: NotifyScheduledFailed, Address: txSender(event.Tx), TxID: event.Tx.ID,
+			Reason: event.Status.Reason}}, nil, nil)
+	case EventAudit:
+		if note, ok := securityNotification(event.Audit); ok {
+			n.notify([]Notification{note}, nil, nil)
+		}
+	case EventBlock:
+		touched := n.touched
+		pending := n.pending
+		n.touched, n.pending = make(map[Address]struct{}), nil
+		n.notify(pending, touched, event.Block)
+	}
+}
+
+// securityNotification is addressed to the user the audit event is about
+func securityNotification(event *AuditEvent) (Notification, bool) {
+	username := event.Actor
+	switch event.Type {
+	case AuditLoginFailed, AuditTwoFactorChanged, AuditTelegramLink:
+	case AuditRoleChanged:
+		username = event.Details["username"]
+	default:
+		return Notification{}, false
+	}
+	return Notification{Kind: NotifySecurity, Address: CalculatePublicKeyByUsername(username),
+		Event: event.Type, Details: event.Details}, true
+}
+
+// notify sends notifications and threshold crossings of touched accounts to reachable users
+func (n *Notifier) notify(pending []Notification, touched map[Address]struct{}, block *BlockSummary) {
+	if len(pending) == 0 && len(touched) == 0 {
+		return
+	}
+	affected := make(map[Address]struct{}, len(touched)+len(pending))
+	for addr := range touched {
+		affected[addr] = struct{}{}
+	}
+	for _, note := range pending {
+		affected[note.Address] = struct{}{}
+	}
+	addrs := make([]Address, 0, len(affected))
+	for addr := range affected {
+		addrs = append(addrs, addr)
+	}
+	recipients, err := n.recipients(addrs)
+	if err != nil {
+		log.Error("can't load notification recipients", "err", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range recipients {
+		user, settings := &recipients[i].User, recipients[i].Settings
+		addr := CalculatePublicKeyByUsername(user.Username)
+		var own []Notification
+		for _, note := range pending {
+			if note.Address == addr {
+				own = append(own, note)
+			}
+		}
+		_, changed := touched[addr]
+
+		var notes []Notification
+		for _, note := range own {
+			if settings.wants(note) {
+				notes = append(notes, note)
+			}
+		}
+		if changed && block != nil && len(settings.Thresholds) != 0 {
+			notes = append(notes, thresholdNotifications(addr, block.Number, settings.Thresholds)...)
+		}
+		if len(notes) == 0 {
+			continue
+		}
+
+		if settings.QuietHours != nil && settings.QuietHours.Contains(now) {
+			held, ok := n.held[user.Username]
+			if !ok {
+				held = &heldNotifications{}
+				n.held[user.Username] = held
+			}
+			held.user, held.settings = *user, settings
+			held.notes = append(held.notes, notes...)
+			continue
+		}
+		n.send(user, settings, notes, false)
+	}
+}
+
+// send queues the digest of notifications, it is dropped if the queue is full
+func (n *Notifier) send(user *dbtypes.User, settings *NotificationSettings, notes []Notification, held bool) {
+	subject, text := renderDigest(notes, held)
+	select {
+	case n.outbox <- outgoingNotification{user: *user, settings: settings, subject: subject, text: text}:
+	default:
+		log.Warn("notification queue is full, notification dropped", "username", user.Username)
+	}
+}
+
+func (n *Notifier) deliver() {
+	for msg := range n.outbox {
+		for _, channel := range n.channels {
+			if _, err := channel.Send(&msg.user, msg.settings, msg.subject, msg.text); err != nil {
+				log.Warn("can't send notification", "channel", channel.Name(), "username", msg.user.Username, "err", err)
+			}
+		}
+	}
+}
+
+// thresholdNotifications compares balances before and after the block
+func thresholdNotifications(addr Address, number utils.BlockNumber, thresholds []Value_) []Notification {
+	balanceAt := func(number utils.BlockNumber) Value_ {
+		if number < 0 {
+			return Value_{}
+		}
+		Request := GetBalanceRequest{Address: addr, BlockNumber: number, ResponseCh: make(chan GetBalanceBCResponse)}
+		GetBalanceCh <- Request
+		Response := <-Request.ResponseCh
+		if Response.Err != nil {
+			// unknown address has nothing
+			return Value_{}
+		}
+		return Response.Balance
+	}
+
+	before, after := balanceAt(number-1), balanceAt(number)
+	var notes []Notification
+	for _, threshold := range CrossedThresholds(before, after, thresholds) {
+		notes = append(notes, Notification{Kind: NotifyThreshold, Address: addr, Value: after,
+			Threshold: threshold, Above: !after.LessThen(&threshold)})
+	}
+	return notes
+}
+
+func (n *Notifier) releaseHeld(now time.Time) {
+	for username, held := range n.held {
+		if held.settings.QuietHours.Contains(now) {
+			continue
+		}
+		delete(n.held, username)
+		n.send(&held.user, held.settings, held.notes, true)
+	}
+}
+
+func (c *telegramChannel) Name() string {
+	return "telegram"
+}
+
+func (c *telegramChannel) Send(user *dbtypes.User, settings *NotificationSettings, _, text string) (bool, error) {
+	if !settings.Telegram || user.TelegramID == 0 {
+		return false, nil
+	}
+	c.bot.reply(int64(user.TelegramID), text)
+	return true, nil
+}
+
+func (c *emailChannel) Name() string {
+	return "email"
+}
+
+func (c *emailChannel) Send(_ *dbtypes.User, settings *NotificationSettings, subject, text string) (bool, error) {
+	if settings.Email == "" {
+		return false, nil
+	}
+	return true, c.mailer.Send(settings.Email, subject, text)
+}
+
+func GetNotificationSettingsReq(c *gin.Context) {
+	settings, err := GetNotificationSettings(GetUserFromContext(c).Username)
+	if err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// SetNotificationSettings keeps the confirmed email until a new one is confirmed by the link sent to it
+func SetNotificationSettings(c *gin.Context) {
+	username := GetUserFromContext(c).Username
+	settings := DefaultNotificationSettings(username)
+	if err := c.ShouldBindJSON(settings); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+	settings.Username = username
+	for _, threshold := range settings.Thresholds {
+		if !threshold.IsFractionalValid() || threshold.Integer < 0 {
+			RespondError(c, InvalidValueError.WithDetails(map[string]interface{}{"parameter": "thresholds"}))
+			return
+		}
+	}
+	requested := settings.Email
+	if requested != "" {
+		address, err := mail.ParseAddress(requested)
+		if err != nil {
+			RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": "email"}))
+			return
+		}
+		requested = address.Address
+	}
+	if settings.QuietHours != nil {
+		if err := settings.QuietHours.Validate(); err != nil {
+			RespondError(c, err)
+			return
+		}
+	}
+
+	current, err := GetNotificationSettings(username)
+	if err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+	settings.Email, settings.PendingEmail, settings.EmailToken, settings.EmailTokenExpiresAt = requested, "", "", 0
+	confirm := requested != "" && !strings.EqualFold(requested, current.Email)
+	if confirm {
+		if notificationMailer == nil {
+			RespondError(c, EmailUnavailableError)
+			return
+		}
+		settings.Email, settings.PendingEmail = current.Email, requested
+		settings.EmailToken = randomID() + randomID()
+		settings.EmailTokenExpiresAt = time.Now().Add(emailConfirmationTTL).Unix()
+	}
+
+	if err = WriteNotificationSettings(settings); err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+	if confirm {
+		link := publicURL + V1Prefix + "/notifications/email/confirm?token=" + settings.EmailToken
+		text := fmt.Sprintf("Open the link to receive notifications of %s at this address:\n%s\n\nThe link expires in %v.",
+			username, link, emailConfirmationTTL)
+		if err = notificationMailer.Send(requested, "Confirm your email", text); err != nil {
+			log.Warn("can't send email confirmation", "username", username, "err", err)
+			RespondError(c, EmailUnavailableError)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// ConfirmNotificationEmail is opened from the email, the token authorizes it
+func ConfirmNotificationEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		RespondError(c, UnknownEmailConfirmError)
+		return
+	}
+	settings, err := GetNotificationSettingsByEmailToken(token)
+	if err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+	if settings == nil || settings.EmailTokenExpiresAt < time.Now().Unix() {
+		RespondError(c, UnknownEmailConfirmError)
+		return
+	}
+
+	settings.Email, settings.PendingEmail, settings.EmailToken, settings.EmailTokenExpiresAt = settings.PendingEmail, "", "", 0
+	if err = WriteNotificationSettings(settings); err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
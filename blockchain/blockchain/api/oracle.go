@@ -0,0 +1,117 @@
+package api
+
+import (
+	"IS/blockchain/config"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	OracleEntry struct {
+		Value     Value_ `bson:"value" json:"value"`
+		UpdatedAt int64  `bson:"updatedAt" json:"updatedAt"` // unix
+	}
+
+	SetOracleValueRequest struct {
+		Value Value_ `json:"value"`
+	}
+
+	// OracleStore keeps external values reported by admins for oracle conditions,
+	// reported values are stored and loaded when the chain starts
+	OracleStore struct {
+		mu     sync.RWMutex
+		values map[string]OracleEntry
+		maxAge time.Duration // older values don't meet conditions, 0 - no bound
+	}
+)
+
+var Oracles = &OracleStore{values: make(map[string]OracleEntry)}
+
+// loadOracles restores reported values, conditions of future txs depend on them
+func loadOracles(cfg *config.Config) {
+	Oracles.SetMaxAge(cfg.OracleMaxAge)
+	values, err := GetOracleEntries()
+	if err != nil {
+		log.Error("can't load oracle values", "err", err)
+		return
+	}
+	for name, entry := range values {
+		Oracles.Put(name, entry)
+	}
+}
+
+func (s *OracleStore) Set(name string, value Value_) {
+	s.Put(name, OracleEntry{Value: value, UpdatedAt: time.Now().Unix()})
+}
+
+func (s *OracleStore) Put(name string, entry OracleEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[name] = entry
+}
+
+func (s *OracleStore) SetMaxAge(maxAge time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxAge = maxAge
+}
+
+func (s *OracleStore) Get(name string) (OracleEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.values[name]
+	return entry, ok
+}
+
+// Fresh returns the value if it was reported within the max age before now (unix)
+func (s *OracleStore) Fresh(name string, now int64) (OracleEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.values[name]
+	if !ok || s.maxAge > 0 && now-entry.UpdatedAt > int64(s.maxAge/time.Second) {
+		return OracleEntry{}, false
+	}
+	return entry, true
+}
+
+func (s *OracleStore) All() map[string]OracleEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[string]OracleEntry, len(s.values))
+	for name, entry := range s.values {
+		res[name] = entry
+	}
+	return res
+}
+
+func GetOracleValues(c *gin.Context) {
+	c.JSON(http.StatusOK, Oracles.All())
+}
+
+func SetOracleValue(c *gin.Context) {
+	Request := SetOracleValueRequest{}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+	if !Request.Value.IsFractionalValid() {
+		RespondError(c, InvalidValueError.WithDetails(map[string]interface{}{"parameter": "value"}))
+		return
+	}
+
+	// the value is stored first, conditions must not see a value lost on restart
+	entry := OracleEntry{Value: Request.Value, UpdatedAt: time.Now().Unix()}
+	if err := WriteOracleValue(c.Param("name"), entry); err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+	Oracles.Put(c.Param("name"), entry)
+	Audit(AuditOracleValueSet, GetUserFromContext(c).Username, map[string]string{
+		"name":  c.Param("name"),
+		"value": formatValue(Request.Value),
+	})
+	c.JSON(http.StatusOK, "ok")
+}
@@ -0,0 +1,4 @@
+// Package pb contains generated protobuf messages and gRPC stubs of the node services
+package pb
+
+//go:generate protoc -I . --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative node.proto
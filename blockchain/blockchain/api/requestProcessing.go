@@ -3,11 +3,13 @@ package api
 import (
 	hash "IS/blockchain/database_utils/hashing"
 	db_types "IS/blockchain/database_utils/types"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strings"
 )
 
 func getLoginDataFromRequest(w http.ResponseWriter, r *http.Request) (*db_types.LoginData, error) {
@@ -33,3 +35,14 @@ func getLoginDataFromRequest(w http.ResponseWriter, r *http.Request) (*db_types.
 func CalculatePublicKeyByUsername(username string) Address {
 	return hash.HashUsername(username)
 }
+
+// ParseAddress parses hex address with or without 0x prefix
+func ParseAddress(s string) (Address, error) {
+	addr := Address{}
+	decoded, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(decoded) != AddressLen {
+		return addr, InvalidAddressError.WithDetails(map[string]interface{}{"address": s})
+	}
+	copy(addr[:], decoded)
+	return addr, nil
+}
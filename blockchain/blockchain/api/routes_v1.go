@@ -0,0 +1,95 @@
+package api
+
+import (
+	db_types "IS/blockchain/database_utils/types"
+	_ "embed"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const V1Prefix = "/v1"
+
+type Route struct {
+	Method  string
+	Path    string   // gin syntax, relative to the version prefix
+	Auth    bool     // basic auth is required
+	Roles   []string // any role if empty
+	Handler gin.HandlerFunc
+}
+
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// V1Routes is the /v1 REST surface, it must be kept in sync with openapi.json
+func V1Routes() []Route {
+	auditors := []string{db_types.RoleAuditor, db_types.RoleAdmin}
+	admins := []string{db_types.RoleAdmin}
+
+	return []Route{
+		{Method: http.MethodGet, Path: "/health", Handler: HealthCheck},
+		{Method: http.MethodPost, Path: "/users", Handler: CreateUserReq},
+		{Method: http.MethodGet, Path: "/users/:username/address", Handler: GetAddressV1},
+		{Method: http.MethodGet, Path: "/accounts/:address/balance", Handler: GetBalanceV1},
+		{Method: http.MethodGet, Path: "/transactions", Auth: true, Handler: GetTransactionsV1},
+		{Method: http.MethodPost, Path: "/transactions", Handler: SendTx},
+		{Method: http.MethodPost, Path: "/transactions/batch", Handler: SendTxBatch},
+		{Method: http.MethodPost, Path: "/transactions/simulate", Handler: SimulateTx},
+		{Method: http.MethodGet, Path: "/pending", Auth: true, Handler: GetOwnPendingTxs},
+		{Method: http.MethodPut, Path: "/pending/:id", Auth: true, Handler: ReplaceTxV1},
+		{Method: http.MethodDelete, Path: "/pending/:id", Auth: true, Handler: CancelTxV1},
+		{Method: http.MethodGet, Path: "/pending/:id/upcoming", Auth: true, Handler: GetUpcomingOccurrences},
+		{Method: http.MethodGet, Path: "/ws", Auth: true, Handler: WebSocket},
+		{Method: http.MethodGet, Path: "/events", Auth: true, Handler: EventStream},
+		{Method: http.MethodPost, Path: "/webhooks", Auth: true, Handler: CreateWebhook},
+		{Method: http.MethodGet, Path: "/webhooks", Auth: true, Handler: ListWebhooks},
+		{Method: http.MethodDelete, Path: "/webhooks/:id", Auth: true, Handler: DeleteWebhookReq},
+		{Method: http.MethodGet, Path: "/webhooks/:id/deliveries", Auth: true, Handler: GetWebhookDeliveriesReq},
+		{Method: http.MethodPost, Path: "/webhooks/:id/deliveries/:delivery/redeliver", Auth: true, Handler: RedeliverWebhook},
+		{Method: http.MethodGet, Path: "/dead-letters", Auth: true, Handler: GetDeadLetters},
+		{Method: http.MethodGet, Path: "/2fa", Auth: true, Handler: GetTwoFactor},
+		{Method: http.MethodPut, Path: "/2fa", Auth: true, Handler: SetTwoFactor},
+		{Method: http.MethodPost, Path: "/2fa/totp", Auth: true, Handler: EnrollTOTP},
+		{Method: http.MethodPost, Path: "/2fa/totp/verify", Auth: true, Handler: VerifyTOTPEnrollment},
+		{Method: http.MethodGet, Path: "/confirmations", Auth: true, Handler: GetConfirmations},
+		{Method: http.MethodPost, Path: "/confirmations/:id", Auth: true, Handler: ConfirmTx},
+		{Method: http.MethodDelete, Path: "/confirmations/:id", Auth: true, Handler: RejectTx},
+		{Method: http.MethodPost, Path: "/telegram/link", Auth: true, Handler: CreateTelegramLinkCode},
+		{Method: http.MethodGet, Path: "/notifications", Auth: true, Handler: GetNotificationSettingsReq},
+		{Method: http.MethodPut, Path: "/notifications", Auth: true, Handler: SetNotificationSettings},
+		{Method: http.MethodGet, Path: "/notifications/email/confirm", Handler: ConfirmNotificationEmail},
+		{Method: http.MethodPost, Path: "/read-grants", Auth: true, Handler: GrantReadAccessReq},
+		{Method: http.MethodDelete, Path: "/read-grants/:username", Auth: true, Handler: RevokeReadAccessV1},
+		{Method: http.MethodGet, Path: "/audit", Auth: true, Roles: auditors, Handler: GetAuditLogV1},
+		{Method: http.MethodGet, Path: "/admin/users", Auth: true, Roles: admins, Handler: ListUsers},
+		{Method: http.MethodPut, Path: "/admin/users/:username/role", Auth: true, Roles: admins, Handler: SetUserRoleV1},
+		{Method: http.MethodPut, Path: "/admin/frozen/:address", Auth: true, Roles: admins, Handler: FreezeAddressV1},
+		{Method: http.MethodDelete, Path: "/admin/frozen/:address", Auth: true, Roles: admins, Handler: UnfreezeAddressV1},
+		{Method: http.MethodGet, Path: "/admin/mempool", Auth: true, Roles: admins, Handler: GetMempool},
+		{Method: http.MethodGet, Path: "/admin/oracles", Auth: true, Roles: admins, Handler: GetOracleValues},
+		{Method: http.MethodPut, Path: "/admin/oracles/:name", Auth: true, Roles: admins, Handler: SetOracleValue},
+	}
+}
+
+func RegisterV1(router gin.IRouter) {
+	group := router.Group(V1Prefix)
+	for _, route := range V1Routes() {
+		handlers := make([]gin.HandlerFunc, 0, 2)
+		if route.Auth {
+			handlers = append(handlers, Authorize(route.Roles...))
+		}
+		handlers = append(handlers, route.Handler)
+		group.Handle(route.Method, route.Path, handlers...)
+	}
+	group.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", OpenAPISpec)
+	})
+}
+
+// Deprecated marks unversioned route as an alias of the successor
+func Deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+">; rel=\"successor-version\"")
+		c.Next()
+	}
+}
@@ -0,0 +1,501 @@
+package api
+
+import (
+	db_types "IS/blockchain/database_utils/types"
+	"IS/utils"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC 2.0 endpoint with a subset of eth_* methods. Quantities are hex encoded,
+// values are in cents. Raw transaction is hex encoded JSON of Transaction, it is sent on
+// behalf of the user authenticated by HTTP basic auth since transactions aren't signed
+
+const (
+	RPCVersion    = "2.0"
+	RPCBatchLimit = 100
+
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	RPCServerError    = -32000 // APIError, code is in data
+)
+
+type (
+	RPCRequest struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id,omitempty"` // nil - notification
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params,omitempty"`
+	}
+
+	RPCResponse struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  interface{}     `json:"result"` // null result is kept, see MarshalJSON
+		Error   *RPCError       `json:"error,omitempty"`
+	}
+
+	RPCError struct {
+		Code    int         `json:"code"`
+		Message string      `json:"message"`
+		Data    interface{} `json:"data,omitempty"`
+	}
+
+	RPCBlock struct {
+		Number       string        `json:"number"`
+		Hash         string        `json:"hash"`
+		ParentHash   string        `json:"parentHash"`
+		Timestamp    string        `json:"timestamp"`
+		Transactions []interface{} `json:"transactions"` // hashes or RPCTransaction
+	}
+
+	RPCTransaction struct {
+		Hash             string  `json:"hash"`
+		Nonce            string  `json:"nonce"`
+		BlockHash        *string `json:"blockHash"`
+		BlockNumber      *string `json:"blockNumber"`
+		TransactionIndex *string `json:"transactionIndex"`
+		From             *string `json:"from"`
+		To               *string `json:"to"`
+		Value            string  `json:"value"`
+		Fee              string  `json:"fee"`
+		Type             string  `json:"type"`
+		Timestamp        *string `json:"timestamp,omitempty"`
+		Description      string  `json:"description,omitempty"`
+		Legs             []*Leg  `json:"legs,omitempty"`
+	}
+
+	// rpcMethod is called with the authenticated user, nil if JSONRPC is served without
+	// Authorize, nothing private is returned then
+	rpcMethod func(user *db_types.User, params json.RawMessage) (interface{}, error)
+)
+
+var nullID = json.RawMessage("null")
+
+var rpcMethods = map[string]rpcMethod{
+	"eth_blockNumber":          rpcBlockNumber,
+	"eth_getBalance":           rpcGetBalance,
+	"eth_getBlockByNumber":     rpcGetBlockByNumber,
+	"eth_getBlockByHash":       rpcGetBlockByHash,
+	"eth_getTransactionByHash": rpcGetTransactionByHash,
+	"eth_sendRawTransaction":   rpcSendRawTransaction,
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// MarshalJSON keeps exactly one of result and error as the spec requires
+func (r *RPCResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+			Error   *RPCError       `json:"error"`
+		}{r.JSONRPC, r.ID, r.Error})
+	}
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  interface{}     `json:"result"`
+	}{r.JSONRPC, r.ID, r.Result})
+}
+
+func invalidParams(format string, args ...interface{}) *RPCError {
+	return &RPCError{Code: RPCInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+func toRPCError(err error) *RPCError {
+	if rpcErr, ok := err.(*RPCError); ok {
+		return rpcErr
+	}
+	apiErr := ToAPIError(err)
+	data := map[string]interface{}{"err_code": apiErr.Code}
+	if apiErr.Details != nil {
+		data["details"] = apiErr.Details
+	}
+	return &RPCError{Code: RPCServerError, Message: apiErr.Message, Data: data}
+}
+
+// JSONRPC serves users let through by Authorize, txs are scoped like in REST
+func JSONRPC(c *gin.Context) {
+	user := GetUserFromContext(c)
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		if resp := handleRPCMessage(user, body); resp != nil {
+			c.JSON(http.StatusOK, resp)
+		} else {
+			c.Status(http.StatusNoContent)
+		}
+		return
+	}
+
+	var batch []json.RawMessage
+	if err = json.Unmarshal(body, &batch); err != nil {
+		c.JSON(http.StatusOK, rpcErrorResponse(nullID, &RPCError{Code: RPCParseError, Message: "parse error"}))
+		return
+	}
+	if len(batch) == 0 || len(batch) > RPCBatchLimit {
+		c.JSON(http.StatusOK, rpcErrorResponse(nullID, &RPCError{
+			Code:    RPCInvalidRequest,
+			Message: fmt.Sprintf("batch must contain 1-%d requests", RPCBatchLimit),
+		}))
+		return
+	}
+
+	responses := make([]*RPCResponse, 0, len(batch))
+	for _, message := range batch {
+		if resp := handleRPCMessage(user, message); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, responses)
+}
+
+// handleRPCMessage returns nil for notifications
+func handleRPCMessage(user *db_types.User, message json.RawMessage) *RPCResponse {
+	req := RPCRequest{}
+	if err := json.Unmarshal(message, &req); err != nil {
+		if _, syntaxErr := err.(*json.SyntaxError); syntaxErr || !json.Valid(message) {
+			return rpcErrorResponse(nullID, &RPCError{Code: RPCParseError, Message: "parse error"})
+		}
+		return rpcErrorResponse(nullID, &RPCError{Code: RPCInvalidRequest, Message: "invalid request"})
+	}
+	if req.JSONRPC != RPCVersion || req.Method == "" {
+		id := req.ID
+		if id == nil {
+			id = nullID
+		}
+		return rpcErrorResponse(id, &RPCError{Code: RPCInvalidRequest, Message: "invalid request"})
+	}
+
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		if req.ID == nil {
+			return nil
+		}
+		return rpcErrorResponse(req.ID, &RPCError{Code: RPCMethodNotFound, Message: "method not found: " + req.Method})
+	}
+
+	result, err := method(user, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return rpcErrorResponse(req.ID, toRPCError(err))
+	}
+	return &RPCResponse{JSONRPC: RPCVersion, ID: req.ID, Result: result}
+}
+
+func rpcErrorResponse(id json.RawMessage, err *RPCError) *RPCResponse {
+	return &RPCResponse{JSONRPC: RPCVersion, ID: id, Error: err}
+}
+
+// bindParams decodes positional params, the ones after required are optional
+func bindParams(raw json.RawMessage, required int, dst ...interface{}) error {
+	var params []json.RawMessage
+	if len(raw) != 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return invalidParams("params must be an array")
+		}
+	}
+	if len(params) < required || len(params) > len(dst) {
+		return invalidParams("expected %d-%d params, got %d", required, len(dst), len(params))
+	}
+	for i, param := range params {
+		if err := json.Unmarshal(param, dst[i]); err != nil {
+			return invalidParams("invalid param %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func EncodeQuantity(value int64) string {
+	if value < 0 {
+		return "-0x" + strconv.FormatInt(-value, 16)
+	}
+	return "0x" + strconv.FormatInt(value, 16)
+}
+
+func encodeBytes(b []byte) string {
+	return "0x" + hex.EncodeToString(b)
+}
+
+func decodeBytes(s string) ([]byte, error) {
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return nil, fmt.Errorf("hex string without 0x prefix")
+	}
+	return hex.DecodeString(s[2:])
+}
+
+// ParseBlockTag accepts hex block number or one of latest, pending, safe, finalized, earliest
+func ParseBlockTag(tag string) (utils.BlockNumber, error) {
+	switch tag {
+	case "", "latest", "pending", "safe", "finalized":
+		return LatestBlock, nil
+	case "earliest":
+		return 0, nil
+	}
+	if !strings.HasPrefix(tag, "0x") {
+		return 0, fmt.Errorf("invalid block tag %q", tag)
+	}
+	number, err := strconv.ParseInt(tag[2:], 16, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid block number %q", tag)
+	}
+	return utils.BlockNumber(number), nil
+}
+
+func parseHash(s string) (Hash, error) {
+	hash := Hash{}
+	b, err := decodeBytes(s)
+	if err != nil || len(b) != len(hash) {
+		return hash, invalidParams("invalid hash %q", s)
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
+
+func getBlock(req GetBlockRequest) *Block {
+	req.ResponseCh = make(chan *Block)
+	GetBlockCh <- req
+	return <-req.ResponseCh
+}
+
+func rpcBlockNumber(_ *db_types.User, params json.RawMessage) (interface{}, error) {
+	if err := bindParams(params, 0); err != nil {
+		return nil, err
+	}
+	block := getBlock(GetBlockRequest{Number: LatestBlock})
+	if block == nil {
+		return nil, InternalError
+	}
+	return EncodeQuantity(int64(block.Number)), nil
+}
+
+func rpcGetBalance(_ *db_types.User, params json.RawMessage) (interface{}, error) {
+	var address, tag string
+	if err := bindParams(params, 1, &address, &tag); err != nil {
+		return nil, err
+	}
+	addr, err := ParseAddress(address)
+	if err != nil {
+		return nil, invalidParams("invalid address %q", address)
+	}
+	number, err := ParseBlockTag(tag)
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+
+	Request := GetBalanceRequest{Address: addr, BlockNumber: number, ResponseCh: make(chan GetBalanceBCResponse)}
+	GetBalanceCh <- Request
+	Response := <-Request.ResponseCh
+	if Response.Err != nil {
+		if ToAPIError(Response.Err).Is(UnknownAddressError) {
+			return EncodeQuantity(0), nil
+		}
+		return nil, Response.Err
+	}
+	return EncodeQuantity(Response.Balance.Cents()), nil
+}
+
+// rpcReadable returns addresses whose txs user can read, nil - any
+func rpcReadable(user *db_types.User) ([]Address, error) {
+	if user == nil {
+		return []Address{}, nil
+	}
+	return subscriberReadable(user)
+}
+
+func txReadable(tx *Transaction, readable []Address) bool {
+	return readable == nil || tx.involvesAnyOf(readable)
+}
+
+// lookupReadable tells whether user may read the found tx, pending and scheduled txs
+// are seen by their senders only, like in REST
+func lookupReadable(user *db_types.User, lookup TxLookup) (bool, error) {
+	if lookup.Tx == nil || user == nil {
+		return false, nil
+	}
+	if lookup.Block == nil {
+		return txSender(lookup.Tx) == CalculatePublicKeyByUsername(user.Username), nil
+	}
+	readable, err := rpcReadable(user)
+	if err != nil {
+		return false, err
+	}
+	return txReadable(lookup.Tx, readable), nil
+}
+
+func rpcGetBlockByNumber(user *db_types.User, params json.RawMessage) (interface{}, error) {
+	var tag string
+	var full bool
+	if err := bindParams(params, 1, &tag, &full); err != nil {
+		return nil, err
+	}
+	number, err := ParseBlockTag(tag)
+	if err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	return rpcScopedBlock(user, getBlock(GetBlockRequest{Number: number}), full)
+}
+
+func rpcGetBlockByHash(user *db_types.User, params json.RawMessage) (interface{}, error) {
+	var hashStr string
+	var full bool
+	if err := bindParams(params, 1, &hashStr, &full); err != nil {
+		return nil, err
+	}
+	hash, err := parseHash(hashStr)
+	if err != nil {
+		return nil, err
+	}
+	return rpcScopedBlock(user, getBlock(GetBlockRequest{Hash: &hash}), full)
+}
+
+// rpcScopedBlock gives bodies of txs user can read, hashes of the others
+func rpcScopedBlock(user *db_types.User, block *Block, full bool) (interface{}, error) {
+	var readable []Address
+	if full {
+		var err error
+		if readable, err = rpcReadable(user); err != nil {
+			return nil, err
+		}
+	}
+	return rpcBlock(block, full, readable), nil
+}
+
+func rpcGetTransactionByHash(user *db_types.User, params json.RawMessage) (interface{}, error) {
+	var hashStr string
+	if err := bindParams(params, 1, &hashStr); err != nil {
+		return nil, err
+	}
+	hash, err := parseHash(hashStr)
+	if err != nil {
+		return nil, err
+	}
+
+	Request := GetTxByHashRequest{Hash: hash, ResponseCh: make(chan TxLookup)}
+	GetTxByHashCh <- Request
+	lookup := <-Request.ResponseCh
+	ok, err := lookupReadable(user, lookup)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if lookup.Block == nil {
+		return rpcTransaction(lookup.Tx, nil, 0), nil
+	}
+	return rpcTransaction(lookup.Tx, lookup.Block, lookup.Index), nil
+}
+
+func rpcSendRawTransaction(user *db_types.User, params json.RawMessage) (interface{}, error) {
+	var raw string
+	if err := bindParams(params, 1, &raw); err != nil {
+		return nil, err
+	}
+	data, err := decodeBytes(raw)
+	if err != nil {
+		return nil, invalidParams("invalid raw transaction: %v", err)
+	}
+	// credentials never travel in params
+	tx := Transaction{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&tx); err != nil {
+		return nil, invalidParams("raw transaction must be hex encoded JSON of Transaction")
+	}
+	if user == nil {
+		return nil, UnauthorizedError
+	}
+
+	hash, err := submitTx(user, []Address{CalculatePublicKeyByUsername(user.Username)}, tx)
+	if err != nil {
+		return nil, err
+	}
+	return encodeBytes(hash[:]), nil
+}
+
+// rpcBlock returns nil for unknown block to encode it as JSON null, full block has bodies
+// of txs involving readable addresses (nil - any) and hashes of the others
+func rpcBlock(block *Block, full bool, readable []Address) interface{} {
+	if block == nil {
+		return nil
+	}
+
+	hash := block.GetHash()
+	res := RPCBlock{
+		Number:       EncodeQuantity(int64(block.Number)),
+		Hash:         encodeBytes(hash[:]),
+		ParentHash:   encodeBytes(block.ParentHash[:]),
+		Timestamp:    EncodeQuantity(0),
+		Transactions: make([]interface{}, 0, len(block.Transactions)),
+	}
+	if block.TimeStamp != nil {
+		res.Timestamp = EncodeQuantity(*block.TimeStamp)
+	}
+	for i, tx := range block.Transactions {
+		if full && txReadable(tx, readable) {
+			res.Transactions = append(res.Transactions, rpcTransaction(tx, block, i))
+		} else {
+			txHash := tx.GetHash()
+			res.Transactions = append(res.Transactions, encodeBytes(txHash[:]))
+		}
+	}
+	return res
+}
+
+// rpcTransaction converts tx, block is nil for pending tx
+func rpcTransaction(tx *Transaction, block *Block, index int) RPCTransaction {
+	hash := tx.GetHash()
+	fee := tx.GetFee()
+	res := RPCTransaction{
+		Hash:        encodeBytes(hash[:]),
+		Nonce:       EncodeQuantity(tx.ID),
+		Value:       EncodeQuantity(tx.Value.Cents()),
+		Fee:         EncodeQuantity(fee.Cents()),
+		Type:        EncodeQuantity(int64(tx.TxType)),
+		Description: tx.Description,
+		Legs:        tx.Legs,
+	}
+	if block != nil {
+		blockHash := block.GetHash()
+		blockHashStr := encodeBytes(blockHash[:])
+		blockNumber := EncodeQuantity(int64(block.Number))
+		txIndex := EncodeQuantity(int64(index))
+		res.BlockHash, res.BlockNumber, res.TransactionIndex = &blockHashStr, &blockNumber, &txIndex
+	}
+	if tx.From != nil {
+		from := encodeBytes(tx.From.Address[:])
+		res.From = &from
+	}
+	if tx.To != nil {
+		to := encodeBytes(tx.To.Address[:])
+		res.To = &to
+	}
+	if tx.Timestamp != nil {
+		timestamp := EncodeQuantity(*tx.Timestamp)
+		res.Timestamp = &timestamp
+	}
+	return res
+}
@@ -0,0 +1,445 @@
+package api
+
+import (
+	"IS/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EveryDay   = "daily"
+	EveryWeek  = "weekly"
+	EveryMonth = "monthly"
+
+	OnInsufficientFundsSkip  = "skip"  // the occurrence is missed, default
+	OnInsufficientFundsRetry = "retry" // the occurrence is retried every block until funds arrive
+	OnInsufficientFundsStop  = "stop"  // the schedule fails
+
+	UpcomingOccurrencesLimit   = 100
+	UpcomingOccurrencesDefault = 10
+	cronSearchLimit            = 100000 // steps of Next, more than 4 years of skipped days
+)
+
+type (
+	// Schedule makes a future tx recurring, every occurrence is executed as a copy
+	// of the tx with its own ID. Exactly one of EveryBlocks, Every and Cron is set.
+	// Occurrences missed while the node was down aren't repeated
+	Schedule struct {
+		EveryBlocks int64  `bson:"everyBlocks,omitempty" json:"every_blocks,omitempty"`
+		Every       string `bson:"every,omitempty" json:"every,omitempty"`        // daily, weekly or monthly
+		At          string `bson:"at,omitempty" json:"at,omitempty"`              // HH:MM of Every, midnight if empty
+		Weekday     string `bson:"weekday,omitempty" json:"weekday,omitempty"`    // mon-sun, weekly only
+		MonthDay    int    `bson:"monthDay,omitempty" json:"month_day,omitempty"` // 1-31, the last day of shorter months
+		Cron        string `bson:"cron,omitempty" json:"cron,omitempty"`          // minute hour day-of-month month day-of-week
+		TimeZone    string `bson:"timeZone,omitempty" json:"time_zone,omitempty"` // IANA name of Every and Cron, UTC if empty
+
+		Start               *int64 `bson:"start,omitempty" json:"start,omitempty"` // unix, no occurrences before it
+		End                 *int64 `bson:"end,omitempty" json:"end,omitempty"`     // unix, no occurrences after it
+		MaxOccurrences      int    `bson:"maxOccurrences,omitempty" json:"max_occurrences,omitempty"`
+		OnInsufficientFunds string `bson:"onInsufficientFunds,omitempty" json:"on_insufficient_funds,omitempty"`
+
+		// kept by the chain
+		Occurrences int                `bson:"occurrences" json:"occurrences"` // executed
+		Skipped     int                `bson:"skipped" json:"skipped"`         // missed for insufficient funds
+		NextAt      *int64             `bson:"nextAt,omitempty" json:"next_at,omitempty"`
+		NextBlock   *utils.BlockNumber `bson:"nextBlock,omitempty" json:"next_block,omitempty"`
+		Of          int64              `bson:"of,omitempty" json:"of,omitempty"` // recurring tx ID, set in occurrences
+	}
+
+	Occurrence struct {
+		Number int                `json:"number"` // 1 for the first occurrence of the schedule
+		At     *int64             `json:"at,omitempty"`
+		Block  *utils.BlockNumber `json:"block,omitempty"`
+	}
+
+	// dueTx is a tx to execute, recurring is set for occurrences of a recurring tx
+	dueTx struct {
+		tx        *Transaction
+		recurring *Transaction
+	}
+
+	// scheduleProgress is a recurring tx advanced by an occurrence of the block being built
+	scheduleProgress struct {
+		tx       *Transaction
+		finished bool
+	}
+
+	// cronExpr has allowed values of every field
+	cronExpr struct {
+		minute, hour, dom, month, dow map[int]bool
+		domAny, dowAny                bool
+	}
+)
+
+func invalidSchedule(param string) error {
+	return InvalidScheduleError.WithDetails(map[string]interface{}{"parameter": param})
+}
+
+func (s *Schedule) Validate() error {
+	kinds := 0
+	for _, set := range []bool{s.EveryBlocks != 0, s.Every != "", s.Cron != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return InvalidScheduleError.WithDetails(map[string]interface{}{"reason": "exactly one of every_blocks, every and cron is required"})
+	}
+	if s.EveryBlocks < 0 {
+		return invalidSchedule("every_blocks")
+	}
+	if s.Every != "" {
+		if ok, _ := utils.Contains(s.Every, []string{EveryDay, EveryWeek, EveryMonth}); !ok {
+			return invalidSchedule("every")
+		}
+		if _, err := s.clock(); err != nil {
+			return invalidSchedule("at")
+		}
+		if _, ok := weekdays[strings.ToLower(s.Weekday)]; s.Every == EveryWeek && !ok {
+			return invalidSchedule("weekday")
+		}
+		if s.Every == EveryMonth && (s.MonthDay < 1 || s.MonthDay > 31) {
+			return invalidSchedule("month_day")
+		}
+	}
+	if s.Cron != "" {
+		if _, err := parseCron(s.Cron); err != nil {
+			return invalidSchedule("cron")
+		}
+	}
+	if _, err := time.LoadLocation(s.TimeZone); err != nil {
+		return invalidSchedule("time_zone")
+	}
+	if s.Start != nil && s.End != nil && *s.End < *s.Start {
+		return invalidSchedule("end")
+	}
+	if s.MaxOccurrences < 0 {
+		return invalidSchedule("max_occurrences")
+	}
+	if ok, _ := utils.Contains(s.OnInsufficientFunds, []string{"", OnInsufficientFundsSkip, OnInsufficientFundsRetry, OnInsufficientFundsStop}); !ok {
+		return invalidSchedule("on_insufficient_funds")
+	}
+	return nil
+}
+
+// clock returns At as minutes since midnight
+func (s *Schedule) clock() (int, error) {
+	if s.At == "" {
+		return 0, nil
+	}
+	at, err := time.Parse(quietHoursLayout, s.At)
+	if err != nil {
+		return 0, err
+	}
+	return at.Hour()*60 + at.Minute(), nil
+}
+
+// Next returns the first occurrence of a time based schedule after t, the schedule must be valid
+func (s *Schedule) Next(t time.Time) (time.Time, bool) {
+	location, _ := time.LoadLocation(s.TimeZone)
+	local := t.In(location)
+	if s.Cron != "" {
+		expr, _ := parseCron(s.Cron)
+		return expr.next(local)
+	}
+
+	clock, _ := s.clock()
+	hour, minute := clock/60, clock%60
+	year, month, day := local.Date()
+	switch s.Every {
+	case EveryDay:
+		for i := 0; ; i++ {
+			candidate := time.Date(year, month, day+i, hour, minute, 0, 0, location)
+			if candidate.After(t) {
+				return candidate, true
+			}
+		}
+	case EveryWeek:
+		offset := (int(weekdays[strings.ToLower(s.Weekday)]) - int(local.Weekday()) + 7) % 7
+		for i := offset; ; i += 7 {
+			candidate := time.Date(year, month, day+i, hour, minute, 0, 0, location)
+			if candidate.After(t) {
+				return candidate, true
+			}
+		}
+	case EveryMonth:
+		for i := 0; ; i++ {
+			first := time.Date(year, month+time.Month(i), 1, hour, minute, 0, 0, location)
+			monthDay := s.MonthDay
+			if lastDay := first.AddDate(0, 1, -1).Day(); monthDay > lastDay {
+				monthDay = lastDay
+			}
+			candidate := first.AddDate(0, 0, monthDay-1)
+			if candidate.After(t) {
+				return candidate, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func (s *Schedule) byBlocks() bool {
+	return s.EveryBlocks != 0
+}
+
+// start resets the state and plans the first occurrence, false if there is none
+func (s *Schedule) start(now time.Time, lastFinalized utils.BlockNumber) bool {
+	s.Occurrences, s.Skipped, s.NextAt, s.NextBlock, s.Of = 0, 0, nil, nil, 0
+	if s.byBlocks() {
+		next := lastFinalized + utils.BlockNumber(s.EveryBlocks)
+		s.NextBlock = &next
+		return !s.finished(now.Unix())
+	}
+
+	from := now
+	if s.Start != nil && time.Unix(*s.Start, 0).After(now) {
+		from = time.Unix(*s.Start-1, 0)
+	}
+	if !s.planAfter(from) {
+		return false
+	}
+	return !s.finished(now.Unix())
+}
+
+func (s *Schedule) planAfter(t time.Time) bool {
+	next, ok := s.Next(t)
+	if !ok {
+		s.NextAt = nil
+		return false
+	}
+	at := next.Unix()
+	s.NextAt = &at
+	return true
+}
+
+// due reports whether the next occurrence is reached
+func (s *Schedule) due(state *ConditionState) bool {
+	if s.Start != nil && state.Now < *s.Start {
+		return false
+	}
+	if s.byBlocks() {
+		return s.NextBlock != nil && state.LastFinalizedNumber >= *s.NextBlock
+	}
+	return s.NextAt != nil && state.Now >= *s.NextAt
+}
+
+// advance counts the occurrence and plans the next one
+func (s *Schedule) advance(executed bool, state *ConditionState) {
+	if executed {
+		s.Occurrences++
+	} else {
+		s.Skipped++
+	}
+	if s.byBlocks() {
+		next := *s.NextBlock + utils.BlockNumber(s.EveryBlocks)
+		if next <= state.LastFinalizedNumber {
+			next = state.LastFinalizedNumber + utils.BlockNumber(s.EveryBlocks)
+		}
+		s.NextBlock = &next
+		return
+	}
+	s.planAfter(time.Unix(state.Now, 0))
+}
+
+// Number is the number of the next occurrence, in an occurrence it is its own number
+func (s *Schedule) Number() int {
+	return s.Occurrences + s.Skipped + 1
+}
+
+// CatchUp plans the occurrence after the finalized one if s is behind it, it happens when
+// the node stops between writing the block and saving the schedule. False if s is up to date
+func (s *Schedule) CatchUp(finalized *Schedule, state *ConditionState) bool {
+	if finalized.Number() < s.Number() {
+		return false
+	}
+	s.Occurrences, s.Skipped, s.NextAt, s.NextBlock = finalized.Occurrences, finalized.Skipped, finalized.NextAt, finalized.NextBlock
+	s.advance(true, state)
+	return true
+}
+
+// Retries reports whether an occurrence lacking funds is retried at now, retrying doesn't
+// plan the next occurrence, so the end is checked against now
+func (s *Schedule) Retries(now int64) bool {
+	return s.OnInsufficientFunds == OnInsufficientFundsRetry && (s.End == nil || now <= *s.End)
+}
+
+// finished reports whether there are no occurrences left
+func (s *Schedule) finished(now int64) bool {
+	if s.MaxOccurrences != 0 && s.Occurrences+s.Skipped >= s.MaxOccurrences {
+		return true
+	}
+	if s.byBlocks() {
+		return s.End != nil && now > *s.End
+	}
+	return s.NextAt == nil || s.End != nil && *s.NextAt > *s.End
+}
+
+// Upcoming returns up to limit next occurrences, blocks of block based schedules
+// ignore End as block times aren't known in advance
+func (s *Schedule) Upcoming(limit int) []Occurrence {
+	res := make([]Occurrence, 0)
+	number := s.Number()
+	for i := 0; i < limit; i, number = i+1, number+1 {
+		if s.MaxOccurrences != 0 && number > s.MaxOccurrences {
+			break
+		}
+		if s.byBlocks() {
+			if s.NextBlock == nil {
+				break
+			}
+			block := *s.NextBlock + utils.BlockNumber(int64(i)*s.EveryBlocks)
+			res = append(res, Occurrence{Number: number, Block: &block})
+			continue
+		}
+
+		if s.NextAt == nil {
+			break
+		}
+		at := *s.NextAt
+		if i != 0 {
+			next, ok := s.Next(time.Unix(*res[i-1].At, 0))
+			if !ok {
+				break
+			}
+			at = next.Unix()
+		}
+		if s.End != nil && at > *s.End {
+			break
+		}
+		res = append(res, Occurrence{Number: number, At: &at})
+	}
+	return res
+}
+
+// cronWeekdays replaces day names of the day-of-week field with numbers
+var cronWeekdays = func() *strings.Replacer {
+	pairs := make([]string, 0, 2*len(weekdays))
+	for name, day := range weekdays {
+		pairs = append(pairs, name, strconv.Itoa(int(day)))
+	}
+	return strings.NewReplacer(pairs...)
+}()
+
+func parseCron(expr string) (*cronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, invalidSchedule("cron")
+	}
+	res := &cronExpr{}
+	var err error
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := []*map[int]bool{&res.minute, &res.hour, &res.dom, &res.month, &res.dow}
+	fields[4] = cronWeekdays.Replace(strings.ToLower(fields[4]))
+	for i, field := range fields {
+		if *sets[i], err = parseCronField(field, bounds[i][0], bounds[i][1]); err != nil {
+			return nil, err
+		}
+	}
+	if res.dow[7] {
+		res.dow[0] = true // both are Sunday
+	}
+	res.domAny, res.dowAny = fields[2] == "*", fields[4] == "*"
+	return res, nil
+}
+
+// parseCronField supports *, values, ranges, steps and lists of them
+func parseCronField(field string, lo, hi int) (map[int]bool, error) {
+	res := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if base, stepStr, ok := strings.Cut(part, "/"); ok {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return nil, invalidSchedule("cron")
+			}
+			part = base
+		}
+
+		from, to := lo, hi
+		if part != "*" {
+			fromStr, toStr, isRange := strings.Cut(part, "-")
+			var errFrom, errTo error
+			from, errFrom = strconv.Atoi(fromStr)
+			to, errTo = from, nil
+			if isRange {
+				to, errTo = strconv.Atoi(toStr)
+			} else if step != 1 {
+				to = hi // a/n is a-hi/n
+			}
+			if errFrom != nil || errTo != nil || from < lo || to > hi || from > to {
+				return nil, invalidSchedule("cron")
+			}
+		}
+		for v := from; v <= to; v += step {
+			res[v] = true
+		}
+	}
+	return res, nil
+}
+
+// next returns the first matching minute after t, day of month and day of week
+// match either if both are restricted
+func (c *cronExpr) next(t time.Time) (time.Time, bool) {
+	location := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for i := 0; i < cronSearchLimit; i++ {
+		year, month, day := t.Date()
+		switch {
+		case !c.month[int(month)]:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, location)
+		case !c.dayMatches(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, location)
+		case !c.hour[t.Hour()]:
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, location)
+		case !c.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (c *cronExpr) dayMatches(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// GetUpcomingOccurrences lists next occurrences of own recurring tx, "limit" of them
+func GetUpcomingOccurrences(c *gin.Context) {
+	ID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": "id"}))
+		return
+	}
+	limit := UpcomingOccurrencesDefault
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > UpcomingOccurrencesLimit {
+			RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": "limit"}))
+			return
+		}
+	}
+
+	owner := CalculatePublicKeyByUsername(GetUserFromContext(c).Username)
+	Request := GetMempoolRequest{Owner: &owner, ResponseCh: make(chan Mempool)}
+	GetMempoolCh <- Request
+	mempool := <-Request.ResponseCh
+
+	index := indexOfOwnTx(mempool.Scheduled, ID, owner)
+	if index < 0 {
+		RespondError(c, UnknownPendingTxError)
+		return
+	}
+	tx := mempool.Scheduled[index]
+	if tx.Schedule == nil {
+		RespondError(c, NotRecurringError)
+		return
+	}
+	c.JSON(http.StatusOK, tx.Schedule.Upcoming(limit))
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	db_types "IS/blockchain/database_utils/types"
+	"IS/utils"
+	"context"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ssePingPeriod = 15 * time.Second
+
+// eventStream writes events of one client, id of block event is its number, so a client
+// reconnecting with Last-Event-ID gets the blocks after it
+type eventStream struct {
+	c         *gin.Context
+	addresses []Address
+	sent      utils.BlockNumber // last block delivered
+}
+
+// EventStream streams finalized block summaries and txs touching the address query params
+func EventStream(c *gin.Context) {
+	user := GetUserFromContext(c)
+	stream := &eventStream{c: c, sent: LatestBlock}
+
+	readable, err := subscriberReadable(user)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+	for _, param := range c.QueryArray("address") {
+		for _, hexAddr := range strings.Split(param, ",") {
+			addr, err := ParseAddress(hexAddr)
+			if err == nil {
+				err = requireReadable(readable, addr)
+			}
+			if err != nil {
+				RespondError(c, err)
+				return
+			}
+			stream.addresses = append(stream.addresses, addr)
+		}
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		// EventSource can't set headers on the first connection
+		lastEventID = c.Query("lastEventId")
+	}
+	if lastEventID != "" {
+		number, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || number < 0 {
+			RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": "Last-Event-ID"}))
+			return
+		}
+		stream.sent = utils.BlockNumber(number)
+	}
+
+	// subscribe before replay so blocks finalized meanwhile are not lost
+	sub := Events.Subscribe(EventBufferSize)
+	defer sub.Unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if stream.sent != LatestBlock {
+		if !stream.replay(c.Request.Context()) {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	ping := time.NewTicker(ssePingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-ping.C:
+			if _, err := c.Writer.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				// too slow, client resumes from the last block after reconnect
+				return
+			}
+			stream.send(event)
+		}
+		c.Writer.Flush()
+	}
+}
+
+// replay sends stored blocks after the last delivered one
+func (s *eventStream) replay(ctx context.Context) bool {
+	head := getBlock(GetBlockRequest{Number: LatestBlock})
+	if head == nil {
+		return true
+	}
+	for number := s.sent + 1; number <= head.Number; number++ {
+		if ctx.Err() != nil {
+			return false
+		}
+		block := getBlock(GetBlockRequest{Number: number})
+		if block == nil {
+			continue
+		}
+		for _, tx := range block.Transactions {
+			s.send(Event{Type: EventTx, Tx: tx, BlockNumber: &number})
+		}
+		s.send(Event{Type: EventBlock, Block: NewBlockSummary(block)})
+	}
+	return true
+}
+
+func (s *eventStream) send(event Event) {
+	switch event.Type {
+	case EventBlock:
+		if s.sent != LatestBlock && event.Block.Number <= s.sent {
+			return
+		}
+		s.sent = event.Block.Number
+		s.c.Render(-1, sse.Event{Id: strconv.FormatInt(int64(event.Block.Number), 10), Event: EventBlock, Data: event})
+	case EventTx:
+		if s.sent != LatestBlock && *event.BlockNumber <= s.sent {
+			return
+		}
+		if len(s.addresses) == 0 || !event.Tx.involvesAnyOf(s.addresses) {
+			return
+		}
+		s.c.Render(-1, sse.Event{Event: EventTx, Data: event})
+	}
+}
+
+// subscriberReadable returns addresses whose txs user can follow, nil - any
+func subscriberReadable(user *db_types.User) ([]Address, error) {
+	if user.HasRole(db_types.RoleAuditor, db_types.RoleAdmin) {
+		return nil, nil
+	}
+	return readableAddresses(context.Background(), user)
+}
+
+func requireReadable(readable []Address, addr Address) error {
+	if readable == nil {
+		return nil
+	}
+	if ok, _ := utils.Contains(addr, readable); !ok {
+		return ForbiddenError.WithDetails(map[string]interface{}{"address": utils.ToHex(addr[:])})
+	}
+	return nil
+}
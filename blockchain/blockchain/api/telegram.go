@@ -0,0 +1,491 @@
+package api
+
+import (
+	"IS/blockchain/config"
+	db_types "IS/blockchain/database_utils/types"
+	"IS/utils"
+	"context"
+	"crypto/rand"
+	"fmt"
+	tgbotapi "github.com/Syfaro/telegram-bot-api"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/gin-gonic/gin"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TelegramLinkCodeTTL     = 10 * time.Minute
+	TelegramConfirmationTTL = 2 * time.Minute
+
+	tgLinkCodeLen      = 8
+	tgLinkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no look-alike characters
+	tgPollTimeout      = 30                                 // seconds of long polling
+	tgHistoryLen       = 10
+	tgConfirmAction    = "confirm" // callback data is "<action>:<parked tx ID>"
+	tgRejectAction     = "reject"
+	tgHelp             = "/link <code> - link the account, get the code with POST /v1/telegram/link\n" +
+		"/balance - balance of the account\n" +
+		"/history - last transactions\n" +
+		"/send <username or address> <amount> [fee] - transfer after /confirm\n" +
+		"/scheduled - scheduled transactions"
+)
+
+type (
+	TelegramLinkCode struct {
+		Code      string `json:"code"`
+		ExpiresAt int64  `json:"expiresAt"` // unix
+	}
+
+	// TelegramBot serves chat commands of linked users, it polls Bot API for updates
+	TelegramBot struct {
+		api *tgbotapi.BotAPI
+
+		mu            sync.Mutex
+		confirmations map[int64]*tgConfirmation // by chat
+	}
+
+	tgConfirmation struct {
+		tx      Transaction
+		expires time.Time
+	}
+
+	linkCodes struct {
+		mu    sync.Mutex
+		codes map[string]linkCode
+	}
+
+	linkCode struct {
+		username string
+		expires  time.Time
+	}
+
+	// tgTransport sends Bot API requests to the configured server, the library has
+	// api.telegram.org hardcoded
+	tgTransport struct {
+		base *url.URL
+		next http.RoundTripper
+	}
+)
+
+var (
+	telegramLinks = &linkCodes{codes: make(map[string]linkCode)}
+	telegramBot   *TelegramBot // nil if the bot isn't running
+)
+
+// IssueTelegramLinkCode returns one-time code linking the chat which sends it to the user,
+// the previous code of the user is revoked
+func IssueTelegramLinkCode(username string) TelegramLinkCode {
+	telegramLinks.mu.Lock()
+	defer telegramLinks.mu.Unlock()
+
+	now := time.Now()
+	for code, link := range telegramLinks.codes {
+		if link.username == username || now.After(link.expires) {
+			delete(telegramLinks.codes, code)
+		}
+	}
+
+	code := make([]byte, tgLinkCodeLen)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(tgLinkCodeAlphabet))))
+		if err != nil {
+			panic(fmt.Sprintf("crypto/rand failed: %v", err))
+		}
+		code[i] = tgLinkCodeAlphabet[n.Int64()]
+	}
+
+	link := linkCode{username: username, expires: now.Add(TelegramLinkCodeTTL)}
+	telegramLinks.codes[string(code)] = link
+	return TelegramLinkCode{Code: string(code), ExpiresAt: link.expires.Unix()}
+}
+
+// ConsumeTelegramLinkCode returns username the code was issued to, the code can't be used twice
+func ConsumeTelegramLinkCode(code string) (string, bool) {
+	telegramLinks.mu.Lock()
+	defer telegramLinks.mu.Unlock()
+
+	code = strings.ToUpper(strings.TrimSpace(code))
+	link, ok := telegramLinks.codes[code]
+	if !ok {
+		return "", false
+	}
+	delete(telegramLinks.codes, code)
+	if time.Now().After(link.expires) {
+		return "", false
+	}
+	return link.username, true
+}
+
+// CreateTelegramLinkCode gives the code to send to the bot with /link. Linking another chat
+// replaces the Telegram factor, so it needs a code of the current factor when the factor is on
+func CreateTelegramLinkCode(c *gin.Context) {
+	user := GetUserFromContext(c)
+	Request, err := bindOptionalOTP(c)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+	settings, err := GetTwoFactorSettings(user.Username)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+	if settings.Telegram {
+		if err = approveChange(user, settings, Request.Code); err != nil {
+			RespondError(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, IssueTelegramLinkCode(user.Username))
+}
+
+func (t *tgTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = t.base.Scheme, t.base.Host
+	r.URL.Path = strings.TrimSuffix(t.base.Path, "/") + r.URL.Path
+	r.Host = t.base.Host
+	return t.next.RoundTrip(r)
+}
+
+// NewTelegramBot checks the token with getMe on the server at apiURL
+func NewTelegramBot(token, apiURL string) (*TelegramBot, error) {
+	base, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Transport: &tgTransport{base: base, next: http.DefaultTransport},
+		Timeout:   (tgPollTimeout + 10) * time.Second,
+	}
+	botAPI, err := tgbotapi.NewBotAPIWithClient(token, client)
+	if err != nil {
+		return nil, err
+	}
+	return &TelegramBot{api: botAPI, confirmations: make(map[int64]*tgConfirmation)}, nil
+}
+
+// StartTelegramBot runs the bot until ctx is done, nil if the bot is disabled
+func StartTelegramBot(ctx context.Context, cfg *config.Config) (<-chan struct{}, error) {
+	if cfg.ApiOnly {
+		return nil, nil
+	}
+	if cfg.TelegramToken == "" {
+		log.Warn("telegram bot token is not set, the bot is disabled")
+		return nil, nil
+	}
+	bot, err := NewTelegramBot(cfg.TelegramToken, cfg.TelegramAPIURL)
+	if err != nil {
+		return nil, err
+	}
+
+	telegramBot = bot
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bot.Run(ctx)
+	}()
+	return done, nil
+}
+
+// Run polls updates until ctx is done
+func (b *TelegramBot) Run(ctx context.Context) {
+	log.Info("telegram bot is running", "username", b.api.Self.UserName)
+	updateCfg := tgbotapi.NewUpdate(0)
+	updateCfg.Timeout = tgPollTimeout
+
+	for ctx.Err() == nil {
+		updates, err := b.getUpdates(ctx, updateCfg)
+		if err != nil {
+			log.Warn("can't get telegram updates", "err", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(3 * time.Second):
+			}
+			continue
+		}
+		for _, update := range updates {
+			if update.UpdateID >= updateCfg.Offset {
+				updateCfg.Offset = update.UpdateID + 1
+			}
+			if query := update.CallbackQuery; query != nil && query.From != nil {
+				text := b.handleCallback(query)
+				if _, err = b.api.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, text)); err != nil {
+					log.Warn("can't answer telegram callback", "err", err)
+				}
+				if query.Message != nil {
+					b.reply(query.Message.Chat.ID, text)
+				}
+				continue
+			}
+			if update.Message == nil || update.Message.From == nil {
+				continue
+			}
+			b.reply(update.Message.Chat.ID, b.handle(update.Message))
+		}
+	}
+}
+
+// getUpdates returns on ctx cancellation without waiting for the long poll
+func (b *TelegramBot) getUpdates(ctx context.Context, cfg tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	type result struct {
+		updates []tgbotapi.Update
+		err     error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		updates, err := b.api.GetUpdates(cfg)
+		ch <- result{updates, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	case res := <-ch:
+		return res.updates, res.err
+	}
+}
+
+func (b *TelegramBot) reply(chatID int64, text string) {
+	if text == "" {
+		return
+	}
+	if _, err := b.api.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+		log.Warn("can't send telegram message", "chat", chatID, "err", err)
+	}
+}
+
+// handle returns the reply to the message
+func (b *TelegramBot) handle(msg *tgbotapi.Message) string {
+	if !msg.IsCommand() {
+		return "Unknown command\n" + tgHelp
+	}
+	args := strings.Fields(msg.CommandArguments())
+
+	switch msg.Command() {
+	case "start", "help":
+		return tgHelp
+	case "link":
+		return b.link(msg.From.ID, args)
+	}
+
+	user, err := GetUserByTelegramID(msg.From.ID)
+	if err != nil {
+		return "The chat isn't linked to an account, use /link <code>"
+	}
+
+	switch msg.Command() {
+	case "balance":
+		return b.balance(user)
+	case "history":
+		return b.history(user)
+	case "send":
+		return b.send(msg.Chat.ID, user, args)
+	case "confirm":
+		return b.confirm(msg.Chat.ID, user)
+	case "cancel":
+		b.mu.Lock()
+		delete(b.confirmations, msg.Chat.ID)
+		b.mu.Unlock()
+		return "Cancelled"
+	case "scheduled":
+		return b.scheduled(user)
+	default:
+		return "Unknown command\n" + tgHelp
+	}
+}
+
+func (b *TelegramBot) link(telegramID int, args []string) string {
+	if len(args) != 1 {
+		return "Usage: /link <code>"
+	}
+	username, ok := ConsumeTelegramLinkCode(args[0])
+	if !ok {
+		return "The code is invalid or expired"
+	}
+	if err := SetTelegramID(username, telegramID); err != nil {
+		log.Error("can't link telegram account", "username", username, "err", err)
+		return "Can't link the account, try again later"
+	}
+	return "Linked to " + username
+}
+
+func (b *TelegramBot) balance(user *db_types.User) string {
+	Request := GetBalanceRequest{
+		Address:     CalculatePublicKeyByUsername(user.Username),
+		BlockNumber: LatestBlock,
+		ResponseCh:  make(chan GetBalanceBCResponse),
+	}
+	GetBalanceCh <- Request
+	Response := <-Request.ResponseCh
+	if Response.Err != nil {
+		return "Can't get balance: " + ToAPIError(Response.Err).Message
+	}
+	return "Balance: " + formatValue(Response.Balance)
+}
+
+func (b *TelegramBot) history(user *db_types.User) string {
+	owner := CalculatePublicKeyByUsername(user.Username)
+	Request := GetTransactionsWithFiltersRequest{
+		ResponseCh:       make(chan Transactions),
+		AllowedAddresses: []Address{owner},
+	}
+	GetTxsWithFiltersCh <- Request
+	txs := <-Request.ResponseCh
+	if len(txs) == 0 {
+		return "No transactions"
+	}
+	if len(txs) > tgHistoryLen {
+		txs = txs[len(txs)-tgHistoryLen:]
+	}
+
+	lines := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		lines = append(lines, describeTx(tx, owner))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (b *TelegramBot) scheduled(user *db_types.User) string {
+	owner := CalculatePublicKeyByUsername(user.Username)
+	Request := GetMempoolRequest{Owner: &owner, ResponseCh: make(chan Mempool)}
+	GetMempoolCh <- Request
+	mempool := <-Request.ResponseCh
+	if len(mempool.Scheduled) == 0 {
+		return "No scheduled transactions"
+	}
+
+	lines := make([]string, 0, len(mempool.Scheduled))
+	for _, tx := range mempool.Scheduled {
+		lines = append(lines, fmt.Sprintf("#%d %s", tx.ID, describeTx(tx, owner)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// send keeps the transfer until /confirm, fee defaults to the required one
+func (b *TelegramBot) send(chatID int64, user *db_types.User, args []string) string {
+	if len(args) != 2 && len(args) != 3 {
+		return "Usage: /send <username or address> <amount> [fee]"
+	}
+	to, err := ParseAddress(args[0])
+	if err != nil {
+		if !UserExists(context.Background(), args[0]) {
+			return "Unknown recipient " + args[0]
+		}
+		to = CalculatePublicKeyByUsername(args[0])
+	}
+	value, err := ParseValue(args[1])
+	if err != nil {
+		return "Invalid amount " + args[1]
+	}
+
+	tx := Transaction{TxType: Transfer, To: &Account{Address: to}, Value: value}
+	if err = prepareTx(user, nil, &tx); err != nil {
+		return "Can't send: " + ToAPIError(err).Message
+	}
+	fee := feePolicy.RequiredFee(&tx)
+	if len(args) == 3 {
+		if fee, err = ParseValue(args[2]); err != nil {
+			return "Invalid fee " + args[2]
+		}
+	}
+	tx.Fee = &fee
+
+	b.mu.Lock()
+	b.confirmations[chatID] = &tgConfirmation{tx: tx, expires: time.Now().Add(TelegramConfirmationTTL)}
+	b.mu.Unlock()
+	return fmt.Sprintf("Send %s to %s with fee %s?\n/confirm or /cancel",
+		formatValue(value), args[0], formatValue(fee))
+}
+
+func (b *TelegramBot) confirm(chatID int64, user *db_types.User) string {
+	b.mu.Lock()
+	confirmation, ok := b.confirmations[chatID]
+	delete(b.confirmations, chatID)
+	b.mu.Unlock()
+	if !ok || time.Now().After(confirmation.expires) {
+		return "Nothing to confirm, use /send"
+	}
+
+	hash, err := submitTx(user, nil, confirmation.tx)
+	if err != nil {
+		if apiErr := ToAPIError(err); apiErr.Code == ConfirmationRequiredError.Code {
+			return fmt.Sprintf("The transfer needs the second factor, confirmation %v", apiErr.Details["id"])
+		}
+		return "Transaction is rejected: " + ToAPIError(err).Message
+	}
+	return "Sent, hash " + utils.ToHex(hash[:])
+}
+
+// askConfirmation sends buttons confirming parked tx
+func (b *TelegramBot) askConfirmation(chatID int64, parked *ParkedTx) {
+	text := fmt.Sprintf("Confirm transfer of %s", formatValue(parked.Tx.OutgoingValue(CalculatePublicKeyByUsername(parked.Owner))))
+	if parked.Tx.TxType == MultiTransfer {
+		text += fmt.Sprintf(" in %d legs", len(parked.Tx.Legs))
+	} else if parked.Tx.To != nil {
+		text += " to " + utils.ToHex(parked.Tx.To.Address[:])
+	}
+	text += fmt.Sprintf("? It expires at %s UTC", time.Unix(parked.ExpiresAt, 0).UTC().Format("15:04:05"))
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Confirm", tgConfirmAction+":"+parked.ID),
+		tgbotapi.NewInlineKeyboardButtonData("Reject", tgRejectAction+":"+parked.ID),
+	))
+	if _, err := b.api.Send(msg); err != nil {
+		log.Warn("can't ask telegram confirmation", "chat", chatID, "err", err)
+	}
+}
+
+// sendApprovalCode sends the code approving a change of two-factor settings
+func (b *TelegramBot) sendApprovalCode(chatID int64, code string) {
+	b.reply(chatID, fmt.Sprintf("Code %s approves the change of two-factor settings, it expires in %d minutes. "+
+		"Don't share it, and change the password if you didn't request it", code, int(confirmationTTL.Minutes())))
+}
+
+// handleCallback applies the button pressed by the owner of parked tx
+func (b *TelegramBot) handleCallback(query *tgbotapi.CallbackQuery) string {
+	user, err := GetUserByTelegramID(query.From.ID)
+	if err != nil {
+		return "The chat isn't linked to an account"
+	}
+
+	action, ID, _ := strings.Cut(query.Data, ":")
+	switch action {
+	case tgConfirmAction:
+		hash, err := confirmParked(user.Username, ID, FactorTelegram, "")
+		if err != nil {
+			return "Can't confirm: " + ToAPIError(err).Message
+		}
+		return "Confirmed, hash " + utils.ToHex(hash[:])
+	case tgRejectAction:
+		if _, ok := awaitingConfirmation.take(user.Username, ID, ""); !ok {
+			return "Unknown or expired confirmation"
+		}
+		return "Rejected"
+	default:
+		return "Unknown action"
+	}
+}
+
+func describeTx(tx *Transaction, owner Address) string {
+	delta := tx.GetBalanceDelta()[owner]
+	sign := "+"
+	if delta.Cents() < 0 {
+		sign, delta = "-", ValueFromCents(-delta.Cents())
+	}
+
+	var timestamp string
+	if tx.Timestamp != nil {
+		timestamp = time.Unix(*tx.Timestamp, 0).UTC().Format("2006-01-02 15:04") + " "
+	}
+	return timestamp + sign + formatValue(delta)
+}
+
+func formatValue(v Value_) string {
+	return fmt.Sprintf("%d.%02d", v.Integer, v.Fractional)
+}
@@ -0,0 +1,567 @@
+package api
+
+import (
+	db_types "IS/blockchain/database_utils/types"
+	"IS/utils"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FactorTOTP     = "totp"
+	FactorTelegram = "telegram"
+
+	totpPeriod = 30 // seconds
+	totpDigits = 6
+	totpSkew   = 1 // periods accepted before and after the current one
+	totpIssuer = "IS"
+
+	maxOTPAttempts = 5                // invalid codes per parked tx, and per user until the lockout ends
+	otpLockout     = 15 * time.Minute // since the last invalid code of the user
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type (
+	TwoFactorSettings struct {
+		Threshold         *Value_ `bson:"threshold,omitempty" json:"threshold,omitempty"` // nil - transfers aren't parked
+		Telegram          bool    `bson:"telegram" json:"telegram"`                       // confirm button in the linked chat
+		TOTP              bool    `bson:"totp" json:"totp"`                               // enrolled and verified
+		TOTPSecret        string  `bson:"totpSecret,omitempty" json:"-"`
+		PendingTOTPSecret string  `bson:"pendingTotpSecret,omitempty" json:"-"` // until the first valid code
+	}
+
+	TwoFactorRequest struct {
+		Threshold *Value_ `json:"threshold,omitempty"`
+		Telegram  bool    `json:"telegram"`
+		Code      string  `json:"code,omitempty"` // of the current second factor if the change needs approval
+	}
+
+	// ParkedTx is a transfer above the threshold of the sender, it enters the chain after confirmation
+	ParkedTx struct {
+		ID        string      `json:"id"`
+		Owner     string      `json:"-"`
+		Tx        Transaction `json:"tx"`
+		Replaces  *int64      `json:"replaces,omitempty"` // ID of own pending or scheduled tx
+		Factors   []string    `json:"factors"`
+		ExpiresAt int64       `json:"expiresAt"` // unix
+		attempts  int         // invalid codes
+	}
+
+	TOTPEnrollment struct {
+		Secret string `json:"secret"` // base32
+		URI    string `json:"uri"`    // otpauth:// for authenticator apps
+	}
+
+	OTPRequest struct {
+		Code string `json:"code"`
+	}
+
+	// parkedTxs are kept in memory, a restart expires them
+	parkedTxs struct {
+		mu  sync.Mutex
+		txs map[string]*ParkedTx
+	}
+
+	// approvalCodes are sent to the linked chat to approve changes of two-factor settings
+	approvalCodes struct {
+		mu    sync.Mutex
+		codes map[string]approvalCode // by username
+	}
+
+	approvalCode struct {
+		code      string
+		expiresAt int64
+	}
+
+	// otpLimiter locks out second factor checks of a user after maxOTPAttempts invalid codes
+	otpLimiter struct {
+		mu       sync.Mutex
+		failures map[string]otpFailures // by username
+	}
+
+	otpFailures struct {
+		count int
+		last  time.Time
+	}
+)
+
+var (
+	awaitingConfirmation = &parkedTxs{txs: make(map[string]*ParkedTx)}
+	telegramApprovals    = &approvalCodes{codes: make(map[string]approvalCode)}
+	otpAttempts          = &otpLimiter{failures: make(map[string]otpFailures)}
+	confirmationTTL      = 5 * time.Minute
+)
+
+// TOTPCode is RFC 6238 code with SHA-1, 30 seconds period and 6 digits
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/totpPeriod))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// VerifyTOTP returns the time step the code belongs to, the caller must reject steps which are used already
+func VerifyTOTP(secret, code string, t time.Time) (step int64, ok bool) {
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		at := t.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := TOTPCode(secret, at)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return at.Unix() / totpPeriod, true
+		}
+	}
+	return 0, false
+}
+
+// checkTOTP accepts a code of the secret from a time step after the last accepted one of the user
+func checkTOTP(username, secret, code string) error {
+	now := time.Now()
+	if otpAttempts.locked(username, now) {
+		return TooManyOTPAttemptsError
+	}
+	step, ok := VerifyTOTP(secret, code, now)
+	if !ok {
+		otpAttempts.fail(username, now)
+		return InvalidOTPError
+	}
+	fresh, err := UseTOTPStep(username, step)
+	if err != nil {
+		return StorageError
+	}
+	if !fresh {
+		otpAttempts.fail(username, now)
+		return InvalidOTPError
+	}
+	otpAttempts.reset(username)
+	return nil
+}
+
+func NewTOTPSecret() string {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return totpEncoding.EncodeToString(key)
+}
+
+// factors returns second factors user can confirm with now
+func (s *TwoFactorSettings) factors(user *db_types.User) []string {
+	res := make([]string, 0, 2)
+	if s.TOTP {
+		res = append(res, FactorTOTP)
+	}
+	if s.Telegram && user.TelegramID != 0 && telegramBot != nil {
+		res = append(res, FactorTelegram)
+	}
+	return res
+}
+
+// NeedsApproval reports whether next raises or removes the threshold, so fewer transfers are parked,
+// or adds, removes or replaces a factor. The current second factor must approve such changes
+func (s *TwoFactorSettings) NeedsApproval(next *TwoFactorSettings) bool {
+	if !s.TOTP && !s.Telegram {
+		return false // there is nothing to approve with, transfers aren't parked either
+	}
+	if s.Threshold != nil && (next.Threshold == nil || s.Threshold.LessThen(next.Threshold)) {
+		return true
+	}
+	return next.Telegram != s.Telegram || next.TOTP != s.TOTP || next.TOTPSecret != s.TOTPSecret ||
+		next.PendingTOTPSecret != "" && next.PendingTOTPSecret != s.PendingTOTPSecret
+}
+
+// approveChange checks a code of the current second factor: TOTP or the code sent to the linked chat.
+// Without the code one is sent to the chat and SecondFactorRequiredError is returned
+func approveChange(user *db_types.User, settings *TwoFactorSettings, code string) error {
+	factors := settings.factors(user)
+	if len(factors) == 0 {
+		return NoSecondFactorError
+	}
+	telegram, _ := utils.Contains(FactorTelegram, factors)
+	if code == "" {
+		if telegram {
+			telegramBot.sendApprovalCode(int64(user.TelegramID), telegramApprovals.issue(user.Username))
+		}
+		return SecondFactorRequiredError.WithDetails(map[string]interface{}{"factors": factors})
+	}
+
+	now := time.Now()
+	if otpAttempts.locked(user.Username, now) {
+		return TooManyOTPAttemptsError
+	}
+	if telegram && telegramApprovals.consume(user.Username, code, now) {
+		otpAttempts.reset(user.Username)
+		return nil
+	}
+	if settings.TOTP {
+		return checkTOTP(user.Username, settings.TOTPSecret, code)
+	}
+	otpAttempts.fail(user.Username, now)
+	return InvalidOTPError
+}
+
+// parkIfLarge parks tx sending the threshold of the sender or more, replacing tx of the sender
+// if replaces isn't nil. ConfirmationRequiredError with the confirmation is returned then
+func parkIfLarge(user *db_types.User, tx Transaction, replaces *int64) error {
+	outgoing := tx.OutgoingValue(CalculatePublicKeyByUsername(user.Username))
+	if outgoing.Cents() == 0 {
+		return nil
+	}
+	settings, err := GetTwoFactorSettings(user.Username)
+	if err != nil {
+		return err
+	}
+	if settings.Threshold == nil || outgoing.LessThen(settings.Threshold) {
+		return nil
+	}
+	factors := settings.factors(user)
+	if len(factors) == 0 {
+		return NoSecondFactorError
+	}
+
+	parked := awaitingConfirmation.park(user.Username, tx, replaces, factors)
+	if ok, _ := utils.Contains(FactorTelegram, factors); ok {
+		telegramBot.askConfirmation(int64(user.TelegramID), parked)
+	}
+	return ConfirmationRequiredError.WithDetails(map[string]interface{}{
+		"id":        parked.ID,
+		"expiresAt": parked.ExpiresAt,
+		"factors":   factors,
+	})
+}
+
+func (p *parkedTxs) park(owner string, tx Transaction, replaces *int64, factors []string) *ParkedTx {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire(time.Now())
+
+	parked := &ParkedTx{
+		ID:        randomID(),
+		Owner:     owner,
+		Tx:        tx,
+		Replaces:  replaces,
+		Factors:   factors,
+		ExpiresAt: time.Now().Add(confirmationTTL).Unix(),
+	}
+	p.txs[parked.ID] = parked
+	return parked
+}
+
+// take removes tx of the owner awaiting confirmation, empty factor matches any
+func (p *parkedTxs) take(owner, ID, factor string) (*ParkedTx, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire(time.Now())
+
+	parked, ok := p.txs[ID]
+	if !ok || parked.Owner != owner {
+		return nil, false
+	}
+	if ok, _ = utils.Contains(factor, parked.Factors); !ok && factor != "" {
+		return nil, false
+	}
+	delete(p.txs, ID)
+	return parked, true
+}
+
+// fail counts an invalid code for tx of the owner, the tx is dropped after maxOTPAttempts
+func (p *parkedTxs) fail(owner, ID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	parked, ok := p.txs[ID]
+	if !ok || parked.Owner != owner {
+		return
+	}
+	parked.attempts++
+	if parked.attempts >= maxOTPAttempts {
+		delete(p.txs, ID)
+	}
+}
+
+func (p *parkedTxs) owned(owner string) []*ParkedTx {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire(time.Now())
+
+	res := make([]*ParkedTx, 0)
+	for _, parked := range p.txs {
+		if parked.Owner == owner {
+			res = append(res, parked)
+		}
+	}
+	return res
+}
+
+func (p *parkedTxs) expire(now time.Time) {
+	for ID, parked := range p.txs {
+		if now.Unix() >= parked.ExpiresAt {
+			delete(p.txs, ID)
+		}
+	}
+}
+
+// issue replaces the approval code of the user
+func (a *approvalCodes) issue(username string) string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	code := fmt.Sprintf("%0*d", totpDigits, n.Int64())
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.codes[username] = approvalCode{code: code, expiresAt: time.Now().Add(confirmationTTL).Unix()}
+	return code
+}
+
+// consume reports whether the code is issued to the user and isn't expired, it can't be used twice
+func (a *approvalCodes) consume(username, code string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	issued, ok := a.codes[username]
+	if !ok || now.Unix() >= issued.expiresAt {
+		delete(a.codes, username)
+		return false
+	}
+	if !hmac.Equal([]byte(issued.code), []byte(code)) {
+		return false
+	}
+	delete(a.codes, username)
+	return true
+}
+
+func (l *otpLimiter) locked(username string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	failures, ok := l.failures[username]
+	if ok && now.Sub(failures.last) >= otpLockout {
+		delete(l.failures, username)
+		return false
+	}
+	return failures.count >= maxOTPAttempts
+}
+
+func (l *otpLimiter) fail(username string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	failures := l.failures[username]
+	failures.count++
+	failures.last = now
+	l.failures[username] = failures
+}
+
+func (l *otpLimiter) reset(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, username)
+}
+
+// confirmParked sends parked tx to the chain, TOTP factor needs a valid code
+func confirmParked(owner, ID, factor, code string) (Hash, error) {
+	if factor == FactorTOTP {
+		settings, err := GetTwoFactorSettings(owner)
+		if err != nil {
+			return Hash{}, err
+		}
+		if !settings.TOTP {
+			return Hash{}, InvalidOTPError
+		}
+		if err = checkTOTP(owner, settings.TOTPSecret, code); err != nil {
+			if err != StorageError {
+				awaitingConfirmation.fail(owner, ID)
+			}
+			return Hash{}, err
+		}
+	}
+
+	parked, ok := awaitingConfirmation.take(owner, ID, factor)
+	if !ok {
+		return Hash{}, UnknownConfirmationError.WithDetails(map[string]interface{}{"id": ID})
+	}
+	if parked.Replaces != nil {
+		return replaceInChain(CalculatePublicKeyByUsername(owner), *parked.Replaces, parked.Tx)
+	}
+	return sendToChain(parked.Tx)
+}
+
+func GetTwoFactor(c *gin.Context) {
+	settings, err := GetTwoFactorSettings(GetUserFromContext(c).Username)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// SetTwoFactor changes the threshold and Telegram factor, TOTP is enrolled separately.
+// Weakening changes need a code of the current second factor
+func SetTwoFactor(c *gin.Context) {
+	user := GetUserFromContext(c)
+	Request := TwoFactorRequest{}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+	current, err := GetTwoFactorSettings(user.Username)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+
+	settings := *current
+	settings.Threshold, settings.Telegram = Request.Threshold, Request.Telegram
+	if settings.Threshold != nil && (!settings.Threshold.IsFractionalValid() || settings.Threshold.Integer < 0) {
+		RespondError(c, InvalidValueError.WithDetails(map[string]interface{}{"parameter": "threshold"}))
+		return
+	}
+	if settings.Telegram && user.TelegramID == 0 {
+		RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": "telegram", "reason": "telegram isn't linked"}))
+		return
+	}
+	if settings.Threshold != nil && !settings.TOTP && !settings.Telegram {
+		RespondError(c, NoSecondFactorError)
+		return
+	}
+	if current.NeedsApproval(&settings) {
+		if err = approveChange(user, current, Request.Code); err != nil {
+			RespondError(c, err)
+			return
+		}
+	}
+	if settings.Telegram != current.Telegram {
+		// a secret pending from before the change wasn't approved by the factor
+		settings.PendingTOTPSecret = ""
+	}
+
+	if err = WriteTwoFactorSettings(user.Username, &settings); err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+	Audit(AuditTwoFactorChanged, user.Username, map[string]string{"telegram": fmt.Sprint(settings.Telegram)})
+	c.JSON(http.StatusOK, settings)
+}
+
+// bindOptionalOTP reads OTPRequest of the body if there is one
+func bindOptionalOTP(c *gin.Context) (OTPRequest, error) {
+	Request := OTPRequest{}
+	if err := c.ShouldBindJSON(&Request); err != nil && !errors.Is(err, io.EOF) {
+		return Request, InvalidBodyError
+	}
+	return Request, nil
+}
+
+// EnrollTOTP issues a secret, it is enabled by the first valid code. A user with a second factor
+// approves the enrollment with its code, verification of the issued secret needs no approval then
+func EnrollTOTP(c *gin.Context) {
+	user := GetUserFromContext(c)
+	Request, err := bindOptionalOTP(c)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+	current, err := GetTwoFactorSettings(user.Username)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+
+	settings := *current
+	settings.PendingTOTPSecret = NewTOTPSecret()
+	if current.NeedsApproval(&settings) {
+		if err = approveChange(user, current, Request.Code); err != nil {
+			RespondError(c, err)
+			return
+		}
+	}
+	if err = WriteTwoFactorSettings(user.Username, &settings); err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+
+	label := url.PathEscape(totpIssuer + ":" + user.Username)
+	c.JSON(http.StatusOK, TOTPEnrollment{
+		Secret: settings.PendingTOTPSecret,
+		URI:    fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s", label, settings.PendingTOTPSecret, totpIssuer),
+	})
+}
+
+func VerifyTOTPEnrollment(c *gin.Context) {
+	user := GetUserFromContext(c)
+	Request := OTPRequest{}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+	settings, err := GetTwoFactorSettings(user.Username)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+	if settings.PendingTOTPSecret == "" {
+		RespondError(c, InvalidOTPError)
+		return
+	}
+	// the pending secret was approved by the current factor when issued
+	if err = checkTOTP(user.Username, settings.PendingTOTPSecret, Request.Code); err != nil {
+		RespondError(c, err)
+		return
+	}
+
+	settings.TOTP, settings.TOTPSecret, settings.PendingTOTPSecret = true, settings.PendingTOTPSecret, ""
+	if err = WriteTwoFactorSettings(user.Username, settings); err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+	Audit(AuditTwoFactorChanged, user.Username, map[string]string{"totp": "true"})
+	c.JSON(http.StatusOK, settings)
+}
+
+func GetConfirmations(c *gin.Context) {
+	c.JSON(http.StatusOK, awaitingConfirmation.owned(GetUserFromContext(c).Username))
+}
+
+func ConfirmTx(c *gin.Context) {
+	Request := OTPRequest{}
+	if err := c.ShouldBindJSON(&Request); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+	hash, err := confirmParked(GetUserFromContext(c).Username, c.Param("id"), FactorTOTP, Request.Code)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hash": utils.ToHex(hash[:])})
+}
+
+func RejectTx(c *gin.Context) {
+	if _, ok := awaitingConfirmation.take(GetUserFromContext(c).Username, c.Param("id"), ""); !ok {
+		RespondError(c, UnknownConfirmationError.WithDetails(map[string]interface{}{"id": c.Param("id")}))
+		return
+	}
+	c.JSON(http.StatusOK, "ok")
+}
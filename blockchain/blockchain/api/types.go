@@ -4,7 +4,6 @@ import (
 	db_types "IS/blockchain/database_utils/types"
 	"IS/utils"
 	"encoding/json"
-	"fmt"
 	lru "github.com/hashicorp/golang-lru"
 	"go.mongodb.org/mongo-driver/bson"
 	"sync"
@@ -18,25 +17,36 @@ const ( // categories
 	Spending
 	Obtaining
 	Any // api only
+	MultiTransfer
 )
 
-const (
+const ( // condition types, evaluators are registered in conditions.go
 	TimeCond = iota
 	AccountBalanceMoreThen
 	AccountBalanceLessThen
 	AccountSentTransaction
+	ReceivedFrom
+	BlockRange
+	Weekday
+	OracleValue
 )
 
+const LatestBlock utils.BlockNumber = -1
+
 const ( // ETH based
 	HashLen       = 32
 	AddressLen    = 20
 	EpochDuration = 4 * time.Second
 	BlockTxsLimit = 128
-)
+	BatchTxsLimit = 64
+	TxLegsLimit   = 64
+
+	ConditionDepthLimit = 8  // nesting of All, Any and Not
+	ConditionNodesLimit = 32 // filters in the whole tree
 
-var (
-	FutureBlockError    = fmt.Errorf("unknown block")
-	UnknownAddressError = fmt.Errorf("unknown address")
+	FinalizeRetries         = 5 // attempts to write the last block on shutdown
+	FinalizeRetryBackoff    = 100 * time.Millisecond
+	MaxFinalizeRetryBackoff = 30 * time.Second
 )
 
 type ( // primitive types, containers
@@ -58,24 +68,40 @@ type (
 	}
 
 	Transaction struct {
-		ID          int64    `bson:"ID"`
-		Timestamp   *int64   `bson:"timestamp"` // unix
-		From        *Account `bson:"from"`
-		To          *Account `bson:"to" json:"to"`
-		Value       Value_   `bson:"value" json:"value"`
-		Description string   `bson:"description" json:"description"`
-		TxType      uint32   `bson:"txType" json:"txType"`
-		Condition   *Filter  `json:"condition,omitempty" bson:"condition"`
+		ID          int64     `bson:"ID"`
+		Timestamp   *int64    `bson:"timestamp"` // unix
+		From        *Account  `bson:"from"`
+		To          *Account  `bson:"to" json:"to"`
+		Value       Value_    `bson:"value" json:"value"`
+		Description string    `bson:"description" json:"description"`
+		TxType      uint32    `bson:"txType" json:"txType"`
+		Condition   *Filter   `json:"condition,omitempty" bson:"condition"`
+		Fee         *Value_   `json:"fee,omitempty" bson:"fee,omitempty"`
+		Legs        []*Leg    `json:"legs,omitempty" bson:"legs,omitempty"`         // MultiTransfer only
+		Schedule    *Schedule `json:"schedule,omitempty" bson:"schedule,omitempty"` // recurring, Condition is optional then
 	}
 	Transactions []*Transaction
 
+	Leg struct {
+		From  *Account `bson:"from" json:"from,omitempty"`
+		To    *Account `bson:"to" json:"to"`
+		Value Value_   `bson:"value" json:"value"`
+	}
+
+	// Filter is met when its own condition and all of its compound parts are met,
+	// a filter without All, Any and Not is a single condition as before
 	Filter struct {
 		SendAfterBlock     *utils.BlockNumber `json:"send_after"`
 		SendAfterTimestamp *int64             `json:"send_after_timestamp"`
 
-		Type        int      `json:"type"`
-		CondAccount *Account `json:"cond_account"`
-		CondValue   *Value_  `json:"cond_value"`
+		Type        int               `json:"type"`
+		CondAccount *Account          `json:"cond_account"`
+		CondValue   *Value_           `json:"cond_value"`
+		Params      map[string]string `json:"params,omitempty" bson:"params,omitempty"` // of the condition type
+
+		All []*Filter `json:"all,omitempty" bson:"all,omitempty"` // every one is met
+		Any []*Filter `json:"any,omitempty" bson:"any,omitempty"` // at least one is met
+		Not *Filter   `json:"not,omitempty" bson:"not,omitempty"` // isn't met
 	}
 
 	Block struct {
@@ -99,12 +125,26 @@ type (
 		getBalanceCh          chan GetBalanceRequest
 		getTxsWithFiltersCh   chan GetTransactionsWithFiltersRequest
 		saveFutureTransaction chan SendTxBcRequest
+		freezeCh              chan FreezeRequest
+		getMempoolCh          chan GetMempoolRequest
+		cancelTxCh            chan CancelTxRequest
+		replaceTxCh           chan ReplaceTxRequest
+		simulateTxCh          chan SimulateTxRequest
+		getBlockCh            chan GetBlockRequest
+		getTxByHashCh         chan GetTxByHashRequest
 
 		lastFinalizedBlock  *Block
 		lastFinalizedNumber utils.BlockNumber
+		pendingBlock        *Block           // built but not written
+		finalizeFailures    int              // consecutive failed writes of pendingBlock
+		finalizeRetry       <-chan time.Time // fires when pendingBlock is written again, nil without it
 		stateCache          *lru.Cache
 		txQueue             TransactionQueue
 		futureTransactions  Transactions
+		scheduleProgress    []scheduleProgress // made while building the unwritten block, saved after it's written
+		frozen              map[Address]struct{}
+		blockNumbers        map[Hash]utils.BlockNumber // finalized blocks by hash
+		txLocations         map[Hash]TxLocation        // finalized txs by hash
 
 		globalTxID int64
 
@@ -114,10 +154,43 @@ type (
 	SendTxBcRequest struct {
 		Tx         Transaction
 		ResponseCh chan error
+		HashCh     chan Hash // optional, receives hash of the accepted tx before ResponseCh
+	}
+
+	GetBlockRequest struct {
+		Number     utils.BlockNumber // LatestBlock - head, ignored if Hash is set
+		Hash       *Hash
+		ResponseCh chan *Block // nil - unknown block
+	}
+
+	GetTxByHashRequest struct {
+		Hash       Hash
+		ResponseCh chan TxLookup
+	}
+	TxLookup struct {
+		Tx    *Transaction // nil - unknown tx
+		Block *Block       // nil - tx is not finalized
+		Index int
+	}
+	TxLocation struct {
+		BlockNumber utils.BlockNumber
+		Index       int
 	}
 
 	TransactionQueue struct {
 		transactions Transactions
+		size         int // 0 - unlimited
+		accountLimit int // 0 - unlimited
+		ttl          time.Duration
+		metrics      MempoolMetrics
+	}
+
+	MempoolMetrics struct {
+		Accepted            uint64 `json:"accepted"`
+		DroppedFull         uint64 `json:"droppedFull"`
+		DroppedAccountLimit uint64 `json:"droppedAccountLimit"`
+		Evicted             uint64 `json:"evicted"`
+		Expired             uint64 `json:"expired"`
 	}
 
 	GetBalanceRequest struct {
@@ -140,7 +213,19 @@ type (
 
 	SendTxRequest struct {
 		db_types.LoginData
-		Tx Transaction `json:"tx"`
+		CoSigners []db_types.LoginData `json:"cosigners,omitempty"` // senders of MultiTransfer legs
+		Tx        Transaction          `json:"tx"`
+	}
+
+	SendTxBatchRequest struct {
+		db_types.LoginData
+		CoSigners []db_types.LoginData `json:"cosigners,omitempty"`
+		Txs       []Transaction        `json:"txs"`
+	}
+	BatchTxResult struct {
+		Index  int       `json:"index"`
+		Result string    `json:"result"`
+		Error  *APIError `json:"error,omitempty"`
 	}
 
 	GetTransactionsWithFiltersRequest struct {
@@ -150,6 +235,62 @@ type (
 		TimeStampFrom *int64   `json:"timeStampFrom,omitempty"`
 		TimeStampTo   *int64   `json:"timeStampTo,omitempty"`
 		ResponseCh    chan Transactions
+
+		AllowedAddresses []Address `json:"-"` // nil - no restrictions
+	}
+
+	ReadAccessRequest struct {
+		Username string `json:"username"`
+	}
+
+	FreezeRequest struct {
+		Address    Address `json:"address"`
+		Freeze     bool    `json:"-"`
+		ResponseCh chan error
+	}
+
+	GetMempoolRequest struct {
+		Owner      *Address // nil - all transactions
+		ResponseCh chan Mempool
+	}
+
+	CancelTxRequest struct {
+		ID         int64 `json:"id"`
+		Owner      Address
+		ResponseCh chan error
+	}
+
+	SimulateTxRequest struct {
+		Tx         Transaction
+		ResponseCh chan SimulateTxResponse
+	}
+	SimulateTxResponse struct {
+		Valid    bool               `json:"valid"`
+		Error    *APIError          `json:"error,omitempty"`
+		Balances []ProjectedBalance `json:"balances,omitempty"`
+	}
+	ProjectedBalance struct {
+		Address Address `json:"address"`
+		Before  Value_  `json:"before"`
+		After   Value_  `json:"after"`
+	}
+
+	ReplaceTxRequest struct {
+		ID         int64       `json:"id"`
+		Tx         Transaction `json:"tx"`
+		Owner      Address
+		ResponseCh chan error
+		HashCh     chan Hash // optional, receives hash of the new tx before ResponseCh
+	}
+	Mempool struct {
+		Pending   Transactions   `json:"pending"`
+		Scheduled Transactions   `json:"scheduled"`
+		Metrics   MempoolMetrics `json:"metrics"`
+	}
+
+	SetRoleRequest struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
 	}
 )
 
@@ -160,6 +301,8 @@ func (tq *TransactionQueue) Pop() Transaction {
 }
 
 func (tq *TransactionQueue) GetMaxCountAndRemove() Transactions {
+	tq.sortByPriority()
+
 	if len(tq.transactions) <= BlockTxsLimit {
 		res := make(Transactions, len(tq.transactions))
 
@@ -237,6 +380,15 @@ func (v *Value_) Minus(v2 *Value_) Value_ {
 	return newValue
 }
 
+// Cents returns value in the smallest units, negative values have both parts negative
+func (v *Value_) Cents() int64 {
+	return v.Integer*100 + int64(v.Fractional)
+}
+
+func ValueFromCents(cents int64) Value_ {
+	return Value_{Integer: cents / 100, Fractional: int32(cents % 100)}
+}
+
 func (v *Value_) IsFractionalValid() bool {
 	return v.Fractional < 100
 }
@@ -287,31 +439,101 @@ func (acc *Account) GetHeadStateBalance(bc *BlockChain) (*Value_, error) {
 	return &res, nil
 }
 
+func (bc *BlockChain) headStateBalance(addr Address) Value_ {
+	balance, _ := (&Account{Address: addr}).GetHeadStateBalance(bc)
+	return *balance
+}
+
+// IsScheduled reports whether tx waits for its condition or schedule instead of the mempool
+func (tx *Transaction) IsScheduled() bool {
+	return tx.Condition != nil || tx.Schedule != nil
+}
+
 func (tx *Transaction) IsValid(bc *BlockChain) bool {
+	return tx.Validate(bc, bc.headStateBalance) == nil
+}
+
+// Validate checks tx against balances returned by balanceOf and explains the rejection
+func (tx *Transaction) Validate(bc *BlockChain, balanceOf func(Address) Value_) error {
 	if tx.Value.Fractional < 0 || tx.Value.Fractional > 99 {
-		return false
+		return InvalidValueError
+	}
+	if bc.isFrozen(tx.From) || bc.isFrozen(tx.To) {
+		return FrozenAddressError
 	}
+	fee := tx.GetFee()
+	if fee.Integer < 0 || fee.Fractional < 0 || fee.Fractional > 99 {
+		return InvalidFeeError
+	}
+	requiredFee := feePolicy.RequiredFee(tx)
+	if fee.LessThen(&requiredFee) {
+		return InsufficientFeeError.WithDetails(map[string]interface{}{"required": requiredFee})
+	}
+	total := tx.Value.Plus(&fee)
+
 	switch tx.TxType {
 	case Unknown:
-		return false
+		return UnknownTxTypeError
 	case Transfer:
-		balance, err := tx.From.GetHeadStateBalance(bc)
-		if err != nil {
-			return false
+		if tx.From == nil {
+			return MissingSenderError
 		}
-		return tx.From != nil && tx.To != nil && balance.GreeterEqualThen(&tx.Value)
+		if tx.To == nil {
+			return MissingRecipientError
+		}
+		if balance := balanceOf(tx.From.Address); balance.LessThen(&total) {
+			return InsufficientFundsError
+		}
+		return nil
 	case Spending:
-		balance, err := tx.From.GetHeadStateBalance(bc)
-		if err != nil {
-			return false
+		if tx.From == nil {
+			return MissingSenderError
+		}
+		if balance := balanceOf(tx.From.Address); balance.LessThen(&total) {
+			return InsufficientFundsError
 		}
-		return tx.From != nil && balance.GreeterEqualThen(&tx.Value)
+		return nil
 	case Obtaining:
-		return tx.To != nil
+		if tx.To == nil {
+			return MissingRecipientError
+		}
+		return nil
+	case MultiTransfer:
+		return tx.validateMultiTransfer(bc, balanceOf, fee)
 	default:
-		fmt.Println("unknown tx type")
-		return false
+		return UnknownTxTypeError
+	}
+}
+
+// validateMultiTransfer checks that every sender can pay all his legs, the fee is paid by tx.From
+func (tx *Transaction) validateMultiTransfer(bc *BlockChain, balanceOf func(Address) Value_, fee Value_) error {
+	if tx.From == nil {
+		return MissingSenderError
+	}
+	if len(tx.Legs) == 0 || len(tx.Legs) > TxLegsLimit {
+		return InvalidLegError.WithDetails(map[string]interface{}{"maxLegs": TxLegsLimit})
 	}
+
+	outgoing := map[Address]int64{tx.From.Address: fee.Cents()}
+	for i, leg := range tx.Legs {
+		if leg == nil || leg.From == nil || leg.To == nil {
+			return InvalidLegError.WithDetails(map[string]interface{}{"leg": i, "reason": "no sender or recipient"})
+		}
+		if !leg.Value.IsFractionalValid() || leg.Value.Fractional < 0 || leg.Value.Cents() <= 0 {
+			return InvalidLegError.WithDetails(map[string]interface{}{"leg": i, "reason": "invalid value"})
+		}
+		if bc.isFrozen(leg.From) || bc.isFrozen(leg.To) {
+			return FrozenAddressError
+		}
+		outgoing[leg.From.Address] += leg.Value.Cents()
+	}
+
+	for addr, cents := range outgoing {
+		if balance := balanceOf(addr); balance.Cents() < cents {
+			return InsufficientFundsError.WithDetails(map[string]interface{}{"address": utils.ToHex(addr[:])})
+		}
+	}
+	return nil
 }
 
 func (b *Block) MarshalBSON() ([]byte, error) {
@@ -346,21 +568,60 @@ func (b *Block) MarshalJSON() ([]byte, error) {
 	})
 }
 
+func (tx *Transaction) involvesAnyOf(addresses []Address) bool {
+	for _, addr := range addresses {
+		if tx.From != nil && tx.From.Address == addr || tx.To != nil && tx.To.Address == addr {
+			return true
+		}
+		for _, leg := range tx.Legs {
+			if leg.From != nil && leg.From.Address == addr || leg.To != nil && leg.To.Address == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// OutgoingValue is what addr sends by tx without the fee, legs of MultiTransfer are summed up
+func (tx *Transaction) OutgoingValue(addr Address) Value_ {
+	var cents int64
+	switch tx.TxType {
+	case Transfer, Spending:
+		if tx.From != nil && tx.From.Address == addr {
+			cents = tx.Value.Cents()
+		}
+	case MultiTransfer:
+		for _, leg := range tx.Legs {
+			if leg != nil && leg.From != nil && leg.From.Address == addr {
+				cents += leg.Value.Cents()
+			}
+		}
+	}
+	return ValueFromCents(cents)
+}
+
 func (tx *Transaction) GetBalanceDelta() map[Address]Value_ {
 	res := make(map[Address]Value_)
 
+	fee := tx.GetFee()
 	switch tx.TxType {
 	case Transfer:
-		fromDelta := tx.Value
-		fromDelta.Integer *= -1
-		res[tx.From.Address] = fromDelta
+		res[tx.From.Address] = ValueFromCents(-tx.Value.Cents() - fee.Cents())
 		res[tx.To.Address] = tx.Value
 	case Spending:
-		fromDelta := tx.Value
-		fromDelta.Integer *= -1
-		res[tx.From.Address] = fromDelta
+		res[tx.From.Address] = ValueFromCents(-tx.Value.Cents() - fee.Cents())
 	case Obtaining:
 		res[tx.To.Address] = tx.Value
+	case MultiTransfer:
+		// all legs are applied together as a part of the same tx
+		cents := map[Address]int64{tx.From.Address: -fee.Cents()}
+		for _, leg := range tx.Legs {
+			cents[leg.From.Address] -= leg.Value.Cents()
+			cents[leg.To.Address] += leg.Value.Cents()
+		}
+		for addr, delta := range cents {
+			res[addr] = ValueFromCents(delta)
+		}
 	default:
 		//lol
 	}
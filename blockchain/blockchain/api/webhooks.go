@@ -0,0 +1,638 @@
+package api
+
+import (
+	"IS/blockchain/config"
+	"IS/utils"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	WebhookIncoming            = "incoming"
+	WebhookOutgoing            = "outgoing"
+	WebhookConditionalExecuted = "conditional_executed"
+	WebhookConditionalFailed   = "conditional_failed"
+
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead" // attempts are exhausted
+
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+
+	webhookTimeout = 10 * time.Second
+	webhookWorkers = 4
+)
+
+var WebhookEvents = []string{WebhookIncoming, WebhookOutgoing, WebhookConditionalExecuted, WebhookConditionalFailed}
+
+type (
+	Webhook struct {
+		ID        string    `bson:"id" json:"id"`
+		Owner     string    `bson:"owner" json:"-"`
+		URL       string    `bson:"url" json:"url"`
+		Secret    string    `bson:"secret" json:"secret,omitempty"` // returned once on creation
+		Events    []string  `bson:"events" json:"events"`
+		Addresses []Address `bson:"addresses" json:"addresses"`
+		CreatedAt int64     `bson:"createdAt" json:"createdAt"` // unix
+	}
+
+	WebhookPayload struct {
+		DeliveryID  string             `json:"deliveryId"`
+		WebhookID   string             `json:"webhookId"`
+		Event       string             `json:"event"`
+		Address     string             `json:"address"` // watched address the event is about
+		BlockNumber *utils.BlockNumber `json:"blockNumber,omitempty"`
+		Reason      string             `json:"reason,omitempty"`
+		Tx          *Transaction       `json:"tx"`
+	}
+
+	WebhookDelivery struct {
+		ID          string           `bson:"id" json:"id"`
+		WebhookID   string           `bson:"webhookId" json:"webhookId"`
+		Owner       string           `bson:"owner" json:"-"`
+		Event       string           `bson:"event" json:"event"`
+		Payload     string           `bson:"payload" json:"payload"` // signed body
+		Status      string           `bson:"status" json:"status"`
+		Attempt     int              `bson:"attempt" json:"attempt"` // attempts since creation or redelivery
+		NextAttempt int64            `bson:"nextAttempt" json:"nextAttempt,omitempty"`
+		History     []WebhookAttempt `bson:"history" json:"history"`
+		CreatedAt   int64            `bson:"createdAt" json:"createdAt"`
+	}
+
+	WebhookAttempt struct {
+		Timestamp  int64  `bson:"timestamp" json:"timestamp"`
+		StatusCode int    `bson:"statusCode,omitempty" json:"statusCode,omitempty"`
+		Error      string `bson:"error,omitempty" json:"error,omitempty"`
+	}
+
+	// WebhookDispatcher matches chain events against webhooks and delivers them with retries
+	WebhookDispatcher struct {
+		client      *http.Client
+		maxAttempts int
+		backoff     time.Duration
+
+		mu       sync.RWMutex
+		webhooks map[string]*Webhook
+
+		queue chan *WebhookDelivery
+		ctx   context.Context
+	}
+)
+
+var (
+	webhooks *WebhookDispatcher
+
+	// webhookPrivateNets are loopback, private or link-local networks allowed by config
+	webhookPrivateNets []*net.IPNet
+)
+
+func NewWebhookDispatcher(ctx context.Context, maxAttempts int, backoff time.Duration) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		client:      NewWebhookClient(webhookPrivateNets),
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		webhooks:    make(map[string]*Webhook),
+		queue:       make(chan *WebhookDelivery, 1024),
+		ctx:         ctx,
+	}
+}
+
+// StartWebhooks delivers events until ctx is done, undelivered ones are resumed on the next start
+func StartWebhooks(ctx context.Context, cfg *config.Config) <-chan struct{} {
+	webhookPrivateNets = nil
+	for _, cidr := range cfg.WebhookPrivateNets {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			webhookPrivateNets = append(webhookPrivateNets, network)
+		}
+	}
+	d := NewWebhookDispatcher(ctx, cfg.WebhookMaxAttempts, cfg.WebhookRetryBackoff)
+	for _, hook := range GetWebhooks("") {
+		d.add(hook)
+	}
+	webhooks = d
+
+	var wg sync.WaitGroup
+	for i := 0; i < webhookWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.work()
+		}()
+	}
+
+	events := d.intake()
+	for _, delivery := range GetWebhookDeliveries("", "", DeliveryPending) {
+		d.schedule(delivery)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				return
+			case event := <-events:
+				d.dispatch(event)
+			}
+		}
+	}()
+	return done
+}
+
+// intake buffers bus events without limit, so the dispatcher is never dropped as a slow subscriber
+func (d *WebhookDispatcher) intake() <-chan Event {
+	out := make(chan Event)
+	go func() {
+		sub := Events.Subscribe(EventBufferSize)
+		var buffer []Event
+		for {
+			var send chan Event
+			var next Event
+			if len(buffer) != 0 {
+				send, next = out, buffer[0]
+			}
+
+			select {
+			case <-d.ctx.Done():
+				sub.Unsubscribe()
+				return
+			case event, ok := <-sub.C:
+				if !ok {
+					log.Error("webhook dispatcher lost events, resubscribing")
+					sub = Events.Subscribe(EventBufferSize)
+					continue
+				}
+				buffer = append(buffer, event)
+			case send <- next:
+				buffer = buffer[1:]
+			}
+		}
+	}()
+	return out
+}
+
+func (d *WebhookDispatcher) add(hook *Webhook) {
+	d.mu.Lock()
+	d.webhooks[hook.ID] = hook
+	d.mu.Unlock()
+}
+
+func (d *WebhookDispatcher) remove(ID string) {
+	d.mu.Lock()
+	delete(d.webhooks, ID)
+	d.mu.Unlock()
+}
+
+func (d *WebhookDispatcher) get(ID string) *Webhook {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.webhooks[ID]
+}
+
+func (d *WebhookDispatcher) dispatch(event Event) {
+	d.mu.RLock()
+	hooks := make([]*Webhook, 0, len(d.webhooks))
+	for _, hook := range d.webhooks {
+		hooks = append(hooks, hook)
+	}
+	d.mu.RUnlock()
+
+	for _, hook := range hooks {
+		for _, match := range MatchWebhook(hook, event) {
+			delivery, err := newDelivery(hook, match, event)
+			if err != nil {
+				log.Error("can't create webhook delivery", "webhook", hook.ID, "err", err)
+				continue
+			}
+			if err = WriteWebhookDelivery(delivery); err != nil {
+				log.Error("can't write webhook delivery", "webhook", hook.ID, "err", err)
+				continue
+			}
+			d.schedule(delivery)
+		}
+	}
+}
+
+func (d *WebhookDispatcher) work() {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case delivery := <-d.queue:
+			d.attempt(delivery)
+		}
+	}
+}
+
+// schedule queues delivery at its NextAttempt
+func (d *WebhookDispatcher) schedule(delivery *WebhookDelivery) {
+	enqueue := func() {
+		select {
+		case d.queue <- delivery:
+		case <-d.ctx.Done():
+		}
+	}
+
+	delay := time.Until(time.Unix(0, delivery.NextAttempt))
+	if delay <= 0 {
+		go enqueue()
+		return
+	}
+	time.AfterFunc(delay, enqueue)
+}
+
+func (d *WebhookDispatcher) attempt(delivery *WebhookDelivery) {
+	hook := d.get(delivery.WebhookID)
+	now := time.Now()
+	record := WebhookAttempt{Timestamp: now.Unix()}
+
+	if hook == nil {
+		record.Error = "webhook was deleted"
+		delivery.Status = DeliveryDead
+	} else {
+		record.StatusCode, record.Error = d.post(hook, delivery, now)
+		if d.ctx.Err() != nil {
+			// interrupted by shutdown, the delivery stays pending
+			return
+		}
+		delivery.Attempt++
+		switch {
+		case record.Error == "":
+			delivery.Status = DeliveryDelivered
+		case delivery.Attempt >= d.maxAttempts:
+			delivery.Status = DeliveryDead
+			log.Warn("webhook delivery is dead", "webhook", hook.ID, "delivery", delivery.ID, "err", record.Error)
+		default:
+			delivery.NextAttempt = now.Add(RetryDelay(d.backoff, delivery.Attempt)).UnixNano()
+		}
+	}
+	delivery.History = append(delivery.History, record)
+
+	if err := UpdateWebhookDelivery(delivery); err != nil {
+		log.Error("can't update webhook delivery", "delivery", delivery.ID, "err", err)
+	}
+	if delivery.Status == DeliveryPending {
+		d.schedule(delivery)
+	}
+}
+
+// post returns response status and error description, empty for 2xx response
+func (d *WebhookDispatcher) post(hook *Webhook, delivery *WebhookDelivery, now time.Time) (int, string) {
+	req, err := NewWebhookRequest(hook, delivery, now)
+	if err != nil {
+		return 0, err.Error()
+	}
+	resp, err := d.client.Do(req.WithContext(d.ctx))
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, "unexpected status " + resp.Status
+	}
+	return resp.StatusCode, ""
+}
+
+// redeliver gives dead delivery another round of attempts
+func (d *WebhookDispatcher) redeliver(delivery *WebhookDelivery) error {
+	delivery.Status = DeliveryPending
+	delivery.Attempt = 0
+	delivery.NextAttempt = 0
+	if err := UpdateWebhookDelivery(delivery); err != nil {
+		return err
+	}
+	d.schedule(delivery)
+	return nil
+}
+
+// NewWebhookClient returns client which refuses to connect to internal addresses out
+// of allowed networks. The address is checked on every dial, after DNS resolution and
+// redirects, so a host which resolves to a public address on creation can't be rebound
+func NewWebhookClient(allowed []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !WebhookIPAllowed(ip, allowed) {
+				return fmt.Errorf("webhook address %s is internal", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: webhookTimeout, Transport: transport}
+}
+
+// WebhookIPAllowed reports whether webhooks may be delivered to ip
+func WebhookIPAllowed(ip net.IP, allowed []*net.IPNet) bool {
+	for _, network := range allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// checkWebhookHost resolves host of webhook URL, every address must be allowed
+func checkWebhookHost(ctx context.Context, host string) error {
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(ips) == 0 {
+		return InvalidWebhookError.WithDetails(map[string]interface{}{"parameter": "url", "reason": "host can't be resolved"})
+	}
+	for _, ip := range ips {
+		if !WebhookIPAllowed(ip.IP, webhookPrivateNets) {
+			return InvalidWebhookError.WithDetails(map[string]interface{}{"parameter": "url", "reason": "internal address"})
+		}
+	}
+	return nil
+}
+
+func NewWebhookRequest(hook *Webhook, delivery *WebhookDelivery, now time.Time) (*http.Request, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(hook.Secret, timestamp, body))
+	return req, nil
+}
+
+// SignWebhookPayload returns "sha256=" and hex HMAC of "<timestamp>.<body>", the timestamp
+// lets receivers reject replayed requests
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyWebhookSignature(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, timestamp, body)), []byte(signature))
+}
+
+// RetryDelay is backoff doubled after each failed attempt
+func RetryDelay(backoff time.Duration, attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	return backoff << (attempt - 1)
+}
+
+// WebhookMatch is a webhook event and the watched address it is delivered for
+type WebhookMatch struct {
+	Event   string
+	Address Address
+}
+
+// MatchWebhook returns webhook events the chain event triggers, funds moving in and out of
+// a watched address in one tx produce both incoming and outgoing
+func MatchWebhook(hook *Webhook, event Event) (res []WebhookMatch) {
+	if event.Tx == nil {
+		return nil
+	}
+	wants := func(name string) bool {
+		ok, _ := utils.Contains(name, hook.Events)
+		return ok
+	}
+
+	delta := event.Tx.GetBalanceDelta()
+	switch event.Type {
+	case EventTx:
+		for _, addr := range hook.Addresses {
+			value, ok := delta[addr]
+			if !ok {
+				continue
+			}
+			if wants(WebhookIncoming) && value.Cents() > 0 {
+				res = append(res, WebhookMatch{WebhookIncoming, addr})
+			}
+			if wants(WebhookOutgoing) && value.Cents() < 0 {
+				res = append(res, WebhookMatch{WebhookOutgoing, addr})
+			}
+			if wants(WebhookConditionalExecuted) && event.Tx.IsScheduled() {
+				res = append(res, WebhookMatch{WebhookConditionalExecuted, addr})
+			}
+		}
+	case EventTxStatus:
+		if event.Status.Status != TxStatusFailed || !wants(WebhookConditionalFailed) {
+			return nil
+		}
+		for _, addr := range hook.Addresses {
+			if _, ok := delta[addr]; ok {
+				res = append(res, WebhookMatch{WebhookConditionalFailed, addr})
+			}
+		}
+	}
+	return res
+}
+
+func newDelivery(hook *Webhook, match WebhookMatch, event Event) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{
+		ID:        randomID(),
+		WebhookID: hook.ID,
+		Owner:     hook.Owner,
+		Event:     match.Event,
+		Status:    DeliveryPending,
+		History:   make([]WebhookAttempt, 0),
+		CreatedAt: time.Now().Unix(),
+	}
+
+	payload := WebhookPayload{
+		DeliveryID:  delivery.ID,
+		WebhookID:   hook.ID,
+		Event:       match.Event,
+		Address:     utils.ToHex(match.Address[:]),
+		BlockNumber: event.BlockNumber,
+		Tx:          event.Tx,
+	}
+	if event.Status != nil {
+		payload.Reason = event.Status.Reason
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = string(body)
+	return delivery, nil
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+type CreateWebhookRequest struct {
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Addresses []string `json:"addresses,omitempty"` // hex, own address if empty
+}
+
+// CreateWebhook registers webhook of the user, the secret is shown only in this response
+func CreateWebhook(c *gin.Context) {
+	user := GetUserFromContext(c)
+	req := CreateWebhookRequest{}
+	if err := c.BindJSON(&req); err != nil {
+		RespondError(c, InvalidBodyError)
+		return
+	}
+
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		RespondError(c, InvalidWebhookError.WithDetails(map[string]interface{}{"parameter": "url"}))
+		return
+	}
+	if err = checkWebhookHost(c.Request.Context(), parsed.Hostname()); err != nil {
+		RespondError(c, err)
+		return
+	}
+	if len(req.Events) == 0 {
+		RespondError(c, InvalidWebhookError.WithDetails(map[string]interface{}{"parameter": "events"}))
+		return
+	}
+	for _, event := range req.Events {
+		if ok, _ := utils.Contains(event, WebhookEvents); !ok {
+			RespondError(c, InvalidWebhookError.WithDetails(map[string]interface{}{"event": event, "supported": WebhookEvents}))
+			return
+		}
+	}
+
+	hook := &Webhook{
+		ID:        randomID(),
+		Owner:     user.Username,
+		URL:       req.URL,
+		Secret:    randomID() + randomID(),
+		Events:    req.Events,
+		CreatedAt: time.Now().Unix(),
+	}
+	if len(req.Addresses) == 0 {
+		hook.Addresses = []Address{CalculatePublicKeyByUsername(user.Username)}
+	} else {
+		readable, err := subscriberReadable(user)
+		if err != nil {
+			RespondError(c, err)
+			return
+		}
+		for _, hexAddr := range req.Addresses {
+			addr, err := ParseAddress(hexAddr)
+			if err == nil {
+				err = requireReadable(readable, addr)
+			}
+			if err != nil {
+				RespondError(c, err)
+				return
+			}
+			hook.Addresses = append(hook.Addresses, addr)
+		}
+	}
+
+	if err := WriteWebhook(hook); err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+	if webhooks != nil {
+		webhooks.add(hook)
+	}
+	c.JSON(http.StatusCreated, hook)
+}
+
+func ListWebhooks(c *gin.Context) {
+	user := GetUserFromContext(c)
+	hooks := GetWebhooks(user.Username)
+	for _, hook := range hooks {
+		hook.Secret = ""
+	}
+	c.JSON(http.StatusOK, hooks)
+}
+
+// DeleteWebhookReq removes webhook, its pending deliveries turn dead on the next attempt
+func DeleteWebhookReq(c *gin.Context) {
+	user := GetUserFromContext(c)
+	ID := c.Param("id")
+	deleted, err := DeleteWebhook(user.Username, ID)
+	if err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+	if !deleted {
+		RespondError(c, UnknownWebhookError.WithDetails(map[string]interface{}{"id": ID}))
+		return
+	}
+	if webhooks != nil {
+		webhooks.remove(ID)
+	}
+	c.JSON(http.StatusOK, "ok")
+}
+
+func GetWebhookDeliveriesReq(c *gin.Context) {
+	user := GetUserFromContext(c)
+	status := c.Query("status")
+	if ok, _ := utils.Contains(status, []string{"", DeliveryPending, DeliveryDelivered, DeliveryDead}); !ok {
+		RespondError(c, InvalidParamError.WithDetails(map[string]interface{}{"parameter": "status"}))
+		return
+	}
+	c.JSON(http.StatusOK, GetWebhookDeliveries(user.Username, c.Param("id"), status))
+}
+
+// GetDeadLetters returns deliveries of the user that ran out of attempts
+func GetDeadLetters(c *gin.Context) {
+	user := GetUserFromContext(c)
+	c.JSON(http.StatusOK, GetWebhookDeliveries(user.Username, "", DeliveryDead))
+}
+
+func RedeliverWebhook(c *gin.Context) {
+	user := GetUserFromContext(c)
+	delivery, err := GetWebhookDelivery(user.Username, c.Param("delivery"))
+	if err != nil || delivery.WebhookID != c.Param("id") {
+		RespondError(c, UnknownDeliveryError.WithDetails(map[string]interface{}{"id": c.Param("delivery")}))
+		return
+	}
+	if delivery.Status != DeliveryDead {
+		RespondError(c, RedeliveryError.WithDetails(map[string]interface{}{"status": delivery.Status}))
+		return
+	}
+	if webhooks == nil || webhooks.get(delivery.WebhookID) == nil {
+		RespondError(c, UnknownWebhookError.WithDetails(map[string]interface{}{"id": delivery.WebhookID}))
+		return
+	}
+	if err = webhooks.redeliver(delivery); err != nil {
+		RespondError(c, StorageError)
+		return
+	}
+	c.JSON(http.StatusOK, delivery)
+}
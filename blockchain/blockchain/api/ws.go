@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TopicBlocks  = "blocks"  // new finalized blocks
+	TopicAddress = "address" // finalized txs touching given addresses
+	TopicPending = "pending" // status changes of own pending and scheduled txs
+
+	wsWriteTimeout = 10 * time.Second
+	wsPingPeriod   = 30 * time.Second
+	wsMaxMessage   = 64 << 10
+)
+
+type (
+	WSRequest struct {
+		Action    string   `json:"action"` // subscribe, unsubscribe
+		Topic     string   `json:"topic"`
+		Addresses []string `json:"addresses,omitempty"` // TopicAddress only, hex
+	}
+
+	WSReply struct {
+		Result string    `json:"result"` // ok, error
+		Topic  string    `json:"topic,omitempty"`
+		Error  *APIError `json:"error,omitempty"`
+	}
+
+	WSMessage struct {
+		Topic string `json:"topic"`
+		Event Event  `json:"event"`
+	}
+
+	wsSession struct {
+		owner    Address
+		readable []Address // nil - any address
+
+		mu        sync.Mutex
+		blocks    bool
+		pending   bool
+		addresses map[Address]struct{}
+	}
+)
+
+var (
+	wsUpgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     WSOriginAllowed,
+	}
+	wsOrigins []string
+)
+
+// AllowWSOrigins sets origins besides the node's own which may open WebSocket in browsers
+func AllowWSOrigins(origins []string) {
+	wsOrigins = origins
+}
+
+// WSOriginAllowed accepts clients without Origin, which are not browsers, same-origin
+// pages and the allowed origins. Browsers may send stored basic auth credentials
+// along with a cross-origin upgrade, so other origins are rejected
+func WSOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range wsOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebSocket streams chain events for topics the client subscribed to
+func WebSocket(c *gin.Context) {
+	user := GetUserFromContext(c)
+	session := &wsSession{
+		owner:     CalculatePublicKeyByUsername(user.Username),
+		addresses: make(map[Address]struct{}),
+	}
+	readable, err := subscriberReadable(user)
+	if err != nil {
+		RespondError(c, err)
+		return
+	}
+	session.readable = readable
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Warn("websocket upgrade failed", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := Events.Subscribe(EventBufferSize)
+	defer sub.Unsubscribe()
+
+	replies := make(chan WSReply, 16)
+	done, closing := make(chan struct{}), make(chan struct{})
+	defer close(closing)
+	go session.readLoop(conn, replies, done, closing)
+	session.writeLoop(c.Request.Context(), conn, sub, replies, done)
+}
+
+func (s *wsSession) readLoop(conn *websocket.Conn, replies chan<- WSReply, done chan<- struct{}, closing <-chan struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(wsMaxMessage)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * wsPingPeriod))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * wsPingPeriod))
+	})
+
+	for {
+		req := WSRequest{}
+		if err := conn.ReadJSON(&req); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr) {
+				return
+			}
+			// malformed message, connection is still usable
+			if !s.reply(replies, closing, WSReply{Result: "error", Error: InvalidBodyError}) {
+				return
+			}
+			continue
+		}
+
+		reply := WSReply{Result: "ok", Topic: req.Topic}
+		if err := s.apply(req); err != nil {
+			reply.Result, reply.Error = "error", ToAPIError(err)
+		}
+		if !s.reply(replies, closing, reply) {
+			return
+		}
+	}
+}
+
+func (s *wsSession) reply(replies chan<- WSReply, closing <-chan struct{}, reply WSReply) bool {
+	select {
+	case replies <- reply:
+		return true
+	case <-closing:
+		return false
+	}
+}
+
+// writeLoop runs until the client goes away or ctx is done on server shutdown
+func (s *wsSession) writeLoop(ctx context.Context, conn *websocket.Conn, sub *Subscription, replies <-chan WSReply, done <-chan struct{}) {
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		var msg interface{}
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down")
+			_ = conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(wsWriteTimeout))
+			return
+		case reply := <-replies:
+			msg = reply
+		case event, ok := <-sub.C:
+			if !ok {
+				closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber is too slow")
+				_ = conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(wsWriteTimeout))
+				return
+			}
+			topic, ok := s.match(event)
+			if !ok {
+				continue
+			}
+			msg = WSMessage{Topic: topic, Event: event}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+			continue
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+func (s *wsSession) apply(req WSRequest) error {
+	subscribe := true
+	switch req.Action {
+	case "subscribe":
+	case "unsubscribe":
+		subscribe = false
+	default:
+		return InvalidParamError.WithDetails(map[string]interface{}{"parameter": "action"})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Topic {
+	case TopicBlocks:
+		s.blocks = subscribe
+	case TopicPending:
+		s.pending = subscribe
+	case TopicAddress:
+		addresses := make([]Address, 0, len(req.Addresses))
+		for _, hexAddr := range req.Addresses {
+			addr, err := ParseAddress(hexAddr)
+			if err != nil {
+				return err
+			}
+			if subscribe {
+				if err = requireReadable(s.readable, addr); err != nil {
+					return err
+				}
+			}
+			addresses = append(addresses, addr)
+		}
+		for _, addr := range addresses {
+			if subscribe {
+				s.addresses[addr] = struct{}{}
+			} else {
+				delete(s.addresses, addr)
+			}
+		}
+	default:
+		return InvalidParamError.WithDetails(map[string]interface{}{"parameter": "topic"})
+	}
+	return nil
+}
+
+// match returns the topic event is delivered under
+func (s *wsSession) match(event Event) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch event.Type {
+	case EventBlock:
+		return TopicBlocks, s.blocks
+	case EventTxStatus:
+		return TopicPending, s.pending && event.Status.OwnedBy(s.owner)
+	case EventTx:
+		for addr := range s.addresses {
+			if event.Tx.involvesAnyOf([]Address{addr}) {
+				return TopicAddress, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"testing"
+)
+
+func buildAuditChain(n int) api.AuditEvents {
+	events := make(api.AuditEvents, 0, n)
+	var prev *api.AuditEvent
+	for i := 0; i < n; i++ {
+		event := api.ChainAuditEvent(prev, api.AuditEvent{
+			Timestamp: int64(1000 + i),
+			Type:      api.AuditLogin,
+			Actor:     "user",
+		})
+		events = append(events, &event)
+		prev = &event
+	}
+	return events
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	if err := api.VerifyAuditChain(buildAuditChain(5), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	modified := buildAuditChain(5)
+	modified[2].Actor = "admin"
+	if api.VerifyAuditChain(modified, nil) == nil {
+		t.Error("modified event wasn't detected")
+	}
+
+	removed := buildAuditChain(5)
+	removed = append(removed[:2], removed[3:]...)
+	if api.VerifyAuditChain(removed, nil) == nil {
+		t.Error("removed event wasn't detected")
+	}
+
+	rehashed := buildAuditChain(5)
+	rehashed[2].Actor = "admin"
+	rehashed[2].Hash = rehashed[2].ComputeHash()
+	if api.VerifyAuditChain(rehashed, nil) == nil {
+		t.Error("rehashed event wasn't detected")
+	}
+}
+
+func TestVerifyAuditChainAnchor(t *testing.T) {
+	events := buildAuditChain(5)
+	if api.VerifyAuditChain(events[2:], nil) == nil {
+		t.Error("truncated log verified without an anchor")
+	}
+
+	anchor := &api.AuditAnchor{Seq: events[1].Seq, Hash: events[1].Hash}
+	if err := api.VerifyAuditChain(events[2:], anchor); err != nil {
+		t.Errorf("anchored part: %v", err)
+	}
+	if api.VerifyAuditChain(events[3:], anchor) == nil {
+		t.Error("gap after the anchor wasn't detected")
+	}
+	anchor.Hash = events[0].Hash
+	if api.VerifyAuditChain(events[2:], anchor) == nil {
+		t.Error("wrong anchor hash wasn't detected")
+	}
+}
@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCompoundCondition(t *testing.T) {
+	account := &api.Account{Address: api.Address{1}}
+	after := int64(100)
+	balance := api.Value_{Integer: 50}
+	state := &api.ConditionState{
+		Now:     150,
+		Balance: func(api.Address) (api.Value_, bool) { return balance, true },
+	}
+
+	// after 100 AND (balance > 40 OR balance < 10) AND NOT balance > 60
+	cond := &api.Filter{All: []*api.Filter{
+		{SendAfterTimestamp: &after},
+		{Any: []*api.Filter{
+			{Type: api.AccountBalanceMoreThen, CondAccount: account, CondValue: &api.Value_{Integer: 40}},
+			{Type: api.AccountBalanceLessThen, CondAccount: account, CondValue: &api.Value_{Integer: 10}},
+		}},
+		{Not: &api.Filter{Type: api.AccountBalanceMoreThen, CondAccount: account, CondValue: &api.Value_{Integer: 60}}},
+	}}
+	if err := cond.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !cond.Met(state) {
+		t.Error("condition must be met")
+	}
+
+	for _, c := range []struct {
+		now     int64
+		balance api.Value_
+	}{{50, api.Value_{Integer: 50}}, {150, api.Value_{Integer: 20}}, {150, api.Value_{Integer: 70}}} {
+		state.Now, balance = c.now, c.balance
+		if cond.Met(state) {
+			t.Errorf("condition is met at %v with balance %v", c.now, c.balance)
+		}
+	}
+}
+
+func TestCompoundConditionValidation(t *testing.T) {
+	missing := &api.Filter{Any: []*api.Filter{{Type: api.AccountSentTransaction}}}
+	if err := missing.Validate(); !errors.Is(err, api.CondParamError) {
+		t.Errorf("nested missing parameter: %v", err)
+	}
+
+	deep := &api.Filter{}
+	for i := 0; i < api.ConditionDepthLimit; i++ {
+		deep = &api.Filter{Not: deep}
+	}
+	if err := deep.Validate(); !errors.Is(err, api.CondTooComplexError) {
+		t.Errorf("too deep condition: %v", err)
+	}
+}
+
+func TestLegacyConditionFormat(t *testing.T) {
+	cond := api.Filter{}
+	if err := json.Unmarshal([]byte(`{"send_after":null,"send_after_timestamp":100,"type":0,"cond_account":null,"cond_value":null}`), &cond); err != nil {
+		t.Fatal(err)
+	}
+	if err := cond.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cond.Met(&api.ConditionState{Now: 99}) || !cond.Met(&api.ConditionState{Now: 100}) {
+		t.Error("single condition must keep its meaning")
+	}
+}
+
+type evenBlockCondition struct{}
+
+const evenBlock = 100
+
+func init() {
+	api.RegisterCondition(evenBlock, evenBlockCondition{})
+}
+
+func (evenBlockCondition) Params() []string {
+	return []string{"note"}
+}
+
+func (evenBlockCondition) Met(_ *api.Filter, state *api.ConditionState) bool {
+	return state.LastFinalizedNumber%2 == 0
+}
+
+func TestRegisteredCondition(t *testing.T) {
+	if err := (&api.Filter{Type: evenBlock}).Validate(); !errors.Is(err, api.CondParamError) {
+		t.Errorf("declared parameter isn't required: %v", err)
+	}
+	cond := &api.Filter{Type: evenBlock, Params: map[string]string{"note": "even"}}
+	if err := cond.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !cond.Met(&api.ConditionState{LastFinalizedNumber: 4}) || cond.Met(&api.ConditionState{LastFinalizedNumber: 5}) {
+		t.Error("registered condition isn't evaluated")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("condition type is registered twice")
+		}
+	}()
+	api.RegisterCondition(evenBlock, evenBlockCondition{})
+}
+
+func TestBuiltinConditions(t *testing.T) {
+	// 2024-01-01 is Monday
+	monday := &api.ConditionState{Now: 1704067200}
+	weekday := &api.Filter{Type: api.Weekday, Params: map[string]string{"days": "mon, FRI"}}
+	if err := weekday.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !weekday.Met(monday) {
+		t.Error("weekday condition isn't met on Monday")
+	}
+	weekday.Params["time_zone"] = "America/New_York" // still Sunday there
+	if weekday.Met(monday) {
+		t.Error("time zone is ignored")
+	}
+
+	blocks := &api.Filter{Type: api.BlockRange, Params: map[string]string{"from": "10", "to": "20"}}
+	if !blocks.Met(&api.ConditionState{LastFinalizedNumber: 20}) || blocks.Met(&api.ConditionState{LastFinalizedNumber: 21}) {
+		t.Error("block range is inclusive")
+	}
+
+	api.Oracles.Set("rate", api.Value_{Integer: 90})
+	oracle := &api.Filter{Type: api.OracleValue, CondValue: &api.Value_{Integer: 100}, Params: map[string]string{"name": "rate", "op": "lt"}}
+	if err := oracle.Validate(); err != nil || !oracle.Met(&api.ConditionState{}) {
+		t.Errorf("oracle condition isn't met: %v", err)
+	}
+	api.Oracles.SetMaxAge(time.Minute)
+	api.Oracles.Put("rate", api.OracleEntry{Value: api.Value_{Integer: 90}, UpdatedAt: 1000})
+	if !oracle.Met(&api.ConditionState{Now: 1060}) || oracle.Met(&api.ConditionState{Now: 1061}) {
+		t.Error("stale oracle value meets the condition")
+	}
+	api.Oracles.SetMaxAge(0)
+	oracle.Params["name"] = "unknown"
+	if oracle.Met(&api.ConditionState{}) {
+		t.Error("unknown oracle value meets the condition")
+	}
+
+	for _, invalid := range []*api.Filter{
+		{Type: api.Weekday, Params: map[string]string{"days": "someday"}},
+		{Type: api.BlockRange, Params: map[string]string{"from": "20", "to": "10"}},
+		{Type: api.OracleValue, CondValue: &api.Value_{}, Params: map[string]string{"name": "rate", "op": "eq"}},
+		{Type: api.OracleValue, CondValue: &api.Value_{}, Params: map[string]string{"name": "never_reported", "op": "gt"}},
+	} {
+		if err := invalid.Validate(); !errors.Is(err, api.InvalidCondParamError) {
+			t.Errorf("type %d: unexpected error %v", invalid.Type, err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAPIErrorMatching(t *testing.T) {
+	err := api.InsufficientFundsError.WithDetails(map[string]interface{}{"address": "00"})
+	if !errors.Is(err, api.InsufficientFundsError) {
+		t.Error("error with details must match catalogue error")
+	}
+	if errors.Is(err, api.InsufficientFeeError) {
+		t.Error("errors with different codes must not match")
+	}
+	if api.InsufficientFundsError.Details != nil {
+		t.Error("catalogue error must not be modified")
+	}
+
+	wrapped := fmt.Errorf("processing: %w", err)
+	if apiErr := api.ToAPIError(wrapped); apiErr.Code != "insufficient_funds" || apiErr.Status != http.StatusNotAcceptable {
+		t.Errorf("unexpected error: %+v", apiErr)
+	}
+
+	if apiErr := api.ToAPIError(errors.New("boom")); apiErr.Code != api.InternalError.Code || apiErr.Status != http.StatusInternalServerError {
+		t.Errorf("unknown error must be internal: %+v", apiErr)
+	}
+}
+
+func TestTxProcessingStatus(t *testing.T) {
+	// 1xx statuses are informational, clients wait for the final response then
+	if api.TxProcessingError.Status != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status %d", api.TxProcessingError.Status)
+	}
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"testing"
+)
+
+func TestEventBusDelivery(t *testing.T) {
+	bus := api.NewEventBus()
+	first, second := bus.Subscribe(1), bus.Subscribe(1)
+
+	bus.Publish(api.Event{Type: api.EventBlock})
+	for _, sub := range []*api.Subscription{first, second} {
+		if event := <-sub.C; event.Type != api.EventBlock {
+			t.Errorf("unexpected event %v", event.Type)
+		}
+	}
+
+	second.Unsubscribe()
+	second.Unsubscribe()
+	if _, ok := <-second.C; ok {
+		t.Error("unsubscribed channel must be closed")
+	}
+
+	bus.Publish(api.Event{Type: api.EventTx})
+	if event := <-first.C; event.Type != api.EventTx {
+		t.Errorf("unexpected event %v", event.Type)
+	}
+}
+
+func TestEventBusDropsSlowSubscriber(t *testing.T) {
+	bus := api.NewEventBus()
+	slow := bus.Subscribe(1)
+
+	bus.Publish(api.Event{Type: api.EventBlock})
+	bus.Publish(api.Event{Type: api.EventBlock})
+
+	if _, ok := <-slow.C; !ok {
+		t.Fatal("buffered event must be delivered")
+	}
+	if _, ok := <-slow.C; ok {
+		t.Error("slow subscriber must be dropped instead of blocking publisher")
+	}
+	slow.Unsubscribe()
+}
+
+func TestTxStatusOwner(t *testing.T) {
+	tx := pendingTx(1, 1, 10)
+	status := api.NewTxStatus(tx, api.TxStatusPending, "")
+	if !status.OwnedBy(tx.From.Address) {
+		t.Error("status must belong to the sender")
+	}
+	if status.OwnedBy(api.Address{0xff}) {
+		t.Error("status must not belong to other address")
+	}
+}
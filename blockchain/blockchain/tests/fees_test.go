@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"IS/blockchain/config"
+	"strings"
+	"testing"
+)
+
+func TestParseValue(t *testing.T) {
+	cases := map[string]api.Value_{
+		"1":     {Integer: 1},
+		"1.5":   {Integer: 1, Fractional: 50},
+		"0.05":  {Fractional: 5},
+		"12.34": {Integer: 12, Fractional: 34},
+	}
+	for s, expected := range cases {
+		if value, err := api.ParseValue(s); err != nil || value != expected {
+			t.Errorf("ParseValue(%q) = %v, %v", s, value, err)
+		}
+	}
+
+	for _, s := range []string{"", "1.234", "-1", "a.b"} {
+		if _, err := api.ParseValue(s); err == nil {
+			t.Errorf("ParseValue(%q) must fail", s)
+		}
+	}
+}
+
+func TestNegativeDelta(t *testing.T) {
+	balance := api.Value_{Integer: 1, Fractional: 20}
+	delta := api.ValueFromCents(-30)
+
+	if res := api.ValueFromCents(balance.Cents() + delta.Cents()); res != (api.Value_{Fractional: 90}) {
+		t.Errorf("invalid result: %v", res)
+	}
+}
+
+func TestRequiredFee(t *testing.T) {
+	tx := pendingTx(1, 1, 100)
+
+	flat := api.FeePolicy{Kind: api.FlatFee, Amount: api.Value_{Fractional: 5}}
+	if fee := flat.RequiredFee(tx); fee != (api.Value_{Fractional: 5}) {
+		t.Errorf("invalid flat fee: %v", fee)
+	}
+
+	perByte := api.FeePolicy{Kind: api.PerByteFee, Amount: api.Value_{Fractional: 1}}
+	short := perByte.RequiredFee(tx)
+	tx.Description = "rent for october"
+	if long := perByte.RequiredFee(tx); !long.GreeterThen(&short) {
+		t.Errorf("longer tx must cost more: %v <= %v", long, short)
+	}
+
+	mint := &api.Transaction{TxType: api.Obtaining, To: tx.To}
+	if fee := flat.RequiredFee(mint); fee != (api.Value_{}) {
+		t.Errorf("obtaining must be free: %v", fee)
+	}
+}
+
+func TestFeePolicyRequiresTreasury(t *testing.T) {
+	for _, treasury := range []string{"", strings.Repeat("00", api.AddressLen)} {
+		cfg := &config.Config{FeePolicy: api.FlatFee, FeeAmount: "0.01", TreasuryAddress: treasury}
+		if _, err := api.FeePolicyFromConfig(cfg); err == nil {
+			t.Errorf("fees are burned with treasury %q", treasury)
+		}
+	}
+
+	if _, err := api.FeePolicyFromConfig(&config.Config{FeePolicy: api.NoFee, FeeAmount: "0.01"}); err != nil {
+		t.Errorf("treasury is required without fees: %v", err)
+	}
+	cfg := &config.Config{FeePolicy: api.FlatFee, FeeAmount: "0.01", TreasuryAddress: strings.Repeat("ab", api.AddressLen)}
+	if policy, err := api.FeePolicyFromConfig(cfg); err != nil || policy.Treasury == (api.Address{}) {
+		t.Errorf("unexpected policy %+v, %v", policy, err)
+	}
+}
+
+func TestMempoolPrefersHigherFee(t *testing.T) {
+	queue := api.NewTransactionQueue(2, 0, 0)
+
+	cheap := pendingTx(1, 1, 100)
+	expensive := pendingTx(2, 2, 200)
+	expensive.Fee = &api.Value_{Integer: 1}
+	_, _ = queue.Add(cheap)
+	_, _ = queue.Add(expensive)
+
+	richest := pendingTx(3, 3, 300)
+	richest.Fee = &api.Value_{Integer: 2}
+	evicted, err := queue.Add(richest)
+	if err != nil || evicted == nil || evicted.ID != 1 {
+		t.Fatalf("cheapest tx must be evicted, got %v, %v", evicted, err)
+	}
+
+	txs := queue.GetMaxCountAndRemove()
+	if len(txs) != 2 || txs[0].ID != 3 || txs[1].ID != 2 {
+		t.Errorf("expected txs ordered by fee")
+	}
+}
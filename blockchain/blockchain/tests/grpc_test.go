@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"IS/blockchain/blockchain/api/pb"
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+)
+
+func dialGRPC(t *testing.T) *grpc.ClientConn {
+	listener := bufconn.Listen(1 << 20)
+	server := api.NewGRPCServer()
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestGRPCValidation(t *testing.T) {
+	conn := dialGRPC(t)
+	ctx := context.Background()
+
+	_, err := pb.NewBalanceServiceClient(conn).GetBalance(ctx, &pb.GetBalanceRequest{Address: []byte{1, 2}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("short address: %v", err)
+	}
+
+	txs := pb.NewTransactionServiceClient(conn)
+	_, err = txs.SendTransaction(ctx, &pb.SendTransactionRequest{Tx: &pb.Transaction{Type: api.Transfer}})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("send without credentials: %v", err)
+	}
+
+	stream, err := txs.ListTransactions(ctx, &pb.ListTransactionsRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("list without credentials: %v", err)
+	}
+
+	_, err = pb.NewBlockServiceClient(conn).GetBlock(ctx, &pb.GetBlockRequest{Selector: &pb.GetBlockRequest_Hash{Hash: []byte{1}}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("short hash: %v", err)
+	}
+
+	_, err = txs.GetTransaction(ctx, &pb.GetTransactionRequest{Hash: make([]byte, len(api.Hash{}))})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("get tx without credentials: %v", err)
+	}
+	blocks := pb.NewBlockServiceClient(conn)
+	_, err = blocks.GetBlock(ctx, &pb.GetBlockRequest{Selector: &pb.GetBlockRequest_Number{Number: 1}})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("get block without credentials: %v", err)
+	}
+	blockStream, err := blocks.StreamBlocks(ctx, &pb.StreamBlocksRequest{})
+	if err == nil {
+		_, err = blockStream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("stream blocks without credentials: %v", err)
+	}
+}
+
+func TestGRPCErrorDetails(t *testing.T) {
+	st := status.Convert(api.GRPCError(api.InsufficientFundsError))
+	if st.Code() != codes.FailedPrecondition {
+		t.Errorf("unexpected code %v", st.Code())
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Reason == api.InsufficientFundsError.Code {
+			return
+		}
+	}
+	t.Errorf("error code is missing in details: %v", st.Details())
+}
+
+func TestGRPCTxProcessing(t *testing.T) {
+	if code := status.Code(api.GRPCError(api.TxProcessingError)); code != codes.DeadlineExceeded {
+		t.Errorf("unexpected code %v", code)
+	}
+}
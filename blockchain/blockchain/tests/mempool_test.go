@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"errors"
+	"testing"
+	"time"
+)
+
+func pendingTx(ID int64, sender byte, timestamp int64) *api.Transaction {
+	return &api.Transaction{
+		ID:        ID,
+		Timestamp: &timestamp,
+		From:      &api.Account{Address: api.Address{sender}},
+		To:        &api.Account{Address: api.Address{0xff}},
+		TxType:    api.Transfer,
+	}
+}
+
+func TestMempoolLimits(t *testing.T) {
+	queue := api.NewTransactionQueue(3, 2, 0)
+
+	if _, err := queue.Add(pendingTx(1, 1, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queue.Add(pendingTx(2, 1, 101)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queue.Add(pendingTx(3, 1, 102)); !errors.Is(err, api.AccountLimitError) {
+		t.Errorf("expected account limit error, got %v", err)
+	}
+	if _, err := queue.Add(pendingTx(4, 2, 103)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queue.Add(pendingTx(5, 3, 104)); !errors.Is(err, api.MempoolFullError) {
+		t.Errorf("expected mempool full error, got %v", err)
+	}
+
+	metrics := queue.Metrics()
+	if metrics.Accepted != 3 || metrics.DroppedAccountLimit != 1 || metrics.DroppedFull != 1 {
+		t.Errorf("invalid metrics: %+v", metrics)
+	}
+}
+
+func TestMempoolOrderAndTTL(t *testing.T) {
+	queue := api.NewTransactionQueue(0, 0, time.Minute)
+	now := time.Now()
+
+	_, _ = queue.Add(pendingTx(1, 1, now.Unix()))
+	_, _ = queue.Add(pendingTx(2, 2, now.Add(-2*time.Minute).Unix()))
+	_, _ = queue.Add(pendingTx(3, 3, now.Add(-30*time.Second).Unix()))
+
+	expired := queue.EvictExpired(now)
+	if len(expired) != 1 || expired[0].ID != 2 {
+		t.Fatalf("expected tx 2 to expire, got %v", expired)
+	}
+
+	txs := queue.GetMaxCountAndRemove()
+	if len(txs) != 2 || txs[0].ID != 3 || txs[1].ID != 1 {
+		t.Errorf("expected older tx first")
+	}
+}
+
+func TestMempoolRevert(t *testing.T) {
+	queue := api.NewTransactionQueue(1, 0, 0)
+	older, newer := pendingTx(1, 1, 100), pendingTx(2, 2, 99)
+	_, _ = queue.Add(older)
+
+	evicted, err := queue.Add(newer)
+	if err != nil || evicted != older {
+		t.Fatalf("expected tx 1 to be evicted, got %v %v", evicted, err)
+	}
+	queue.Revert(newer, evicted)
+
+	txs := queue.GetMaxCountAndRemove()
+	if len(txs) != 1 || txs[0].ID != 1 {
+		t.Errorf("evicted tx isn't restored: %v", txs)
+	}
+	if metrics := queue.Metrics(); metrics.Accepted != 1 || metrics.Evicted != 0 {
+		t.Errorf("invalid metrics: %+v", metrics)
+	}
+}
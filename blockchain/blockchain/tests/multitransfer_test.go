@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"testing"
+)
+
+func TestMultiTransferBalanceDelta(t *testing.T) {
+	alice := &api.Account{Address: api.Address{1}}
+	bob := &api.Account{Address: api.Address{2}}
+	carol := &api.Account{Address: api.Address{3}}
+
+	tx := api.Transaction{
+		TxType: api.MultiTransfer,
+		From:   alice,
+		Fee:    &api.Value_{Fractional: 10},
+		Legs: []*api.Leg{
+			{From: alice, To: carol, Value: api.Value_{Integer: 1, Fractional: 50}},
+			{From: bob, To: carol, Value: api.Value_{Integer: 2}},
+			{From: alice, To: bob, Value: api.Value_{Fractional: 75}},
+		},
+	}
+
+	expected := map[api.Address]int64{
+		alice.Address: -150 - 75 - 10,
+		bob.Address:   -200 + 75,
+		carol.Address: 150 + 200,
+	}
+
+	deltas := tx.GetBalanceDelta()
+	if len(deltas) != len(expected) {
+		t.Fatalf("unexpected deltas: %v", deltas)
+	}
+	for addr, cents := range expected {
+		delta := deltas[addr]
+		if delta.Cents() != cents {
+			t.Errorf("invalid delta for %v: %v, expected %v", addr, delta.Cents(), cents)
+		}
+	}
+}
+
+func TestMultiTransferOutgoingValue(t *testing.T) {
+	alice := &api.Account{Address: api.Address{1}}
+	bob := &api.Account{Address: api.Address{2}}
+	carol := &api.Account{Address: api.Address{3}}
+
+	// the 2FA threshold applies to all legs sent by the user together
+	tx := api.Transaction{
+		TxType: api.MultiTransfer,
+		From:   alice,
+		Legs: []*api.Leg{
+			{From: alice, To: carol, Value: api.Value_{Integer: 1, Fractional: 50}},
+			{From: bob, To: carol, Value: api.Value_{Integer: 2}},
+			{From: alice, To: bob, Value: api.Value_{Fractional: 75}},
+		},
+	}
+	for addr, cents := range map[api.Address]int64{alice.Address: 150 + 75, bob.Address: 200, carol.Address: 0} {
+		if outgoing := tx.OutgoingValue(addr); outgoing.Cents() != cents {
+			t.Errorf("outgoing value of %v: %v, expected %v", addr, outgoing.Cents(), cents)
+		}
+	}
+
+	transfer := api.Transaction{TxType: api.Transfer, From: alice, To: bob, Value: api.Value_{Integer: 3}}
+	if outgoing := transfer.OutgoingValue(alice.Address); outgoing.Cents() != 300 {
+		t.Errorf("outgoing value of transfer: %v", outgoing.Cents())
+	}
+	if outgoing := transfer.OutgoingValue(bob.Address); outgoing.Cents() != 0 {
+		t.Errorf("incoming transfer is counted: %v", outgoing.Cents())
+	}
+}
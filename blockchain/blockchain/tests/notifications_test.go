@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	dbtypes "IS/blockchain/database_utils/types"
+	"IS/utils"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrossedThresholds(t *testing.T) {
+	thresholds := []api.Value_{{Integer: 10}, {Integer: 100}}
+
+	crossed := api.CrossedThresholds(api.Value_{Integer: 5}, api.Value_{Integer: 10}, thresholds)
+	if len(crossed) != 1 || crossed[0].Integer != 10 {
+		t.Errorf("reaching threshold must cross it: %v", crossed)
+	}
+	crossed = api.CrossedThresholds(api.Value_{Integer: 150}, api.Value_{Integer: 9, Fractional: 99}, thresholds)
+	if len(crossed) != 2 {
+		t.Errorf("falling below both thresholds must cross them: %v", crossed)
+	}
+	if crossed = api.CrossedThresholds(api.Value_{Integer: 20}, api.Value_{Integer: 50}, thresholds); len(crossed) != 0 {
+		t.Errorf("unexpected crossing %v", crossed)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	night := &api.QuietHours{From: "22:00", To: "07:00", TimeZone: "UTC"}
+	if err := night.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for clock, expected := range map[string]bool{"23:30": true, "03:00": true, "07:00": false, "12:00": false, "22:00": true} {
+		at, _ := time.Parse("15:04", clock)
+		if night.Contains(at) != expected {
+			t.Errorf("%v: expected quiet %v", clock, expected)
+		}
+	}
+
+	// 12:00 UTC is 15:00 in Moscow
+	day := &api.QuietHours{From: "14:00", To: "16:00", TimeZone: "Europe/Moscow"}
+	if !day.Contains(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Error("time zone is ignored")
+	}
+
+	for _, invalid := range []*api.QuietHours{{From: "25:00", To: "07:00"}, {From: "07:00", To: "07:00"}, {From: "22:00", To: "07:00", TimeZone: "Nowhere/City"}} {
+		if invalid.Validate() == nil {
+			t.Errorf("invalid quiet hours %v are accepted", invalid)
+		}
+	}
+}
+
+type sentNotification struct {
+	username, subject, text string
+}
+
+type fakeChannel struct {
+	sent chan sentNotification
+}
+
+func (c *fakeChannel) Name() string {
+	return "fake"
+}
+
+func (c *fakeChannel) Send(user *dbtypes.User, _ *api.NotificationSettings, subject, text string) (bool, error) {
+	c.sent <- sentNotification{user.Username, subject, text}
+	return true, nil
+}
+
+func (c *fakeChannel) expect(t *testing.T, count int) map[string]sentNotification {
+	res := make(map[string]sentNotification)
+	for i := 0; i < count; i++ {
+		select {
+		case note := <-c.sent:
+			if _, ok := res[note.username]; ok {
+				t.Errorf("%s is notified twice", note.username)
+			}
+			res[note.username] = note
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d notifications, got %v", count, res)
+		}
+	}
+	select {
+	case note := <-c.sent:
+		t.Errorf("unexpected notification %+v", note)
+	case <-time.After(50 * time.Millisecond):
+	}
+	return res
+}
+
+func TestNotifier(t *testing.T) {
+	now := time.Now().UTC()
+	recipients := make(map[api.Address]api.NotificationRecipient)
+	addUser := func(username string, change func(*api.NotificationSettings)) api.Address {
+		settings := api.DefaultNotificationSettings(username)
+		change(settings)
+		addr := api.CalculatePublicKeyByUsername(username)
+		recipients[addr] = api.NotificationRecipient{User: dbtypes.User{Username: username}, Settings: settings}
+		return addr
+	}
+	bob := addUser("bob", func(*api.NotificationSettings) {})
+	carol := addUser("carol", func(s *api.NotificationSettings) { s.Conditional = false })
+	dave := addUser("dave", func(s *api.NotificationSettings) { s.Received = false })
+	erin := addUser("erin", func(s *api.NotificationSettings) {
+		s.QuietHours = &api.QuietHours{From: now.Add(-time.Hour).Format("15:04"), To: now.Add(time.Hour).Format("15:04")}
+	})
+	stranger := api.Address{0xee}
+
+	lookup := func(addrs []api.Address) ([]api.NotificationRecipient, error) {
+		var res []api.NotificationRecipient
+		for _, addr := range addrs {
+			if recipient, ok := recipients[addr]; ok {
+				res = append(res, recipient)
+			}
+		}
+		return res, nil
+	}
+	channel := &fakeChannel{sent: make(chan sentNotification, 16)}
+	notifier := api.NewNotifier(lookup, channel)
+
+	bus := api.NewEventBus()
+	ticks := make(chan time.Time)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := bus.Subscribe(api.EventBufferSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		notifier.Run(ctx, sub, ticks)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	transfer := func(from, to api.Address, integer int64, scheduled bool) *api.Transaction {
+		tx := &api.Transaction{ID: integer, TxType: api.Transfer, From: &api.Account{Address: from},
+			To: &api.Account{Address: to}, Value: api.Value_{Integer: integer}}
+		if scheduled {
+			tx.Condition = &api.Filter{}
+		}
+		return tx
+	}
+	number := utils.BlockNumber(7)
+	for _, tx := range []*api.Transaction{
+		transfer(stranger, bob, 1, false),
+		transfer(stranger, bob, 2, false),
+		transfer(stranger, dave, 3, false), // received notifications are off
+		transfer(carol, stranger, 4, true), // scheduled notifications are off
+		transfer(dave, stranger, 5, true),  // scheduled tx executed
+		transfer(stranger, erin, 6, false), // held by quiet hours
+	} {
+		bus.Publish(api.Event{Type: api.EventTx, Tx: tx, BlockNumber: &number})
+	}
+
+	select {
+	case note := <-channel.sent:
+		t.Fatalf("notification %+v is sent before the block", note)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	bus.Publish(api.Event{Type: api.EventBlock, Block: &api.BlockSummary{Number: number}})
+	sent := channel.expect(t, 2)
+	if note := sent["bob"]; note.subject != "2 notifications" || strings.Count(note.text, "Received") != 2 {
+		t.Errorf("txs of the block aren't grouped: %+v", note)
+	}
+	if note := sent["dave"]; !strings.Contains(note.text, "#5 executed in block 7") || strings.Contains(note.text, "Received") {
+		t.Errorf("unexpected notification of dave: %+v", note)
+	}
+
+	ticks <- now
+	channel.expect(t, 0)
+	ticks <- now.Add(3 * time.Hour)
+	released := channel.expect(t, 1)
+	if note := released["erin"]; !strings.HasPrefix(note.text, "While quiet hours were on:") || !strings.Contains(note.text, "Received 6") {
+		t.Errorf("held notification isn't released: %+v", note)
+	}
+}
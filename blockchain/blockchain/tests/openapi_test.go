@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var ginParam = regexp.MustCompile(`:(\w+)`)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	spec := struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	if err := json.Unmarshal(api.OpenAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	routes := make(map[string]bool)
+	for _, route := range api.V1Routes() {
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		key := route.Method + " " + path
+		routes[key] = true
+		if _, ok := spec.Paths[path][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("%s is missing in openapi.json", key)
+		}
+	}
+
+	for path, methods := range spec.Paths {
+		for method := range methods {
+			if key := strings.ToUpper(method) + " " + path; !routes[key] {
+				t.Errorf("%s is documented but not routed", key)
+			}
+		}
+	}
+}
+
+// jsonFields returns names the type is encoded with, fields of embedded structs included
+func jsonFields(typ reflect.Type) []string {
+	res := make([]string, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" {
+			res = append(res, jsonFields(field.Type)...)
+			continue
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	spec := struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}{}
+	if err := json.Unmarshal(api.OpenAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range map[string]interface{}{
+		"Account":              api.Account{},
+		"Value":                api.Value_{},
+		"Filter":               api.Filter{},
+		"Leg":                  api.Leg{},
+		"Transaction":          api.Transaction{},
+		"Schedule":             api.Schedule{},
+		"Occurrence":           api.Occurrence{},
+		"BatchTxResult":        api.BatchTxResult{},
+		"ProjectedBalance":     api.ProjectedBalance{},
+		"SimulateTxResponse":   api.SimulateTxResponse{},
+		"ReadAccessRequest":    api.ReadAccessRequest{},
+		"AuditEvent":           api.AuditEvent{},
+		"Metrics":              api.MempoolMetrics{},
+		"Mempool":              api.Mempool{},
+		"Health":               api.Health{},
+		"CreateWebhookRequest": api.CreateWebhookRequest{},
+		"Webhook":              api.Webhook{},
+		"WebhookAttempt":       api.WebhookAttempt{},
+		"WebhookDelivery":      api.WebhookDelivery{},
+		"TelegramLinkCode":     api.TelegramLinkCode{},
+		"QuietHours":           api.QuietHours{},
+		"NotificationSettings": api.NotificationSettings{},
+		"TwoFactorSettings":    api.TwoFactorSettings{},
+		"TwoFactorRequest":     api.TwoFactorRequest{},
+		"TOTPEnrollment":       api.TOTPEnrollment{},
+		"OTPRequest":           api.OTPRequest{},
+		"ParkedTx":             api.ParkedTx{},
+		"OracleEntry":          api.OracleEntry{},
+	} {
+		schema, ok := spec.Components.Schemas[name]
+		if !ok {
+			t.Errorf("schema %s is missing in openapi.json", name)
+			continue
+		}
+		documented := make([]string, 0, len(schema.Properties))
+		for property := range schema.Properties {
+			documented = append(documented, property)
+		}
+		sort.Strings(documented)
+		if fields := jsonFields(reflect.TypeOf(value)); !reflect.DeepEqual(documented, fields) {
+			t.Errorf("schema %s has properties %v, the type is encoded with %v", name, documented, fields)
+		}
+	}
+}
+
+func TestRegisterV1(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.RegisterV1(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, api.V1Prefix+"/openapi.json", nil))
+	if w.Code != http.StatusOK || !json.Valid(w.Body.Bytes()) {
+		t.Errorf("openapi.json isn't served: %d", w.Code)
+	}
+}
@@ -0,0 +1,237 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func rpcCall(t *testing.T, body string) (int, []byte) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/rpc", api.JSONRPC)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body)))
+	return w.Code, w.Body.Bytes()
+}
+
+func rpcErrorCode(t *testing.T, body []byte) int {
+	resp := struct {
+		Error *api.RPCError `json:"error"`
+	}{}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error == nil {
+		t.Fatalf("expected error response, got %s", body)
+	}
+	return resp.Error.Code
+}
+
+func TestRPCErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"parse error", `{"jsonrpc":"2.0","method":`, api.RPCParseError},
+		{"wrong version", `{"jsonrpc":"1.0","method":"eth_blockNumber","id":1}`, api.RPCInvalidRequest},
+		{"not an object", `42`, api.RPCInvalidRequest},
+		{"empty batch", `[]`, api.RPCInvalidRequest},
+		{"unknown method", `{"jsonrpc":"2.0","method":"eth_mining","id":1}`, api.RPCMethodNotFound},
+		{"bad address", `{"jsonrpc":"2.0","method":"eth_getBalance","params":["0x12","latest"],"id":1}`, api.RPCInvalidParams},
+		{"bad block tag", `{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["newest",false],"id":1}`, api.RPCInvalidParams},
+		{"missing params", `{"jsonrpc":"2.0","method":"eth_getTransactionByHash","id":1}`, api.RPCInvalidParams},
+		{"bad raw tx", `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0x7b"],"id":1}`, api.RPCInvalidParams},
+	}
+
+	for _, test := range tests {
+		status, body := rpcCall(t, test.body)
+		if status != http.StatusOK {
+			t.Errorf("%s: status %d", test.name, status)
+		}
+		if code := rpcErrorCode(t, body); code != test.code {
+			t.Errorf("%s: code %d, expected %d", test.name, code, test.code)
+		}
+	}
+}
+
+func TestRPCSendRawTransactionAuth(t *testing.T) {
+	raw := func(body string) string {
+		return `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0x` + hex.EncodeToString([]byte(body)) + `"],"id":1}`
+	}
+
+	// credentials are taken from basic auth only
+	_, body := rpcCall(t, raw(`{"username":"alice","password":"secret","tx":{"txType":1}}`))
+	if code := rpcErrorCode(t, body); code != api.RPCInvalidParams {
+		t.Errorf("login data in params: code %d", code)
+	}
+
+	_, body = rpcCall(t, raw(`{"txType":1,"value":{"integer":1}}`))
+	resp := struct {
+		Error *struct {
+			Code int               `json:"code"`
+			Data map[string]string `json:"data"`
+		} `json:"error"`
+	}{}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error == nil {
+		t.Fatalf("expected error response, got %s", body)
+	}
+	if resp.Error.Code != api.RPCServerError || resp.Error.Data["err_code"] != api.UnauthorizedError.Code {
+		t.Errorf("anonymous tx: %s", body)
+	}
+}
+
+func TestRPCBatch(t *testing.T) {
+	status, body := rpcCall(t, `[
+		{"jsonrpc":"2.0","method":"eth_mining","id":"a"},
+		{"jsonrpc":"2.0","method":"eth_mining"},
+		{"jsonrpc":"2.0","method":"eth_getBlockByHash","params":["0x00"],"id":null},
+		1
+	]`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+
+	var responses []json.RawMessage
+	if err := json.Unmarshal(body, &responses); err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("notification must not be answered, got %s", body)
+	}
+	expected := []struct {
+		id   string
+		code int
+	}{{`"a"`, api.RPCMethodNotFound}, {`null`, api.RPCInvalidParams}, {`null`, api.RPCInvalidRequest}}
+	for i, resp := range responses {
+		parsed := struct {
+			ID json.RawMessage `json:"id"`
+		}{}
+		_ = json.Unmarshal(resp, &parsed)
+		if string(parsed.ID) != expected[i].id {
+			t.Errorf("response %d: id %s, expected %s", i, parsed.ID, expected[i].id)
+		}
+		if code := rpcErrorCode(t, resp); code != expected[i].code {
+			t.Errorf("response %d: code %d, expected %d", i, code, expected[i].code)
+		}
+	}
+
+	status, _ = rpcCall(t, `[{"jsonrpc":"2.0","method":"eth_mining"}]`)
+	if status != http.StatusNoContent {
+		t.Errorf("batch of notifications: status %d", status)
+	}
+}
+
+func TestRPCResponseEncoding(t *testing.T) {
+	JSON, _ := json.Marshal(&api.RPCResponse{JSONRPC: api.RPCVersion, ID: json.RawMessage("1")})
+	if string(JSON) != `{"jsonrpc":"2.0","id":1,"result":null}` {
+		t.Errorf("null result must be kept: %s", JSON)
+	}
+	JSON, _ = json.Marshal(&api.RPCResponse{JSONRPC: api.RPCVersion, ID: json.RawMessage("1"), Error: &api.RPCError{Code: -1}})
+	if strings.Contains(string(JSON), "result") {
+		t.Errorf("error response must not contain result: %s", JSON)
+	}
+}
+
+func TestParseBlockTag(t *testing.T) {
+	for tag, expected := range map[string]int64{"latest": int64(api.LatestBlock), "earliest": 0, "0x1f": 31} {
+		number, err := api.ParseBlockTag(tag)
+		if err != nil || int64(number) != expected {
+			t.Errorf("%s: got %d, %v", tag, number, err)
+		}
+	}
+	if _, err := api.ParseBlockTag("0x-1"); err == nil {
+		t.Error("negative block number must be rejected")
+	}
+	if api.EncodeQuantity(255) != "0xff" || api.EncodeQuantity(0) != "0x0" {
+		t.Error("wrong quantity encoding")
+	}
+}
+
+// serveChain answers block and tx lookups of the rpc methods until the test ends
+func serveChain(t *testing.T, blocks []*api.Block, txs map[api.Hash]api.TxLookup) {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case req := <-api.GetBlockCh:
+				var res *api.Block
+				for _, block := range blocks {
+					if req.Hash != nil && block.GetHash() == *req.Hash || req.Hash == nil && block.Number == req.Number {
+						res = block
+					}
+				}
+				req.ResponseCh <- res
+			case req := <-api.GetTxByHashCh:
+				req.ResponseCh <- txs[req.Hash]
+			}
+		}
+	}()
+}
+
+func rpcResult(t *testing.T, body string, result interface{}) {
+	_, resp := rpcCall(t, body)
+	parsed := struct {
+		Result json.RawMessage `json:"result"`
+	}{}
+	if err := json.Unmarshal(resp, &parsed); err != nil || json.Unmarshal(parsed.Result, result) != nil {
+		t.Fatalf("unexpected response %s", resp)
+	}
+}
+
+func TestRPCBlockRoundTrip(t *testing.T) {
+	transfer := &api.Transaction{ID: 1, TxType: api.Transfer, From: &api.Account{Address: api.Address{1}},
+		To: &api.Account{Address: api.Address{2}}, Value: api.Value_{Integer: 5}}
+	first := &api.Block{Number: 1, Transactions: api.Transactions{transfer}}
+	second := &api.Block{Number: 2, ParentHash: first.GetHash()}
+
+	// the stored block recomputes its hash
+	JSON, _ := json.Marshal(first)
+	stored := &api.Block{}
+	if err := json.Unmarshal(JSON, stored); err != nil || stored.GetHash() != first.GetHash() {
+		t.Fatalf("hash of the stored block differs: %v", err)
+	}
+	serveChain(t, []*api.Block{first, second}, nil)
+
+	block := api.RPCBlock{}
+	rpcResult(t, `{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["0x1",false],"id":1}`, &block)
+	byHash := api.RPCBlock{}
+	rpcResult(t, `{"jsonrpc":"2.0","method":"eth_getBlockByHash","params":["`+block.Hash+`",false],"id":1}`, &byHash)
+	if byHash.Number != "0x1" || byHash.Hash != block.Hash {
+		t.Errorf("block by hash %+v, expected %+v", byHash, block)
+	}
+	child := api.RPCBlock{}
+	rpcResult(t, `{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["0x2",false],"id":1}`, &child)
+	if child.ParentHash != block.Hash {
+		t.Errorf("parent hash %s, expected %s", child.ParentHash, block.Hash)
+	}
+
+	// txs of others are given as hashes
+	full := struct {
+		Transactions []json.RawMessage `json:"transactions"`
+	}{}
+	rpcResult(t, `{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["0x1",true],"id":1}`, &full)
+	txHash := transfer.GetHash()
+	if len(full.Transactions) != 1 || string(full.Transactions[0]) != `"0x`+hex.EncodeToString(txHash[:])+`"` {
+		t.Errorf("unreadable tx is exposed: %s", full.Transactions)
+	}
+}
+
+func TestRPCTransactionScope(t *testing.T) {
+	pending := &api.Transaction{ID: 1, TxType: api.Transfer, From: &api.Account{Address: api.Address{1}},
+		To: &api.Account{Address: api.Address{2}}, Value: api.Value_{Integer: 5}}
+	hash := pending.GetHash()
+	serveChain(t, nil, map[api.Hash]api.TxLookup{hash: {Tx: pending}})
+
+	_, body := rpcCall(t, `{"jsonrpc":"2.0","method":"eth_getTransactionByHash","params":["0x`+hex.EncodeToString(hash[:])+`"],"id":1}`)
+	if !strings.Contains(string(body), `"result":null`) {
+		t.Errorf("pending tx of another user is exposed: %s", body)
+	}
+}
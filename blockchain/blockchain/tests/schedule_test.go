@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"IS/utils"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// Wednesday
+	from := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		schedule api.Schedule
+		expected time.Time
+	}{
+		{api.Schedule{Every: api.EveryDay, At: "09:30"}, time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC)},
+		{api.Schedule{Every: api.EveryDay, At: "10:30"}, time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{api.Schedule{Every: api.EveryWeek, Weekday: "wed", At: "09:00"}, time.Date(2024, 2, 7, 9, 0, 0, 0, time.UTC)},
+		{api.Schedule{Every: api.EveryWeek, Weekday: "Fri"}, time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+		// February is shorter
+		{api.Schedule{Every: api.EveryMonth, MonthDay: 30, At: "12:00"}, time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{api.Schedule{Every: api.EveryMonth, MonthDay: 31, At: "12:00"}, time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{api.Schedule{Every: api.EveryDay, At: "09:00", TimeZone: "Europe/Moscow"}, time.Date(2024, 2, 1, 6, 0, 0, 0, time.UTC)},
+		{api.Schedule{Cron: "*/15 9-17 * * mon-fri"}, time.Date(2024, 1, 31, 10, 15, 0, 0, time.UTC)},
+		{api.Schedule{Cron: "0 8 1 * *"}, time.Date(2024, 2, 1, 8, 0, 0, 0, time.UTC)},
+		// either restricted day matches
+		{api.Schedule{Cron: "0 0 15 * 6"}, time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)},
+	} {
+		if err := c.schedule.Validate(); err != nil {
+			t.Fatalf("%+v: %v", c.schedule, err)
+		}
+		next, ok := c.schedule.Next(from)
+		if !ok || !next.Equal(c.expected) {
+			t.Errorf("%+v: next %v, expected %v", c.schedule, next.UTC(), c.expected)
+		}
+	}
+
+	if _, ok := (&api.Schedule{Cron: "0 0 30 2 *"}).Next(from); ok {
+		t.Error("February 30 is found")
+	}
+}
+
+func TestScheduleValidation(t *testing.T) {
+	start, end := int64(200), int64(100)
+	for _, invalid := range []api.Schedule{
+		{},
+		{EveryBlocks: 5, Every: api.EveryDay},
+		{EveryBlocks: -1},
+		{Every: "hourly"},
+		{Every: api.EveryDay, At: "24:00"},
+		{Every: api.EveryWeek},
+		{Every: api.EveryMonth, MonthDay: 32},
+		{Cron: "* * *"},
+		{Cron: "60 * * * *"},
+		{Cron: "*/0 * * * *"},
+		{EveryBlocks: 5, TimeZone: "Nowhere/City"},
+		{EveryBlocks: 5, Start: &start, End: &end},
+		{EveryBlocks: 5, OnInsufficientFunds: "borrow"},
+	} {
+		if err := invalid.Validate(); !errors.Is(err, api.InvalidScheduleError) {
+			t.Errorf("%+v: unexpected error %v", invalid, err)
+		}
+	}
+}
+
+func TestScheduleUpcoming(t *testing.T) {
+	first := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC).Unix()
+	end := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC).Unix()
+	daily := api.Schedule{Every: api.EveryDay, At: "09:00", End: &end, MaxOccurrences: 5, Occurrences: 1, NextAt: &first}
+
+	upcoming := daily.Upcoming(10)
+	if len(upcoming) != 4 {
+		t.Fatalf("expected 4 occurrences left, got %v", len(upcoming))
+	}
+	if upcoming[0].Number != 2 || *upcoming[3].At != first+3*24*3600 {
+		t.Errorf("unexpected occurrences %+v %+v", upcoming[0], upcoming[3])
+	}
+
+	daily.MaxOccurrences = 0
+	if upcoming = daily.Upcoming(20); len(upcoming) != 9 {
+		t.Errorf("end must limit occurrences: %v", len(upcoming))
+	}
+
+	next := utils.BlockNumber(12)
+	blocks := api.Schedule{EveryBlocks: 4, NextBlock: &next}
+	upcoming = blocks.Upcoming(3)
+	if len(upcoming) != 3 || *upcoming[2].Block != 20 {
+		t.Errorf("unexpected block occurrences %+v", upcoming)
+	}
+}
+
+func TestScheduleRetriesUntilEnd(t *testing.T) {
+	end := int64(1000)
+	retrying := api.Schedule{Every: api.EveryDay, End: &end, OnInsufficientFunds: api.OnInsufficientFundsRetry}
+	if !retrying.Retries(end) {
+		t.Error("occurrence isn't retried before the end")
+	}
+	if retrying.Retries(end + 1) {
+		t.Error("occurrence is retried after the end")
+	}
+
+	retrying.End = nil
+	if !retrying.Retries(end + 1) {
+		t.Error("occurrence of endless schedule isn't retried")
+	}
+	if skipping := (api.Schedule{Every: api.EveryDay}); skipping.Retries(0) {
+		t.Error("skipping schedule retries")
+	}
+}
+
+func TestScheduleCatchUp(t *testing.T) {
+	due := utils.BlockNumber(12)
+	// the node stopped after the block with the second occurrence was written
+	stored := api.Schedule{EveryBlocks: 4, Occurrences: 1, NextBlock: &due}
+	finalized := stored
+	finalized.Of = 7
+	state := &api.ConditionState{LastFinalizedNumber: 12}
+
+	if !stored.CatchUp(&finalized, state) {
+		t.Fatal("stale schedule isn't caught up")
+	}
+	if stored.Number() != 3 || *stored.NextBlock != 16 {
+		t.Errorf("unexpected progress: occurrence %d at block %d", stored.Number(), *stored.NextBlock)
+	}
+	if stored.CatchUp(&finalized, state) || stored.Number() != 3 {
+		t.Error("up to date schedule is advanced again")
+	}
+}
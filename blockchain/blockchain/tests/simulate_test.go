@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"testing"
+)
+
+func TestSimulateTx(t *testing.T) {
+	alice, bob := api.Address{1}, api.Address{2}
+	head := func(addr api.Address) api.Value_ {
+		if addr == alice {
+			return api.Value_{Integer: 10}
+		}
+		return api.Value_{}
+	}
+	transfer := func(from, to api.Address, integer int64) *api.Transaction {
+		return &api.Transaction{
+			TxType: api.Transfer,
+			From:   &api.Account{Address: from},
+			To:     &api.Account{Address: to},
+			Value:  api.Value_{Integer: integer},
+		}
+	}
+
+	for _, c := range []struct {
+		name    string
+		pending api.Transactions
+		tx      *api.Transaction
+		err     *api.APIError
+		after   map[api.Address]api.Value_
+	}{
+		{
+			name:  "covered by head state",
+			tx:    transfer(alice, bob, 4),
+			after: map[api.Address]api.Value_{alice: {Integer: 6}, bob: {Integer: 4}},
+		},
+		{
+			name:    "pending txs are applied",
+			pending: api.Transactions{transfer(alice, bob, 3)},
+			tx:      transfer(alice, bob, 4),
+			after:   map[api.Address]api.Value_{alice: {Integer: 3}, bob: {Integer: 7}},
+		},
+		{
+			name:    "funds are spent by pending txs",
+			pending: api.Transactions{transfer(alice, bob, 8)},
+			tx:      transfer(alice, bob, 4),
+			err:     api.InsufficientFundsError,
+		},
+		{
+			name:    "received in pending txs",
+			pending: api.Transactions{transfer(alice, bob, 5)},
+			tx:      transfer(bob, alice, 5),
+			after:   map[api.Address]api.Value_{alice: {Integer: 10}, bob: {}},
+		},
+		{
+			name: "no recipient",
+			tx:   &api.Transaction{TxType: api.Transfer, From: &api.Account{Address: alice}},
+			err:  api.MissingRecipientError,
+		},
+	} {
+		balanceOf := api.PendingBalance(head, c.pending)
+		res := c.tx.Simulate(&api.BlockChain{}, balanceOf)
+
+		// submission is validated against the same basis
+		if err := c.tx.Validate(&api.BlockChain{}, balanceOf); (err == nil) != res.Valid {
+			t.Errorf("%s: simulated %v, validated %v", c.name, res.Valid, err)
+		}
+		if c.err != nil {
+			if res.Valid || res.Error == nil || res.Error.Code != c.err.Code {
+				t.Errorf("%s: expected %v, got %+v", c.name, c.err.Code, res)
+			}
+			continue
+		}
+		if !res.Valid || len(res.Balances) != len(c.after) {
+			t.Errorf("%s: unexpected result %+v", c.name, res)
+			continue
+		}
+		for _, balance := range res.Balances {
+			if balance.Before != balanceOf(balance.Address) || balance.After != c.after[balance.Address] {
+				t.Errorf("%s: unexpected balance %+v", c.name, balance)
+			}
+		}
+	}
+}
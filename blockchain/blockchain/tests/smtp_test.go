@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"IS/blockchain/config"
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeSMTP accepts one session and sends the envelope and data it got
+func fakeSMTP(t *testing.T) (port string, received <-chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	ch := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+		reply := func(line string) {
+			w.WriteString(line + "\r\n")
+			w.Flush()
+		}
+
+		var lines []string
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				ch <- lines
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch command := strings.ToUpper(strings.Fields(line + " ")[0]); command {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL", "RCPT":
+				lines = append(lines, line)
+				reply("250 OK")
+			case "DATA":
+				reply("354 go ahead")
+				for {
+					line, err = r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					lines = append(lines, strings.TrimRight(line, "\r\n"))
+				}
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				ch <- lines
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port), ch
+}
+
+func TestSMTPMailer(t *testing.T) {
+	port, received := fakeSMTP(t)
+	mailer := api.NewSMTPMailer(&config.Config{SMTPHost: "127.0.0.1", SMTPPort: port, SMTPFrom: "noreply@example.com", SMTPSecurity: api.SMTPNone})
+
+	if err := mailer.Send("alice@example.com", "Received 1.50", "Received 1.50\nfrom somebody"); err != nil {
+		t.Fatal(err)
+	}
+	lines := <-received
+	message := strings.Join(lines, "\n")
+	for _, expected := range []string{"MAIL FROM:<noreply@example.com>", "RCPT TO:<alice@example.com>",
+		"To: alice@example.com", "Subject: Received 1.50", "Received 1.50\nfrom somebody"} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("%q is missing in:\n%s", expected, message)
+		}
+	}
+}
+
+func TestRenderNotification(t *testing.T) {
+	for _, c := range []struct {
+		note    api.Notification
+		subject string
+		text    string
+	}{
+		{api.Notification{Kind: api.NotifyReceived, Value: api.Value_{Integer: 1, Fractional: 5}, Counterparty: "abc"},
+			"Received 1.05", "Received 1.05 from abc"},
+		{api.Notification{Kind: api.NotifyScheduledFailed, TxID: 7, Reason: "insufficient funds"},
+			"Scheduled transaction #7 failed", "Scheduled transaction #7 failed: insufficient funds"},
+		{api.Notification{Kind: api.NotifyThreshold, Value: api.Value_{Integer: 5}, Threshold: api.Value_{Integer: 10}},
+			"Balance is below 10.00", "Balance is below 10.00: 5.00"},
+		{api.Notification{Kind: api.NotifySecurity, Event: api.AuditLoginFailed, Details: map[string]string{"reason": "invalid password"}},
+			"Security alert", "Failed login attempt: invalid password"},
+	} {
+		subject, text, err := api.RenderNotification(c.note)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subject != c.subject || text != c.text {
+			t.Errorf("%v: got %q %q, expected %q %q", c.note.Kind, subject, text, c.subject, c.text)
+		}
+	}
+
+	if _, _, err := api.RenderNotification(api.Notification{Kind: "unknown"}); err == nil {
+		t.Error("unknown kind is rendered")
+	}
+}
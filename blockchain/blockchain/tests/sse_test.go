@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	db_types "IS/blockchain/database_utils/types"
+	"IS/utils"
+	"bufio"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sseServer serves EventStream to an admin without touching the database
+func sseServer(t *testing.T) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/events", func(c *gin.Context) {
+		c.Set("user", &db_types.User{Username: "admin", Role: db_types.RoleAdmin}) // what Authorize sets
+	}, api.EventStream)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestEventStreamInvalidLastEventID(t *testing.T) {
+	server := sseServer(t)
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status %d", resp.StatusCode)
+	}
+}
+
+func TestEventStreamLive(t *testing.T) {
+	server := sseServer(t)
+	watched, other := api.Address{1}, api.Address{2}
+
+	resp, err := http.Get(server.URL + "/events?address=" + utils.ToHex(watched[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("content type %q", ct)
+	}
+
+	// headers are flushed after the handler subscribed
+	number := utils.BlockNumber(7)
+	api.Events.Publish(api.Event{Type: api.EventTx, BlockNumber: &number,
+		Tx: &api.Transaction{TxType: api.Transfer, From: &api.Account{Address: other}, To: &api.Account{Address: other}}})
+	api.Events.Publish(api.Event{Type: api.EventTx, BlockNumber: &number,
+		Tx: &api.Transaction{TxType: api.Transfer, From: &api.Account{Address: other}, To: &api.Account{Address: watched}}})
+	api.Events.Publish(api.Event{Type: api.EventBlock, Block: &api.BlockSummary{Number: number, TxCount: 2}})
+
+	received := make([]string, 0, 2)
+	scanner := bufio.NewScanner(resp.Body)
+	for len(received) < 2 && scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "event:") || strings.HasPrefix(line, "id:") {
+			received = append(received, line)
+		}
+	}
+
+	if len(received) != 2 || received[0] != "event:tx" || received[1] != "id:7" {
+		t.Errorf("expected tx of watched address then block 7, got %v", received)
+	}
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBotAPI serves getMe, getUpdates and sendMessage of Bot API
+type fakeBotAPI struct {
+	mu      sync.Mutex
+	updates []string // message texts, each is sent once
+	sent    chan string
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/getMe"):
+		fmt.Fprint(w, `{"ok":true,"result":{"id":1,"first_name":"bot","username":"test_bot"}}`)
+	case strings.HasSuffix(r.URL.Path, "/getUpdates"):
+		f.mu.Lock()
+		updates := f.updates
+		f.updates = nil
+		f.mu.Unlock()
+		if len(updates) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		results := make([]string, 0, len(updates))
+		for i, text := range updates {
+			command := strings.Fields(text)[0]
+			results = append(results, fmt.Sprintf(`{"update_id":%d,"message":{"message_id":%d,"date":0,`+
+				`"from":{"id":42,"first_name":"user"},"chat":{"id":42,"type":"private"},"text":%q,`+
+				`"entities":[{"type":"bot_command","offset":0,"length":%d}]}}`, i+1, i+1, text, len(command)))
+		}
+		fmt.Fprintf(w, `{"ok":true,"result":[%s]}`, strings.Join(results, ","))
+	case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+		_ = r.ParseForm()
+		f.sent <- r.PostForm.Get("chat_id") + ":" + r.PostForm.Get("text")
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":100,"date":0,"chat":{"id":42,"type":"private"}}}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestTelegramBotAgainstFakeServer(t *testing.T) {
+	fake := &fakeBotAPI{updates: []string{"/help", "/link"}, sent: make(chan string, 2)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	bot, err := api.NewTelegramBot("token", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bot.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for _, expected := range []string{"42:/link <code>", "42:Usage: /link <code>"} {
+		select {
+		case msg := <-fake.sent:
+			if !strings.HasPrefix(msg, expected) {
+				t.Errorf("unexpected reply %q, expected %q", msg, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reply")
+		}
+	}
+}
+
+func TestTelegramBotRejectsInvalidToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"ok":false,"error_code":401,"description":"Unauthorized"}`)
+	}))
+	defer server.Close()
+
+	if _, err := api.NewTelegramBot("invalid", server.URL); err == nil {
+		t.Error("bot started with invalid token")
+	}
+}
+
+func TestTelegramLinkCode(t *testing.T) {
+	first := api.IssueTelegramLinkCode("alice")
+	second := api.IssueTelegramLinkCode("alice")
+
+	if _, ok := api.ConsumeTelegramLinkCode(first.Code); ok {
+		t.Error("code must be revoked by the next one")
+	}
+	username, ok := api.ConsumeTelegramLinkCode(strings.ToLower(second.Code))
+	if !ok || username != "alice" {
+		t.Errorf("unexpected link %v %v", username, ok)
+	}
+	if _, ok = api.ConsumeTelegramLinkCode(second.Code); ok {
+		t.Error("code must be used once")
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"testing"
+	"time"
+)
+
+// base32 of the RFC 6238 SHA-1 key "12345678901234567890"
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// last 6 digits of RFC 6238 test vectors
+	for unix, expected := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		code, err := api.TOTPCode(rfcTOTPSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("%v: code %v, expected %v", unix, code, expected)
+		}
+	}
+
+	if _, err := api.TOTPCode("not base32!", time.Now()); err == nil {
+		t.Error("invalid secret is accepted")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := api.NewTOTPSecret()
+	now := time.Unix(1700000000, 0)
+	code, err := api.TOTPCode(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, at := range []time.Time{now.Add(-30 * time.Second), now, now.Add(30 * time.Second)} {
+		// the step is recorded as used, so the code can't be replayed within the window
+		if step, ok := api.VerifyTOTP(secret, code, at); !ok || step != now.Unix()/30 {
+			t.Errorf("code of the adjacent period must be accepted with its step, got %v %v", step, ok)
+		}
+	}
+	if _, ok := api.VerifyTOTP(secret, code, now.Add(2*time.Minute)); ok {
+		t.Error("stale code is accepted")
+	}
+	if _, ok := api.VerifyTOTP(api.NewTOTPSecret(), code, now); ok {
+		t.Error("code of another secret is accepted")
+	}
+}
+
+func TestTwoFactorNeedsApproval(t *testing.T) {
+	threshold := func(integer int64) *api.Value_ {
+		return &api.Value_{Integer: integer}
+	}
+	current := api.TwoFactorSettings{Threshold: threshold(100), Telegram: true, TOTP: true, TOTPSecret: "OLD"}
+
+	for _, c := range []struct {
+		name   string
+		change func(s *api.TwoFactorSettings)
+		needed bool
+	}{
+		{"threshold is raised", func(s *api.TwoFactorSettings) { s.Threshold = threshold(500) }, true},
+		{"threshold is removed", func(s *api.TwoFactorSettings) { s.Threshold = nil }, true},
+		{"telegram is turned off", func(s *api.TwoFactorSettings) { s.Telegram = false }, true},
+		{"totp secret is issued", func(s *api.TwoFactorSettings) { s.PendingTOTPSecret = "NEW" }, true},
+		{"totp secret is replaced", func(s *api.TwoFactorSettings) { s.TOTPSecret = "NEW" }, true},
+		{"threshold is lowered", func(s *api.TwoFactorSettings) { s.Threshold = threshold(50) }, false},
+		{"nothing changes", func(s *api.TwoFactorSettings) {}, false},
+	} {
+		next := current
+		c.change(&next)
+		if current.NeedsApproval(&next) != c.needed {
+			t.Errorf("%s: expected approval needed %v", c.name, c.needed)
+		}
+	}
+
+	// a user without factors has nothing to approve with
+	unprotected := api.TwoFactorSettings{}
+	if unprotected.NeedsApproval(&api.TwoFactorSettings{PendingTOTPSecret: "NEW"}) {
+		t.Error("first enrollment needs approval")
+	}
+	// a factor added while another one protects transfers can be the attacker's
+	totpOnly := api.TwoFactorSettings{Threshold: threshold(100), TOTP: true, TOTPSecret: "OLD"}
+	if !totpOnly.NeedsApproval(&api.TwoFactorSettings{Threshold: threshold(100), TOTP: true, TOTPSecret: "OLD", Telegram: true}) {
+		t.Error("telegram is added without approval")
+	}
+}
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"event":"incoming"}`)
+	signature := api.SignWebhookPayload("secret", 1700000000, body)
+
+	if !api.VerifyWebhookSignature("secret", 1700000000, body, signature) {
+		t.Error("valid signature is rejected")
+	}
+	if api.VerifyWebhookSignature("other", 1700000000, body, signature) {
+		t.Error("signature with another secret is accepted")
+	}
+	if api.VerifyWebhookSignature("secret", 1700000001, body, signature) {
+		t.Error("signature with another timestamp is accepted")
+	}
+}
+
+func TestWebhookRequestIsSigned(t *testing.T) {
+	hook := &api.Webhook{ID: "hook", Secret: "secret"}
+	delivery := &api.WebhookDelivery{ID: "delivery", Event: api.WebhookIncoming, Payload: `{"event":"incoming"}`}
+
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+	hook.URL = receiver.URL
+
+	req, err := api.NewWebhookRequest(hook, delivery, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	r, body := <-received, <-bodies
+	if string(body) != delivery.Payload {
+		t.Errorf("unexpected body %s", body)
+	}
+	if r.Header.Get(api.WebhookEventHeader) != api.WebhookIncoming || r.Header.Get(api.WebhookDeliveryHeader) != "delivery" {
+		t.Errorf("unexpected headers %v", r.Header)
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(api.WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !api.VerifyWebhookSignature("secret", timestamp, body, r.Header.Get(api.WebhookSignatureHeader)) {
+		t.Error("receiver can't verify signature")
+	}
+}
+
+func TestWebhookIPAllowed(t *testing.T) {
+	_, office, _ := net.ParseCIDR("10.1.0.0/16")
+	for ip, allowed := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.0.0.1":         false,
+		"10.1.2.3":         true, // allowed network
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"0.0.0.0":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if api.WebhookIPAllowed(net.ParseIP(ip), []*net.IPNet{office}) != allowed {
+			t.Errorf("%s: expected allowed %v", ip, allowed)
+		}
+	}
+}
+
+func TestWebhookClientRefusesInternal(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	if resp, err := api.NewWebhookClient(nil).Get(receiver.URL); err == nil {
+		resp.Body.Close()
+		t.Error("loopback receiver is called")
+	}
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	resp, err := api.NewWebhookClient([]*net.IPNet{loopback}).Get(receiver.URL)
+	if err != nil {
+		t.Fatalf("allowed network is refused: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second} {
+		if delay := api.RetryDelay(time.Second, attempt); delay != expected {
+			t.Errorf("attempt %v: delay %v, expected %v", attempt, delay, expected)
+		}
+	}
+}
+
+func TestMatchWebhook(t *testing.T) {
+	alice := &api.Account{Address: api.Address{1}}
+	bob := &api.Account{Address: api.Address{2}}
+	hook := &api.Webhook{
+		Events:    []string{api.WebhookIncoming, api.WebhookOutgoing, api.WebhookConditionalExecuted, api.WebhookConditionalFailed},
+		Addresses: []api.Address{bob.Address},
+	}
+	number := api.LatestBlock + 1
+
+	transfer := &api.Transaction{TxType: api.Transfer, From: alice, To: bob, Value: api.Value_{Integer: 1}, Fee: &api.Value_{}}
+	matches := api.MatchWebhook(hook, api.Event{Type: api.EventTx, Tx: transfer, BlockNumber: &number})
+	if len(matches) != 1 || matches[0].Event != api.WebhookIncoming || matches[0].Address != bob.Address {
+		t.Errorf("unexpected matches %v", matches)
+	}
+
+	conditional := *transfer
+	conditional.From, conditional.To = bob, alice
+	conditional.Condition = &api.Filter{}
+	matches = api.MatchWebhook(hook, api.Event{Type: api.EventTx, Tx: &conditional, BlockNumber: &number})
+	if len(matches) != 2 || matches[0].Event != api.WebhookOutgoing || matches[1].Event != api.WebhookConditionalExecuted {
+		t.Errorf("unexpected matches %v", matches)
+	}
+
+	failed := api.Event{Type: api.EventTxStatus, Tx: &conditional, Status: api.NewTxStatus(&conditional, api.TxStatusFailed, "insufficient_funds")}
+	if matches = api.MatchWebhook(hook, failed); len(matches) != 1 || matches[0].Event != api.WebhookConditionalFailed {
+		t.Errorf("unexpected matches %v", matches)
+	}
+
+	hook.Events = []string{api.WebhookOutgoing}
+	if matches = api.MatchWebhook(hook, api.Event{Type: api.EventTx, Tx: transfer, BlockNumber: &number}); len(matches) != 0 {
+		t.Errorf("unsubscribed event matched: %v", matches)
+	}
+}
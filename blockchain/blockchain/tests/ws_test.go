@@ -0,0 +1,29 @@
+package tests
+
+import (
+	"IS/blockchain/blockchain/api"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWSOriginAllowed(t *testing.T) {
+	api.AllowWSOrigins([]string{"https://wallet.example.com"})
+	defer api.AllowWSOrigins(nil)
+
+	for origin, allowed := range map[string]bool{
+		"":                           true, // not a browser
+		"http://node.example.com":    true,
+		"https://wallet.example.com": true,
+		"https://evil.example.com":   false,
+		"http://wallet.example.com":  false,
+		"null":                       false,
+	} {
+		req := httptest.NewRequest("GET", "http://node.example.com/v1/ws", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if api.WSOriginAllowed(req) != allowed {
+			t.Errorf("origin %q: expected allowed %v", origin, allowed)
+		}
+	}
+}
@@ -2,11 +2,15 @@ package config
 
 import (
 	"IS/utils"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type (
@@ -27,17 +31,49 @@ type (
 	}
 
 	Config struct {
-		DataDirectory              string
-		HttpAddress                string
-		HttpPort                   string
-		LogsDir                    string
-		DataBaseAddress            string
-		DataBasePort               string
-		DataBaseName               string
-		UsersCollectionName        string
-		StateCollectionName        string
-		TransactionsCollectionName string
-		ApiOnly                    bool // no tg bot
+		DataDirectory               string
+		HttpAddress                 string
+		HttpPort                    string
+		GrpcPort                    string
+		LogsDir                     string
+		DataBaseAddress             string
+		DataBasePort                string
+		DataBaseName                string
+		UsersCollectionName         string
+		StateCollectionName         string
+		TransactionsCollectionName  string
+		FrozenCollectionName        string
+		MempoolCollectionName       string
+		AuditCollectionName         string
+		WebhooksCollectionName      string
+		DeliveriesCollectionName    string
+		NotificationsCollectionName string
+		OraclesCollectionName       string
+		AdminUsername               string
+		AdminPassword               string `json:"-"`
+		ShutdownTimeout             time.Duration
+		MempoolSize                 int
+		MempoolAccountLimit         int
+		MempoolTxTTL                time.Duration
+		ConfirmationTTL             time.Duration // of transfers awaiting the second factor
+		OracleMaxAge                time.Duration // older oracle values don't meet conditions, 0 - no bound
+		FeePolicy                   string        // none, flat, per-byte
+		FeeAmount                   string
+		TreasuryAddress             string // hex
+		WebhookMaxAttempts          int
+		WebhookRetryBackoff         time.Duration
+		TelegramToken               string `json:"-"` // kept out of the printed config
+		TelegramAPIURL              string // Bot API server, a fake one in tests
+		SMTPHost                    string // email notifications are off if empty
+		SMTPPort                    string
+		SMTPUsername                string
+		SMTPPassword                string `json:"-"`
+		SMTPFrom                    string
+		SMTPSecurity                string   // tls, starttls, none
+		WSOrigins                   []string // allowed cross-origin WebSocket upgrades
+		WebhookPrivateNets          []string // CIDRs of private networks webhooks may call
+		PublicURL                   string   // base of links sent to users
+		ApiOnly                     bool     // no tg bot
 	}
 )
 
@@ -73,6 +109,10 @@ func (f *Flag) FlagValue() string {
 	return f.Flag
 }
 
+const addressLen = 20
+
+var valueRegexp = regexp.MustCompile(`^\d+(\.\d{1,2})?$`)
+
 var (
 	helpFlag = BoolFlag{ // special flag 🤗
 		Flag: Flag{
@@ -150,6 +190,24 @@ var (
 			},
 			DefaultValue: "9545",
 		},
+		{
+			Flag: Flag{
+				Flag:        "--grpc-port",
+				Required:    false,
+				Description: "set gRPC port",
+				Processor: func(config *Config, data string) error {
+					if _, err := strconv.Atoi(data); err != nil {
+						return fmt.Errorf("invalid port: \"%v\"", data)
+					}
+					config.GrpcPort = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.GrpcPort = defaultValue
+				},
+			},
+			DefaultValue: "9546",
+		},
 		{
 			Flag: Flag{
 				Flag:        "--logs-dir",
@@ -247,6 +305,511 @@ var (
 			},
 			DefaultValue: "transactions",
 		},
+		{
+			Flag: Flag{
+				Flag:        "--mempool-collection-name",
+				Required:    false,
+				Description: "set pending txs collection name to use right collection",
+				Processor: func(config *Config, data string) error {
+					config.MempoolCollectionName = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.MempoolCollectionName = defaultValue
+				},
+			},
+			DefaultValue: "mempool",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--notifications-collection-name",
+				Required:    false,
+				Description: "set notification settings collection name to use right collection",
+				Processor: func(config *Config, data string) error {
+					config.NotificationsCollectionName = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.NotificationsCollectionName = defaultValue
+				},
+			},
+			DefaultValue: "notifications",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--oracles-collection-name",
+				Required:    false,
+				Description: "set oracle values collection name to use right collection",
+				Processor: func(config *Config, data string) error {
+					config.OraclesCollectionName = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.OraclesCollectionName = defaultValue
+				},
+			},
+			DefaultValue: "oracles",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--webhooks-collection-name",
+				Required:    false,
+				Description: "set webhooks collection name to use right collection",
+				Processor: func(config *Config, data string) error {
+					config.WebhooksCollectionName = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.WebhooksCollectionName = defaultValue
+				},
+			},
+			DefaultValue: "webhooks",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--deliveries-collection-name",
+				Required:    false,
+				Description: "set webhook deliveries collection name to use right collection",
+				Processor: func(config *Config, data string) error {
+					config.DeliveriesCollectionName = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.DeliveriesCollectionName = defaultValue
+				},
+			},
+			DefaultValue: "webhook_deliveries",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--frozen-collection-name",
+				Required:    false,
+				Description: "set frozen addresses collection name to use right collection",
+				Processor: func(config *Config, data string) error {
+					config.FrozenCollectionName = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.FrozenCollectionName = defaultValue
+				},
+			},
+			DefaultValue: "frozen",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--audit-collection-name",
+				Required:    false,
+				Description: "set audit log collection name to use right collection",
+				Processor: func(config *Config, data string) error {
+					config.AuditCollectionName = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.AuditCollectionName = defaultValue
+				},
+			},
+			DefaultValue: "audit",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--shutdown-timeout",
+				Required:    false,
+				Description: "set graceful shutdown timeout in seconds",
+				Processor: func(config *Config, data string) error {
+					seconds, err := strconv.Atoi(data)
+					if err != nil || seconds <= 0 {
+						return fmt.Errorf("invalid shutdown timeout: \"%v\"", data)
+					}
+					config.ShutdownTimeout = time.Duration(seconds) * time.Second
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					seconds, _ := strconv.Atoi(defaultValue)
+					config.ShutdownTimeout = time.Duration(seconds) * time.Second
+				},
+			},
+			DefaultValue: "15",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--mempool-size",
+				Required:    false,
+				Description: "set max count of pending transactions, 0 - unlimited",
+				Processor: func(config *Config, data string) error {
+					size, err := strconv.Atoi(data)
+					if err != nil || size < 0 {
+						return fmt.Errorf("invalid mempool size: \"%v\"", data)
+					}
+					config.MempoolSize = size
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.MempoolSize, _ = strconv.Atoi(defaultValue)
+				},
+			},
+			DefaultValue: "10000",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--mempool-account-limit",
+				Required:    false,
+				Description: "set max count of pending transactions per account, 0 - unlimited",
+				Processor: func(config *Config, data string) error {
+					limit, err := strconv.Atoi(data)
+					if err != nil || limit < 0 {
+						return fmt.Errorf("invalid mempool account limit: \"%v\"", data)
+					}
+					config.MempoolAccountLimit = limit
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.MempoolAccountLimit, _ = strconv.Atoi(defaultValue)
+				},
+			},
+			DefaultValue: "64",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--mempool-tx-ttl",
+				Required:    false,
+				Description: "set pending transaction time to live in seconds, 0 - forever",
+				Processor: func(config *Config, data string) error {
+					seconds, err := strconv.Atoi(data)
+					if err != nil || seconds < 0 {
+						return fmt.Errorf("invalid mempool tx ttl: \"%v\"", data)
+					}
+					config.MempoolTxTTL = time.Duration(seconds) * time.Second
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					seconds, _ := strconv.Atoi(defaultValue)
+					config.MempoolTxTTL = time.Duration(seconds) * time.Second
+				},
+			},
+			DefaultValue: "3600",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--confirmation-ttl",
+				Required:    false,
+				Description: "set time in seconds to confirm a large transfer with the second factor",
+				Processor: func(config *Config, data string) error {
+					seconds, err := strconv.Atoi(data)
+					if err != nil || seconds <= 0 {
+						return fmt.Errorf("invalid confirmation ttl: \"%v\"", data)
+					}
+					config.ConfirmationTTL = time.Duration(seconds) * time.Second
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					seconds, _ := strconv.Atoi(defaultValue)
+					config.ConfirmationTTL = time.Duration(seconds) * time.Second
+				},
+			},
+			DefaultValue: "300",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--oracle-max-age",
+				Required:    false,
+				Description: "set time in seconds an oracle value meets conditions after it was reported, 0 - forever",
+				Processor: func(config *Config, data string) error {
+					seconds, err := strconv.Atoi(data)
+					if err != nil || seconds < 0 {
+						return fmt.Errorf("invalid oracle max age: \"%v\"", data)
+					}
+					config.OracleMaxAge = time.Duration(seconds) * time.Second
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					seconds, _ := strconv.Atoi(defaultValue)
+					config.OracleMaxAge = time.Duration(seconds) * time.Second
+				},
+			},
+			DefaultValue: "0",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--webhook-max-attempts",
+				Required:    false,
+				Description: "set webhook delivery attempts before it goes to dead letters",
+				Processor: func(config *Config, data string) error {
+					attempts, err := strconv.Atoi(data)
+					if err != nil || attempts < 1 {
+						return fmt.Errorf("invalid webhook max attempts: \"%v\"", data)
+					}
+					config.WebhookMaxAttempts = attempts
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.WebhookMaxAttempts, _ = strconv.Atoi(defaultValue)
+				},
+			},
+			DefaultValue: "6",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--webhook-retry-backoff",
+				Required:    false,
+				Description: "set delay before the first webhook retry in seconds, doubled on each attempt",
+				Processor: func(config *Config, data string) error {
+					seconds, err := strconv.Atoi(data)
+					if err != nil || seconds < 1 {
+						return fmt.Errorf("invalid webhook retry backoff: \"%v\"", data)
+					}
+					config.WebhookRetryBackoff = time.Duration(seconds) * time.Second
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					seconds, _ := strconv.Atoi(defaultValue)
+					config.WebhookRetryBackoff = time.Duration(seconds) * time.Second
+				},
+			},
+			DefaultValue: "5",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--webhook-private-nets",
+				Required:    false,
+				Description: "comma separated CIDRs of loopback, private or link-local networks webhooks may be delivered to, like 10.1.0.0/16, they are refused by default",
+				Processor: func(config *Config, data string) error {
+					config.WebhookPrivateNets = nil
+					for _, cidr := range strings.Split(data, ",") {
+						if cidr = strings.TrimSpace(cidr); cidr == "" {
+							continue
+						}
+						if _, _, err := net.ParseCIDR(cidr); err != nil {
+							return fmt.Errorf("invalid network: \"%v\"", cidr)
+						}
+						config.WebhookPrivateNets = append(config.WebhookPrivateNets, cidr)
+					}
+					return nil
+				},
+			},
+		},
+		{
+			Flag: Flag{
+				Flag:        "--fee-policy",
+				Required:    false,
+				Description: "set transaction fee policy: none, flat or per-byte",
+				Processor: func(config *Config, data string) error {
+					if ok, _ := utils.Contains(data, []string{"none", "flat", "per-byte"}); !ok {
+						return fmt.Errorf("unknown fee policy: \"%v\"", data)
+					}
+					config.FeePolicy = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.FeePolicy = defaultValue
+				},
+			},
+			DefaultValue: "none",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--fee-amount",
+				Required:    false,
+				Description: "set flat fee or fee per byte, e.g. 0.01",
+				Processor: func(config *Config, data string) error {
+					if !valueRegexp.MatchString(data) {
+						return fmt.Errorf("invalid fee amount: \"%v\"", data)
+					}
+					config.FeeAmount = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.FeeAmount = defaultValue
+				},
+			},
+			DefaultValue: "0.01",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--treasury-address",
+				Required:    false,
+				Description: "set hex address which receives fees, required unless fee policy is none",
+				Processor: func(config *Config, data string) error {
+					if addr, err := hex.DecodeString(strings.TrimPrefix(data, "0x")); err != nil || len(addr) != addressLen {
+						return fmt.Errorf("invalid treasury address: \"%v\"", data)
+					}
+					config.TreasuryAddress = strings.TrimPrefix(data, "0x")
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.TreasuryAddress = defaultValue
+				},
+			},
+			DefaultValue: "",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--tg-token",
+				Required:    false,
+				Description: "telegram bot token, the bot doesn't start without it",
+				Processor: func(config *Config, data string) error {
+					config.TelegramToken = data
+					return nil
+				},
+			},
+		},
+		{
+			Flag: Flag{
+				Flag:        "--tg-api-url",
+				Required:    false,
+				Description: "telegram Bot API server",
+				Processor: func(config *Config, data string) error {
+					if !strings.HasPrefix(data, "http://") && !strings.HasPrefix(data, "https://") {
+						return fmt.Errorf("invalid url: \"%v\"", data)
+					}
+					config.TelegramAPIURL = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.TelegramAPIURL = defaultValue
+				},
+			},
+			DefaultValue: "https://api.telegram.org",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--smtp-host",
+				Required:    false,
+				Description: "SMTP server for email notifications, they are off without it",
+				Processor: func(config *Config, data string) error {
+					config.SMTPHost = data
+					return nil
+				},
+			},
+		},
+		{
+			Flag: Flag{
+				Flag:        "--smtp-port",
+				Required:    false,
+				Description: "set SMTP port",
+				Processor: func(config *Config, data string) error {
+					if _, err := strconv.Atoi(data); err != nil {
+						return fmt.Errorf("invalid port: \"%v\"", data)
+					}
+					config.SMTPPort = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.SMTPPort = defaultValue
+				},
+			},
+			DefaultValue: "587",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--smtp-username",
+				Required:    false,
+				Description: "SMTP username, no authentication if empty",
+				Processor: func(config *Config, data string) error {
+					config.SMTPUsername = data
+					return nil
+				},
+			},
+		},
+		{
+			Flag: Flag{
+				Flag:        "--smtp-password",
+				Required:    false,
+				Description: "SMTP password",
+				Processor: func(config *Config, data string) error {
+					config.SMTPPassword = data
+					return nil
+				},
+			},
+		},
+		{
+			Flag: Flag{
+				Flag:        "--smtp-from",
+				Required:    false,
+				Description: "sender address of notification emails",
+				Processor: func(config *Config, data string) error {
+					config.SMTPFrom = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.SMTPFrom = defaultValue
+				},
+			},
+			DefaultValue: "noreply@localhost",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--smtp-security",
+				Required:    false,
+				Description: "SMTP connection security: starttls, tls or none",
+				Processor: func(config *Config, data string) error {
+					if ok, _ := utils.Contains(data, []string{"starttls", "tls", "none"}); !ok {
+						return fmt.Errorf("unknown smtp security: \"%v\"", data)
+					}
+					config.SMTPSecurity = data
+					return nil
+				},
+				DefaultProcessor: func(config *Config, defaultValue string) {
+					config.SMTPSecurity = defaultValue
+				},
+			},
+			DefaultValue: "starttls",
+		},
+		{
+			Flag: Flag{
+				Flag:        "--ws-origins",
+				Required:    false,
+				Description: "comma separated origins allowed to open WebSocket in browsers besides the node's own, like https://wallet.example.com",
+				Processor: func(config *Config, data string) error {
+					config.WSOrigins = nil
+					for _, origin := range strings.Split(data, ",") {
+						if origin = strings.TrimSpace(origin); origin == "" {
+							continue
+						}
+						if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+							return fmt.Errorf("invalid origin: \"%v\"", origin)
+						}
+						config.WSOrigins = append(config.WSOrigins, strings.TrimSuffix(origin, "/"))
+					}
+					return nil
+				},
+			},
+		},
+		{
+			Flag: Flag{
+				Flag:        "--public-url",
+				Required:    false,
+				Description: "base URL of the node in links sent to users, like https://node.example.com, the http address if empty",
+				Processor: func(config *Config, data string) error {
+					if !strings.HasPrefix(data, "http://") && !strings.HasPrefix(data, "https://") {
+						return fmt.Errorf("invalid public url: \"%v\"", data)
+					}
+					config.PublicURL = strings.TrimSuffix(data, "/")
+					return nil
+				},
+			},
+		},
+		{
+			Flag: Flag{
+				Flag:        "--admin-username",
+				Required:    false,
+				Description: "create the admin with this nickname at start, an existing user is granted admin role if --admin-password is its password",
+				Processor: func(config *Config, data string) error {
+					config.AdminUsername = data
+					return nil
+				},
+			},
+		},
+		{
+			Flag: Flag{
+				Flag:        "--admin-password",
+				Required:    false,
+				Description: "password of the admin, required by --admin-username",
+				Processor: func(config *Config, data string) error {
+					config.AdminPassword = data
+					return nil
+				},
+			},
+		},
 	}
 	boolFlagsValues     []string
 	valueFlagsValues    []string
@@ -336,6 +899,13 @@ func ArgsToConfig(args []string) (*Config, error) {
 		}
 	}
 
+	if cfg.AdminUsername != "" && cfg.AdminPassword == "" {
+		return nil, fmt.Errorf("--admin-password is required by --admin-username")
+	}
+	// fees sent to the zero address would be burned
+	if cfg.FeePolicy != "none" && strings.Trim(cfg.TreasuryAddress, "0") == "" {
+		return nil, fmt.Errorf("--treasury-address is required by fee policy \"%v\"", cfg.FeePolicy)
+	}
 	return cfg, nil
 }
 
@@ -4,12 +4,20 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const ( // roles
+	RoleUser    = "user"
+	RoleAuditor = "auditor"
+	RoleAdmin   = "admin"
+)
+
 type (
 	User struct {
-		ID             primitive.ObjectID `bson:"_id"`
-		Username       string             `bson:"username"`
-		HashedPassword []byte             `bson:"hashed_password"`
-		TelegramID     int                `bson:"telegram_id,omitempty"`
+		ID             primitive.ObjectID `bson:"_id" json:"id"`
+		Username       string             `bson:"nickname" json:"username"`
+		HashedPassword []byte             `bson:"hashed_password" json:"-"`
+		TelegramID     int                `bson:"telegram_id,omitempty" json:"telegram_id,omitempty"`
+		Role           string             `bson:"role,omitempty" json:"role"`
+		ReadGrants     []string           `bson:"read_grants,omitempty" json:"read_grants,omitempty"` // usernames allowed to read our txs
 	}
 
 	LoginData struct {
@@ -17,3 +25,24 @@ type (
 		Password string `json:"password"`
 	}
 )
+
+func (u *User) GetRole() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+func (u *User) HasRole(roles ...string) bool {
+	role := u.GetRole()
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func IsKnownRole(role string) bool {
+	return role == RoleUser || role == RoleAuditor || role == RoleAdmin
+}
@@ -3,11 +3,17 @@ package main
 import (
 	"IS/blockchain/blockchain/api"
 	"IS/blockchain/config"
+	db_types "IS/blockchain/database_utils/types"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -32,34 +38,160 @@ func runServer(cfg *config.Config) {
 	router.Use(errorsMiddleware())
 	handleFuncs(router)
 
+	// requests derive from baseCtx, it is cancelled when shutdown starts so SSE and
+	// WebSocket streams end instead of holding Shutdown until the timeout
+	baseCtx, stopStreams := context.WithCancel(context.Background())
+	defer stopStreams()
 	server := &http.Server{
-		Addr:    cfg.HttpAddress + ":" + cfg.HttpPort,
-		Handler: router,
+		Addr:        cfg.HttpAddress + ":" + cfg.HttpPort,
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
 	}
-	stopCh := make(chan struct{})
-	go func(stopCh chan struct{}) {
-		time.Sleep(time.Second)
+	server.RegisterOnShutdown(stopStreams)
+
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	api.AllowWSOrigins(cfg.WSOrigins)
+	chainCtx, stopChain := context.WithCancel(context.Background())
+	chainDone := api.Start(chainCtx, cfg)
+	webhooksCtx, stopWebhooks := context.WithCancel(context.Background())
+	webhooksDone := api.StartWebhooks(webhooksCtx, cfg)
+	botCtx, stopBot := context.WithCancel(context.Background())
+	botDone, err := api.StartTelegramBot(botCtx, cfg)
+	if err != nil {
+		fmt.Println("failed to start telegram bot:", err)
+	}
+	notifierCtx, stopNotifier := context.WithCancel(context.Background())
+	notifierDone := api.StartNotifier(notifierCtx, cfg)
+
+	serverErr := make(chan error, 2)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	grpcServer := api.NewGRPCServer()
+	go func() {
+		listener, err := net.Listen("tcp", cfg.HttpAddress+":"+cfg.GrpcPort)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		serverErr <- grpcServer.Serve(listener)
+	}()
+
+	select {
+	case err := <-serverErr:
+		fmt.Println("failed to run server:", err)
+	case <-time.After(time.Second):
+		fmt.Println("server is running")
 		select {
-		case <-stopCh:
-			fmt.Println("failed to run server")
-		default:
-			fmt.Println("server is running")
+		case err := <-serverErr:
+			fmt.Println(err)
+		case <-signalCtx.Done():
+			fmt.Println("shutting down")
 		}
-	}(stopCh)
-	api.Start()
-	err := server.ListenAndServe()
-	stopCh <- struct{}{}
-	if err != nil {
-		fmt.Println(err)
 	}
+
+	// every stage has its own budget, a slow server doesn't take the time of the chain drain
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// stop accepting requests and wait for in-flight handlers, they need the chain loop alive
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		fmt.Println("http server shutdown:", err)
+	}
+	stopGRPC(shutdownCtx, grpcServer)
+	stopBot()
+	if botDone != nil {
+		select {
+		case <-botDone:
+		case <-shutdownCtx.Done():
+			fmt.Println("telegram bot didn't stop in time")
+		}
+	}
+	stopNotifier()
+	if notifierDone != nil {
+		select {
+		case <-notifierDone:
+		case <-shutdownCtx.Done():
+			fmt.Println("notifier didn't stop in time")
+		}
+	}
+
+	chainTimeout, cancelChain := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelChain()
+	stopChain()
+	select {
+	case <-chainDone:
+	case <-chainTimeout.Done():
+		fmt.Println("chain didn't stop in time")
+	}
+
+	// undelivered webhooks stay pending in the database and are resumed on the next start
+	stopWebhooks()
+	select {
+	case <-webhooksDone:
+	case <-chainTimeout.Done():
+		fmt.Println("webhooks didn't stop in time")
+	}
+
+	dbCtx, cancelDB := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelDB()
+	if err := api.CloseDB(dbCtx); err != nil {
+		fmt.Println("database disconnect:", err)
+	}
+}
+
+// stopGRPC waits for in-flight calls, block streams never end by themselves so they
+// are cut after api.GRPCStopGrace or when ctx is done
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	grace := time.NewTimer(api.GRPCStopGrace)
+	defer grace.Stop()
+	select {
+	case <-stopped:
+		return
+	case <-grace.C:
+	case <-ctx.Done():
+	}
+	server.Stop()
+	<-stopped
 }
 
 func handleFuncs(router *gin.Engine) {
-	router.POST("/register", api.CreateUserReq)
-	router.POST("/sendTx", api.SendTx)
-	router.GET("/getKey", api.GetPublicKeyByUsername)
-	router.GET("/getBalance", api.GetBalanceByBlockNumber)
-	router.GET("/getTxsWithFilters", api.GetTransactionsWithFilters)
+	api.RegisterV1(router)
+
+	// unversioned routes are kept as aliases of /v1
+	router.GET("/health", api.Deprecated("/v1/health"), api.HealthCheck)
+	router.POST("/register", api.Deprecated("/v1/users"), api.CreateUserReq)
+	router.POST("/sendTx", api.Deprecated("/v1/transactions"), api.SendTx)
+	router.POST("/sendTxBatch", api.Deprecated("/v1/transactions/batch"), api.SendTxBatch)
+	router.POST("/simulateTx", api.Deprecated("/v1/transactions/simulate"), api.SimulateTx)
+	router.GET("/getKey", api.Deprecated("/v1/users/{username}/address"), api.GetPublicKeyByUsername)
+	router.GET("/getBalance", api.Deprecated("/v1/accounts/{address}/balance"), api.GetBalanceByBlockNumber)
+	router.GET("/getTxsWithFilters", api.Deprecated("/v1/transactions"), api.Authorize(), api.GetTransactionsWithFilters)
+	router.POST("/grantReadAccess", api.Deprecated("/v1/read-grants"), api.Authorize(), api.GrantReadAccessReq)
+	router.POST("/revokeReadAccess", api.Deprecated("/v1/read-grants/{username}"), api.Authorize(), api.RevokeReadAccessReq)
+	router.GET("/pendingTxs", api.Deprecated("/v1/pending"), api.Authorize(), api.GetOwnPendingTxs)
+	router.POST("/cancelTx", api.Deprecated("/v1/pending/{id}"), api.Authorize(), api.CancelTx)
+	router.POST("/replaceTx", api.Deprecated("/v1/pending/{id}"), api.Authorize(), api.ReplaceTx)
+
+	admin := router.Group("/admin", api.Authorize(db_types.RoleAdmin))
+	admin.GET("/users", api.Deprecated("/v1/admin/users"), api.ListUsers)
+	admin.POST("/setRole", api.Deprecated("/v1/admin/users/{username}/role"), api.SetUserRole)
+	admin.POST("/freeze", api.Deprecated("/v1/admin/frozen/{address}"), api.FreezeAddress)
+	admin.POST("/unfreeze", api.Deprecated("/v1/admin/frozen/{address}"), api.UnfreezeAddress)
+	admin.GET("/mempool", api.Deprecated("/v1/admin/mempool"), api.GetMempool)
+
+	router.POST("/rpc", api.Authorize(), api.JSONRPC)
+
+	router.GET("/audit", api.Deprecated("/v1/audit"), api.Authorize(db_types.RoleAuditor, db_types.RoleAdmin), api.GetAuditLog)
 }
 
 func errorsMiddleware() gin.HandlerFunc {
@@ -68,16 +200,10 @@ func errorsMiddleware() gin.HandlerFunc {
 
 		var nilErr *gin.Error = nil
 		err := c.Errors.Last()
-		if err == nilErr {
+		if err == nilErr || c.Writer.Written() {
 			return
 		}
 
-		errCode := err.Error()
-
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"result":   "error",
-			"err_code": errCode,
-		})
-
+		api.RespondError(c, err.Err)
 	}
 }